@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	gcs "cloud.google.com/go/storage"
+	firebase "firebase.google.com/go"
+	"firebase.google.com/go/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// storageBucket is the Cloud Storage bucket uploads are written to, set with
+// the STORAGE_BUCKET env var.
+var storageBucket = stringFromEnv("STORAGE_BUCKET", "talkit-199f9.appspot.com")
+
+// defaultMaxAvatarBytes bounds the size of an uploaded avatar, unless
+// MAX_AVATAR_BYTES configures another limit.
+const defaultMaxAvatarBytes = 5 << 20
+
+var maxAvatarBytes = intFromEnv("MAX_AVATAR_BYTES", defaultMaxAvatarBytes)
+
+// defaultSignedURLExpiry is how long a signed URL stays valid, unless
+// SIGNED_URL_EXPIRY configures another duration.
+const defaultSignedURLExpiry = 15 * time.Minute
+
+var signedURLExpiry = durationFromEnv("SIGNED_URL_EXPIRY", defaultSignedURLExpiry)
+
+// avatarTypes maps the accepted avatar content types to their extension.
+var avatarTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// objectStorage abstracts the object operations handlers make, so they can
+// run against a stub instead of Cloud Storage. Upload returns the URL the
+// object can be downloaded from, SignedURL a URL only valid until expires.
+type objectStorage interface {
+	Upload(ctx context.Context, object string, contentType string, data []byte) (string, error)
+	SignedURL(object string, expires time.Time) (string, error)
+}
+
+// firebaseStorage is the objectStorage backed by the Firebase bucket.
+type firebaseStorage struct {
+	bucket *gcs.BucketHandle
+}
+
+func newFirebaseStorage(ctx context.Context, app *firebase.App) (objectStorage, error) {
+	client, err := app.Storage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	bucket, err := client.DefaultBucket()
+	if err != nil {
+		return nil, err
+	}
+	return firebaseStorage{bucket: bucket}, nil
+}
+
+func (s firebaseStorage) Upload(ctx context.Context, object string, contentType string, data []byte) (string, error) {
+	// A download token gives a stable public URL, like uploads from the
+	// Firebase client SDKs, even on buckets without public ACLs
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	writer := s.bucket.Object(object).NewWriter(ctx)
+	writer.ContentType = contentType
+	writer.Metadata = map[string]string{"firebaseStorageDownloadTokens": token}
+	if _, err := writer.Write(data); err != nil {
+		writer.Close()
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("https://firebasestorage.googleapis.com/v0/b/%s/o/%s?alt=media&token=%s",
+		writer.Attrs().Bucket, url.PathEscape(object), token), nil
+}
+
+func (s firebaseStorage) SignedURL(object string, expires time.Time) (string, error) {
+	return s.bucket.SignedURL(object, &gcs.SignedURLOptions{
+		Method:  http.MethodGet,
+		Expires: expires,
+		Scheme:  gcs.SigningSchemeV4,
+	})
+}
+
+// randomToken returns a random hex token.
+func randomToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// UsersAvatarAPI is an HTTP Cloud Function uploading the avatar image of a
+// user and storing its URL in the image field.
+func UsersAvatarAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := projectContext(r)
+
+	app, client, ok := initFirebase(w, r)
+	if !ok {
+		return
+	}
+
+	if handleCORS(w, r, http.MethodPost, http.MethodOptions) {
+		return
+	}
+
+	switch method := r.Method; method {
+	case http.MethodPost:
+		token := authorizeRequest(w, app, r)
+		if token == nil {
+			return
+		}
+		storage, err := newFirebaseStorage(ctx, app)
+		if err != nil {
+			logErrorf("Storage init: %v", err)
+			writeInternalError(w)
+			return
+		}
+		uploadAvatar(ctx, storage, storeFor(client), token, w, r)
+	default:
+		writeMethodNotAllowed(w, http.MethodPost, http.MethodOptions)
+	}
+}
+
+// uploadAvatar reads the image sent in the avatar field of a multipart body.
+// Admins may upload the avatar of another user with the uid query parameter.
+func uploadAvatar(ctx context.Context, storage objectStorage, store documentStore, token *auth.Token, w http.ResponseWriter, r *http.Request) {
+	uid := r.URL.Query().Get("uid")
+	if uid == "" {
+		uid = token.UID
+	}
+	if !canModifyUser(token, uid) {
+		writeForbidden(w, "You can only modify your own user")
+		return
+	}
+
+	// Leave room for the multipart framing around the file
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxAvatarBytes)+1<<20)
+	file, _, err := r.FormFile("avatar")
+	if err != nil {
+		writeBadRequest(w, "avatar must be sent as a multipart file")
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, int64(maxAvatarBytes)+1))
+	if err != nil {
+		writeBadRequest(w, "avatar could not be read")
+		return
+	}
+	if len(data) > maxAvatarBytes {
+		writeFieldError(w, &FieldError{Field: "avatar", Message: fmt.Sprintf("must be at most %d bytes", maxAvatarBytes)})
+		return
+	}
+
+	// Trust the bytes rather than the client-declared type
+	contentType := http.DetectContentType(data)
+	extension, ok := avatarTypes[contentType]
+	if !ok {
+		writeFieldError(w, &FieldError{Field: "avatar", Message: "must be a JPEG, PNG, GIF or WebP image"})
+		return
+	}
+
+	// Make sure the user exists before storing anything for it
+	user, err := findDocument(ctx, store, collections.Users, uid)
+	if err != nil {
+		writeFirestoreError(w, "Fetching user failed", err)
+		return
+	}
+	if user == nil {
+		writeNotFound(w, "User not found")
+		return
+	}
+
+	name, err := randomToken()
+	if err != nil {
+		logErrorf("Generating object name failed %v", err)
+		writeInternalError(w)
+		return
+	}
+	imageURL, err := storage.Upload(ctx, "avatars/"+uid+"/"+name+extension, contentType, data)
+	if err != nil {
+		logErrorf("Uploading avatar failed %v", err)
+		writeError(w, errorBadGateway, "Uploading the avatar failed", nil)
+		return
+	}
+
+	err = withRetry(ctx, func() error {
+		return store.Update(ctx, collections.Users, uid, []firestore.Update{
+			{Path: "image", Value: imageURL},
+			{Path: "updatedAt", Value: firestore.ServerTimestamp},
+		})
+	})
+	readCache.Delete(cacheKey(ctx, collections.Users, uid))
+	if status.Code(err) == codes.NotFound {
+		writeNotFound(w, "User not found")
+		return
+	}
+	if err != nil {
+		writeFirestoreError(w, "Document update failed", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", jsonContentType)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"image": imageURL,
+	})
+}
+
+// StorageSignedURLAPI is an HTTP Cloud Function returning a time-limited
+// download URL for a stored object of the caller.
+func StorageSignedURLAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := projectContext(r)
+
+	app, _, ok := initFirebase(w, r)
+	if !ok {
+		return
+	}
+
+	if handleCORS(w, r, http.MethodGet, http.MethodOptions) {
+		return
+	}
+
+	switch method := r.Method; method {
+	case http.MethodGet:
+		token := authorizeRequest(w, app, r)
+		if token == nil {
+			return
+		}
+		storage, err := newFirebaseStorage(ctx, app)
+		if err != nil {
+			logErrorf("Storage init: %v", err)
+			writeInternalError(w)
+			return
+		}
+		getSignedURL(storage, token, w, r)
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodOptions)
+	}
+}
+
+// getSignedURL signs the object given in the object query parameter. Objects
+// are stored under "<kind>/<uid>/", so the second path segment is the owner.
+func getSignedURL(storage objectStorage, token *auth.Token, w http.ResponseWriter, r *http.Request) {
+	object := r.URL.Query().Get("object")
+	segments := strings.Split(object, "/")
+	if len(segments) < 3 || segments[1] == "" || strings.Contains(object, "..") {
+		writeBadRequest(w, "object must be a path like avatars/<uid>/<name>")
+		return
+	}
+	if !canModifyUser(token, segments[1]) {
+		writeForbidden(w, "You can only access your own files")
+		return
+	}
+
+	expiresAt := time.Now().Add(signedURLExpiry)
+	signedURL, err := storage.SignedURL(object, expiresAt)
+	if err != nil {
+		logErrorf("Signing URL failed %v", err)
+		writeInternalError(w)
+		return
+	}
+
+	// Signed URLs are credentials, they must not be cached by intermediaries
+	setPrivateNoStore(w)
+	w.Header().Set("Content-Type", jsonContentType)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"url":       signedURL,
+		"expiresAt": expiresAt,
+	})
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"firebase.google.com/go/auth"
+)
+
+// stubStorage is an objectStorage remembering the objects uploaded to it.
+type stubStorage struct {
+	err     error
+	objects map[string]string
+}
+
+func (s *stubStorage) Upload(ctx context.Context, object string, contentType string, data []byte) (string, error) {
+	if s.err != nil {
+		return "", s.err
+	}
+	if s.objects == nil {
+		s.objects = map[string]string{}
+	}
+	s.objects[object] = contentType
+	return "https://storage.example.com/" + object, nil
+}
+
+func (s *stubStorage) SignedURL(object string, expires time.Time) (string, error) {
+	if s.err != nil {
+		return "", s.err
+	}
+	return "https://storage.example.com/" + object + "?expires=" + expires.UTC().Format(time.RFC3339), nil
+}
+
+// newAvatarRequest returns a multipart request carrying data in its field.
+func newAvatarRequest(target string, field string, data []byte) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile(field, "avatar")
+	part.Write(data)
+	writer.Close()
+
+	r := httptest.NewRequest(http.MethodPost, target, &body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	return r
+}
+
+func TestUploadAvatar(t *testing.T) {
+	restore := maxAvatarBytes
+	maxAvatarBytes = 64
+	t.Cleanup(func() { maxAvatarBytes = restore })
+
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 16)...)
+
+	tests := []struct {
+		name       string
+		uid        string
+		target     string
+		field      string
+		data       []byte
+		storageErr error
+		wantStatus int
+		wantType   string
+	}{
+		{"own avatar", "alice", "/users/avatar", "avatar", png, nil, http.StatusOK, "image/png"},
+		{"avatar of another user", "bob", "/users/avatar?uid=alice", "avatar", png, nil, http.StatusForbidden, ""},
+		{"missing user", "carol", "/users/avatar", "avatar", png, nil, http.StatusNotFound, ""},
+		{"wrong form field", "alice", "/users/avatar", "image", png, nil, http.StatusBadRequest, ""},
+		{"not an image", "alice", "/users/avatar", "avatar", []byte("just some text"), nil, http.StatusBadRequest, ""},
+		{"too large", "alice", "/users/avatar", "avatar", append(png, make([]byte, 64)...), nil, http.StatusBadRequest, ""},
+		{"storage failure", "alice", "/users/avatar", "avatar", png, errors.New("bucket down"), http.StatusBadGateway, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemoryStore()
+			store.Put(collections.Users, "alice", map[string]interface{}{"uid": "alice", "image": "old.png"})
+			storage := &stubStorage{err: tt.storageErr}
+
+			w := httptest.NewRecorder()
+			uploadAvatar(context.Background(), storage, store, &auth.Token{UID: tt.uid}, w, newAvatarRequest(tt.target, tt.field, tt.data))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			image, _ := store.docs[collections.Users+"/alice"]["image"].(string)
+			if tt.wantType == "" {
+				if image != "old.png" || len(storage.objects) != 0 {
+					t.Errorf("image = %s with uploads %v, want nothing stored", image, storage.objects)
+				}
+				return
+			}
+			object := strings.TrimPrefix(image, "https://storage.example.com/")
+			if storage.objects[object] != tt.wantType || !strings.HasPrefix(object, "avatars/alice/") {
+				t.Errorf("image = %s with uploads %v, want the uploaded %s avatar of alice", image, storage.objects, tt.wantType)
+			}
+			if !strings.Contains(w.Body.String(), image) {
+				t.Errorf("body %s doesn't return the image URL", w.Body)
+			}
+		})
+	}
+}
+
+func TestGetSignedURL(t *testing.T) {
+	restore := signedURLExpiry
+	signedURLExpiry = 10 * time.Minute
+	t.Cleanup(func() { signedURLExpiry = restore })
+
+	admin := map[string]interface{}{"admin": true}
+
+	tests := []struct {
+		name       string
+		token      *auth.Token
+		object     string
+		storageErr error
+		wantStatus int
+	}{
+		{"own object", &auth.Token{UID: "alice"}, "avatars/alice/a1.png", nil, http.StatusOK},
+		{"admin", &auth.Token{UID: "root", Claims: admin}, "avatars/alice/a1.png", nil, http.StatusOK},
+		{"object of another user", &auth.Token{UID: "bob"}, "avatars/alice/a1.png", nil, http.StatusForbidden},
+		{"missing object", &auth.Token{UID: "alice"}, "", nil, http.StatusBadRequest},
+		{"no owner segment", &auth.Token{UID: "alice"}, "avatars//a1.png", nil, http.StatusBadRequest},
+		{"path traversal", &auth.Token{UID: "alice"}, "avatars/alice/../bob/a1.png", nil, http.StatusBadRequest},
+		{"signing failure", &auth.Token{UID: "alice"}, "avatars/alice/a1.png", errors.New("no key"), http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/storage/signed-url?object="+tt.object, nil)
+			getSignedURL(&stubStorage{err: tt.storageErr}, tt.token, w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var body struct {
+				URL       string    `json:"url"`
+				ExpiresAt time.Time `json:"expiresAt"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Decoding body: %v", err)
+			}
+			if expiry := body.ExpiresAt.Sub(start); expiry < signedURLExpiry || expiry > signedURLExpiry+time.Second {
+				t.Errorf("expiresAt in %v, want %v", expiry, signedURLExpiry)
+			}
+			if !strings.HasPrefix(body.URL, "https://storage.example.com/"+tt.object+"?expires=") {
+				t.Errorf("url = %s, want the signed URL of %s", body.URL, tt.object)
+			}
+			if got := w.Header().Get("Cache-Control"); !strings.Contains(got, "no-store") {
+				t.Errorf("Cache-Control = %q, want no-store", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// BatchResultType represents the outcome of a single item of a batch write
+type BatchResultType struct {
+	ID      string `json:"id"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// UsersBatchAPI is an HTTP Cloud Function that creates many users at once.
+func UsersBatchAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := projectContext(r)
+
+	app, client, ok := initFirebase(w, r)
+	if !ok {
+		return
+	}
+
+	if handleCORS(w, r, http.MethodPost, http.MethodOptions) {
+		return
+	}
+
+	// Bound the Firestore work so a hung call can't block the request
+	ctx, cancel := context.WithTimeout(ctx, firestoreTimeout)
+	defer cancel()
+
+	switch method := r.Method; method {
+	case http.MethodPost:
+		if !authorizeAdmin(w, app, r) {
+			return
+		}
+		setUsersBatch(ctx, client, w, r)
+	default:
+		writeMethodNotAllowed(w, http.MethodPost, http.MethodOptions)
+	}
+}
+
+func setUsersBatch(ctx context.Context, client *firestore.Client, w http.ResponseWriter, r *http.Request) {
+	if !requireJSON(w, r) {
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		logDebugf("Reading request body failed %v", err)
+		writeBadRequest(w, "Reading the request body failed")
+		return
+	}
+	defer r.Body.Close()
+
+	var newUsers []UsersFieldsType
+
+	err = json.Unmarshal(body, &newUsers)
+	if err != nil {
+		logDebugf("Unmarshalling json failed %v", err)
+		writeDecodeError(w, err)
+		return
+	}
+
+	results := make([]BatchResultType, len(newUsers))
+	jobs := make([]*firestore.BulkWriterJob, len(newUsers))
+
+	bw := client.BulkWriter(ctx)
+	for i := range newUsers {
+		results[i].ID = newUsers[i].ID
+		if newUsers[i].ID == "" {
+			results[i].Error = "missing user ID"
+			continue
+		}
+		if fieldErr := sanitizeUser(&newUsers[i]); fieldErr != nil {
+			results[i].Error = fieldErr.Error()
+			continue
+		}
+
+		// Timestamps are always assigned by the server
+		newUsers[i].CreatedAt = time.Time{}
+		newUsers[i].UpdatedAt = time.Time{}
+
+		job, err := bw.Create(client.Collection(collections.Users).Doc(newUsers[i].ID), &newUsers[i])
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		jobs[i] = job
+		readCache.Delete(cacheKey(ctx, collections.Users, newUsers[i].ID))
+	}
+	bw.End()
+
+	for i, job := range jobs {
+		if job == nil {
+			continue
+		}
+		if _, err := job.Results(); err != nil {
+			logErrorf("Batch create of %s failed %v", results[i].ID, err)
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].Success = true
+	}
+
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(results)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestUsersBatchAPI(t *testing.T) {
+	tests := []struct {
+		name        string
+		uid         string
+		body        string
+		wantStatus  int
+		wantResults []BatchResultType
+	}{
+		{name: "not admin", uid: "alice", body: `[{"ID": "bob", "Name": "Bob"}]`, wantStatus: http.StatusForbidden},
+		{name: "unauthenticated", body: `[{"ID": "bob", "Name": "Bob"}]`, wantStatus: http.StatusForbidden},
+		{name: "not a list", uid: "admin", body: `{"ID": "bob"}`, wantStatus: http.StatusBadRequest},
+		{name: "empty", uid: "admin", body: `[]`, wantStatus: http.StatusOK, wantResults: []BatchResultType{}},
+		{name: "invalid items", uid: "admin", body: `[{"Name": "Nobody"}, {"ID": "bob", "Price": -1}, {"ID": "carol", "Slug": "Carol!"}]`,
+			wantStatus: http.StatusOK, wantResults: []BatchResultType{
+				{ID: "", Error: "missing user ID"},
+				{ID: "bob", Error: "invalid field price: must not be negative"},
+				{ID: "carol", Error: "invalid field slug: must only contain lowercase letters, digits and hyphens"},
+			}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeFirebase(t, newOfflineClient(t), nil)
+
+			w := serveJSON(UsersBatchAPI, http.MethodPost, "/users/batch", tt.uid, tt.body)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantResults == nil {
+				return
+			}
+			var results []BatchResultType
+			if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+				t.Fatalf("Decoding results: %v", err)
+			}
+			if len(results) != len(tt.wantResults) {
+				t.Fatalf("results = %+v, want %+v", results, tt.wantResults)
+			}
+			for i, want := range tt.wantResults {
+				if results[i] != want {
+					t.Errorf("result %d = %+v, want %+v", i, results[i], want)
+				}
+			}
+		})
+	}
+}
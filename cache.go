@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// defaultCacheTTL is used when CACHE_TTL (e.g. "30s") is not set or invalid.
+const defaultCacheTTL = 30 * time.Second
+
+// readCache holds recently read documents keyed by project, collection and
+// document ID.
+var readCache = newTTLCache(durationFromEnv("CACHE_TTL", defaultCacheTTL))
+
+type cacheEntry struct {
+	value     interface{}
+	expiresAt time.Time
+}
+
+// ttlCache is a small in-memory cache whose entries expire after a fixed TTL.
+type ttlCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]cacheEntry
+}
+
+func newTTLCache(ttl time.Duration) *ttlCache {
+	return &ttlCache{
+		ttl:     ttl,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+// Get returns the cached value for key if present and not expired.
+func (c *ttlCache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// Set stores value under key for the cache TTL.
+func (c *ttlCache) Set(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = cacheEntry{value: value, expiresAt: time.Now().Add(c.ttl)}
+}
+
+// Delete evicts key from the cache.
+func (c *ttlCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, key)
+}
+
+// cacheKey builds the cache key of a document in the project carried by ctx.
+func cacheKey(ctx context.Context, collection string, id string) string {
+	return projectFromContext(ctx) + "/" + collection + "/" + id
+}
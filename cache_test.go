@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTTLCache(t *testing.T) {
+	tests := []struct {
+		name      string
+		ttl       time.Duration
+		evict     bool
+		wantFound bool
+	}{
+		{"fresh", time.Minute, false, true},
+		{"expired", -time.Second, false, false},
+		{"deleted", time.Minute, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := newTTLCache(tt.ttl)
+			cache.Set("Users/alice", "Alice")
+			if tt.evict {
+				cache.Delete("Users/alice")
+			}
+
+			value, found := cache.Get("Users/alice")
+
+			if found != tt.wantFound {
+				t.Fatalf("found = %v, want %v", found, tt.wantFound)
+			}
+			if found && value != "Alice" {
+				t.Errorf("value = %v, want Alice", value)
+			}
+			if _, found := cache.Get("Users/bob"); found {
+				t.Error("found a key never set")
+			}
+		})
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	other := context.WithValue(context.Background(), projectContextKey{}, "other-project")
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{"default project", context.Background(), defaultProjectID + "/Users/alice"},
+		{"other project", other, "other-project/Users/alice"},
+	}
+
+	for _, tt := range tests {
+		if got := cacheKey(tt.ctx, "Users", "alice"); got != tt.want {
+			t.Errorf("%s: cacheKey = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ChatFrameType is a frame sent by a client over the chat WebSocket.
+type ChatFrameType struct {
+	Type   string `json:"type"`
+	ChatID string `json:"chatId"`
+}
+
+// TypingEventType is the ephemeral event relayed to the other participants
+// of a chat while someone is typing. It is never persisted.
+type TypingEventType struct {
+	Type   string `json:"type"`
+	ChatID string `json:"chatId"`
+	UID    string `json:"uid"`
+}
+
+// chatConn is a chat WebSocket connection. Writes are serialized since the
+// connection supports a single concurrent writer.
+type chatConn struct {
+	conn *websocket.Conn
+	uid  string
+	mu   sync.Mutex
+}
+
+func (c *chatConn) writeJSON(v interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return c.conn.WriteJSON(v)
+}
+
+// chatHub tracks the connections open on each chat of this instance, so
+// ephemeral events only reach participants connected to the same process.
+type chatHub struct {
+	mu    sync.Mutex
+	chats map[string]map[*chatConn]bool
+}
+
+var hub = &chatHub{chats: map[string]map[*chatConn]bool{}}
+
+func (h *chatHub) join(chatID string, c *chatConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.chats[chatID] == nil {
+		h.chats[chatID] = map[*chatConn]bool{}
+	}
+	h.chats[chatID][c] = true
+}
+
+func (h *chatHub) leave(chatID string, c *chatConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.chats[chatID], c)
+	if len(h.chats[chatID]) == 0 {
+		delete(h.chats, chatID)
+	}
+}
+
+// broadcast sends v to every connection on the chat except from.
+func (h *chatHub) broadcast(chatID string, from *chatConn, v interface{}) {
+	h.mu.Lock()
+	peers := make([]*chatConn, 0, len(h.chats[chatID]))
+	for c := range h.chats[chatID] {
+		if c != from {
+			peers = append(peers, c)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, c := range peers {
+		if err := c.writeJSON(v); err != nil {
+			logWarnf("Writing to chat %s socket failed %v", chatID, err)
+		}
+	}
+}
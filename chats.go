@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"firebase.google.com/go/auth"
+)
+
+// ChatFieldsType defines the structure of the fields in a Chat from the Chats collection.
+type ChatFieldsType struct {
+	ID           string    `firestore:"uid" json:"uid"`
+	Name         string    `firestore:"displayName" json:"displayName"`
+	Participants []string  `firestore:"participants" json:"participants"`
+	CreatedAt    time.Time `firestore:"createdAt,serverTimestamp" json:"createdAt"`
+}
+
+// ChatsAPI is an HTTP Cloud Function creating chats between existing users.
+func ChatsAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := projectContext(r)
+
+	app, client, ok := initFirebase(w, r)
+	if !ok {
+		return
+	}
+
+	if handleCORS(w, r, http.MethodPost, http.MethodOptions) {
+		return
+	}
+
+	// Bound the Firestore work so a hung call can't block the request
+	ctx, cancel := context.WithTimeout(ctx, firestoreTimeout)
+	defer cancel()
+
+	switch method := r.Method; method {
+	case http.MethodPost:
+		token := authorizeRequest(w, app, r)
+		if token == nil {
+			return
+		}
+		withIdempotency(w, r, token, func(w http.ResponseWriter) {
+			setChats(ctx, client, token, w, r)
+		})
+	default:
+		writeMethodNotAllowed(w, http.MethodPost, http.MethodOptions)
+	}
+}
+
+func setChats(ctx context.Context, client *firestore.Client, token *auth.Token, w http.ResponseWriter, r *http.Request) {
+	if !requireJSON(w, r) {
+		return
+	}
+
+	defer r.Body.Close()
+
+	var newChat ChatFieldsType
+
+	err := decodeStrict(r.Body, &newChat)
+	if err != nil {
+		logDebugf("Unmarshalling json failed %v", err)
+		writeDecodeError(w, err)
+		return
+	}
+
+	if len(newChat.Participants) == 0 {
+		writeFieldError(w, &FieldError{Field: "participants", Message: "is required"})
+		return
+	}
+	if containsString(newChat.Participants, "") {
+		writeFieldError(w, &FieldError{Field: "participants", Message: "must not contain empty uids"})
+		return
+	}
+	if !requireClaim(token, "admin") && !containsString(newChat.Participants, token.UID) {
+		writeForbidden(w, "You can only create chats you participate in")
+		return
+	}
+
+	unknown, err := unknownUsers(ctx, client, newChat.Participants)
+	if err != nil {
+		writeFirestoreError(w, "Reading participants failed", err)
+		return
+	}
+	if len(unknown) > 0 {
+		writeError(w, errorBadRequest, "participants must be existing users", map[string]interface{}{
+			"field":   "participants",
+			"unknown": unknown,
+		})
+		return
+	}
+
+	docRef := client.Collection(collections.Chats).NewDoc()
+	newChat.ID = docRef.ID
+	newChat.CreatedAt = time.Time{}
+
+	err = withWriteRetry(ctx, func() error {
+		_, err := docRef.Create(ctx, &newChat)
+		return err
+	})
+	if err != nil {
+		writeFirestoreError(w, "Collection update failed", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"uid": newChat.ID,
+	})
+}
+
+// unknownUsers returns the uids that have no document in the Users
+// collection, fetching them all in a single batched read.
+func unknownUsers(ctx context.Context, client *firestore.Client, uids []string) ([]string, error) {
+	refs := make([]*firestore.DocumentRef, len(uids))
+	for i, uid := range uids {
+		refs[i] = client.Collection(collections.Users).Doc(uid)
+	}
+
+	docs, err := client.GetAll(ctx, refs)
+	if err != nil {
+		return nil, err
+	}
+
+	var unknown []string
+	for i, doc := range docs {
+		if !doc.Exists() {
+			unknown = append(unknown, uids[i])
+		}
+	}
+	return unknown, nil
+}
+
+// containsString reports whether values holds s.
+func containsString(values []string, s string) bool {
+	for _, value := range values {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
+
+// authorizeChat checks that the token owner participates in the chat, admins
+// being allowed into every chat. It returns false after writing a 404
+// response for unknown chats, a 403 one for anyone else, or the error of the
+// lookup.
+func authorizeChat(ctx context.Context, store documentStore, token *auth.Token, chatID string, w http.ResponseWriter) bool {
+	chat, err := findDocument(ctx, store, collections.Chats, chatID)
+	if err != nil {
+		writeFirestoreError(w, "Reading chat failed", err)
+		return false
+	}
+	if chat == nil {
+		writeNotFound(w, "Chat not found")
+		return false
+	}
+	if requireClaim(token, "admin") {
+		return true
+	}
+
+	participants, _ := chat["participants"].([]interface{})
+	for _, participant := range participants {
+		if participant == token.UID {
+			return true
+		}
+	}
+	writeForbidden(w, "You can only access chats you participate in")
+	return false
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"firebase.google.com/go/auth"
+)
+
+func TestAuthorizeChat(t *testing.T) {
+	tests := []struct {
+		name       string
+		token      *auth.Token
+		chatID     string
+		err        error
+		want       bool
+		wantStatus int
+	}{
+		{"participant", &auth.Token{UID: "alice"}, "chat1", nil, true, http.StatusOK},
+		{"admin", &auth.Token{UID: "root", Claims: map[string]interface{}{"admin": true}}, "chat1", nil, true, http.StatusOK},
+		{"outsider", &auth.Token{UID: "mallory"}, "chat1", nil, false, http.StatusForbidden},
+		{"unknown chat", &auth.Token{UID: "alice"}, "chat2", nil, false, http.StatusNotFound},
+		{"store failure", &auth.Token{UID: "alice"}, "chat1", errors.New("backend down"), false, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemoryStore()
+			store.Put(collections.Chats, "chat1", map[string]interface{}{"participants": []interface{}{"alice", "bob"}})
+			store.Err = tt.err
+
+			w := httptest.NewRecorder()
+			if got := authorizeChat(context.Background(), store, tt.token, tt.chatID, w); got != tt.want {
+				t.Errorf("authorizeChat = %v, want %v", got, tt.want)
+			}
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
+
+func TestContainsString(t *testing.T) {
+	tests := []struct {
+		values []string
+		s      string
+		want   bool
+	}{
+		{[]string{"alice", "bob"}, "bob", true},
+		{[]string{"alice", "bob"}, "carol", false},
+		{[]string{"alice", ""}, "", true},
+		{nil, "alice", false},
+	}
+
+	for _, tt := range tests {
+		if got := containsString(tt.values, tt.s); got != tt.want {
+			t.Errorf("containsString(%v, %q) = %v, want %v", tt.values, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestChatsAPIValidation(t *testing.T) {
+	tests := []struct {
+		name       string
+		uid        string
+		body       string
+		wantStatus int
+	}{
+		{"no participants", "alice", `{"participants": []}`, http.StatusBadRequest},
+		{"empty participant", "alice", `{"participants": ["alice", ""]}`, http.StatusBadRequest},
+		{"without the caller", "alice", `{"participants": ["bob", "carol"]}`, http.StatusForbidden},
+		{"unknown field", "alice", `{"participants": ["alice"], "topic": "talks"}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeFirebase(t, newOfflineClient(t), nil)
+
+			w := serveJSON(ChatsAPI, http.MethodPost, "/chats", tt.uid, tt.body)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	firebase "firebase.google.com/go"
+	"firebase.google.com/go/auth"
+)
+
+// grantableClaims lists the custom claims admins may set on a user.
+var grantableClaims = map[string]bool{
+	"admin":   true,
+	"premium": true,
+}
+
+// ClaimsType represents the body expected structure of a claims http call.
+// Claims are merged into the ones the user already has; false revokes one.
+type ClaimsType struct {
+	ID     string          `json:"uid"`
+	Claims map[string]bool `json:"claims"`
+}
+
+// UsersClaimsAPI is an HTTP Cloud Function letting admins grant roles to users
+// through custom claims.
+func UsersClaimsAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := projectContext(r)
+
+	app, _, ok := initFirebase(w, r)
+	if !ok {
+		return
+	}
+
+	if handleCORS(w, r, http.MethodPost, http.MethodOptions) {
+		return
+	}
+
+	switch method := r.Method; method {
+	case http.MethodPost:
+		if !authorizeAdmin(w, app, r) {
+			return
+		}
+		setClaims(ctx, app, w, r)
+	default:
+		writeMethodNotAllowed(w, http.MethodPost, http.MethodOptions)
+	}
+}
+
+func setClaims(ctx context.Context, app *firebase.App, w http.ResponseWriter, r *http.Request) {
+	if !requireJSON(w, r) {
+		return
+	}
+
+	var Body ClaimsType
+
+	err := decodeStrict(r.Body, &Body)
+	if err != nil {
+		logDebugf("Unmarshalling json failed %v", err)
+		writeDecodeError(w, err)
+		return
+	}
+	defer r.Body.Close()
+
+	if Body.ID == "" {
+		writeBadRequest(w, "uid is required")
+		return
+	}
+	if len(Body.Claims) == 0 {
+		writeFieldError(w, &FieldError{Field: "claims", Message: "is required"})
+		return
+	}
+	for claim := range Body.Claims {
+		if !grantableClaims[claim] {
+			writeFieldError(w, &FieldError{Field: "claims", Message: fmt.Sprintf("claim %q can't be granted", claim)})
+			return
+		}
+	}
+
+	authClient, err := app.Auth(ctx)
+	if err != nil {
+		logErrorf("error getting Auth client: %v", err)
+		writeInternalError(w)
+		return
+	}
+
+	// Setting claims replaces them all, so merge with the current ones
+	user, err := authClient.GetUser(ctx, Body.ID)
+	if auth.IsUserNotFound(err) {
+		writeNotFound(w, "User not found")
+		return
+	}
+	if err != nil {
+		logErrorf("Reading user failed %v", err)
+		writeInternalError(w)
+		return
+	}
+
+	if err := authClient.SetCustomUserClaims(ctx, Body.ID, mergeClaims(user.CustomClaims, Body.Claims)); err != nil {
+		logErrorf("Setting custom claims failed %v", err)
+		writeInternalError(w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// mergeClaims applies changes to a copy of the current custom claims, granting
+// the claims set to true and removing the ones set to false.
+func mergeClaims(current map[string]interface{}, changes map[string]bool) map[string]interface{} {
+	claims := map[string]interface{}{}
+	for claim, value := range current {
+		claims[claim] = value
+	}
+	for claim, value := range changes {
+		if value {
+			claims[claim] = true
+		} else {
+			delete(claims, claim)
+		}
+	}
+	return claims
+}
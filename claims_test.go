@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestMergeClaims(t *testing.T) {
+	tests := []struct {
+		name    string
+		current map[string]interface{}
+		changes map[string]bool
+		want    map[string]interface{}
+	}{
+		{"first claim", nil, map[string]bool{"premium": true}, map[string]interface{}{"premium": true}},
+		{"keeps other claims", map[string]interface{}{"admin": true, "team": "talks"}, map[string]bool{"premium": true},
+			map[string]interface{}{"admin": true, "team": "talks", "premium": true}},
+		{"revokes a claim", map[string]interface{}{"admin": true, "premium": true}, map[string]bool{"admin": false},
+			map[string]interface{}{"premium": true}},
+		{"revokes a missing claim", map[string]interface{}{}, map[string]bool{"admin": false}, map[string]interface{}{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var before map[string]interface{}
+			if tt.current != nil {
+				before = mergeClaims(tt.current, nil)
+			}
+
+			if got := mergeClaims(tt.current, tt.changes); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeClaims() = %v, want %v", got, tt.want)
+			}
+			if tt.current != nil && !reflect.DeepEqual(tt.current, before) {
+				t.Errorf("current claims changed to %v", tt.current)
+			}
+		})
+	}
+}
+
+func TestUsersClaimsAPI(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		uid        string
+		body       string
+		wantStatus int
+	}{
+		{"unsupported method", http.MethodGet, "admin", "", http.StatusMethodNotAllowed},
+		{"unauthenticated", http.MethodPost, "", `{"uid": "alice", "claims": {"premium": true}}`, http.StatusForbidden},
+		{"non-admin", http.MethodPost, "alice", `{"uid": "alice", "claims": {"admin": true}}`, http.StatusForbidden},
+		{"without uid", http.MethodPost, "admin", `{"claims": {"premium": true}}`, http.StatusBadRequest},
+		{"without claims", http.MethodPost, "admin", `{"uid": "alice"}`, http.StatusBadRequest},
+		{"claim outside the allowlist", http.MethodPost, "admin", `{"uid": "alice", "claims": {"premium": true, "owner": true}}`, http.StatusBadRequest},
+		{"non-boolean claim", http.MethodPost, "admin", `{"uid": "alice", "claims": {"premium": "yes"}}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeFirebase(t, nil, nil)
+
+			w := serveJSON(UsersClaimsAPI, tt.method, "/users/claims", tt.uid, tt.body)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
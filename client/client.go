@@ -0,0 +1,215 @@
+// Package client is a typed HTTP client for the Talkit backend.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TokenSource returns the Firebase ID token sent with authenticated calls.
+type TokenSource func(ctx context.Context) (string, error)
+
+// Client calls the Talkit backend at BaseURL.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	// Token authenticates the calls. Without it calls are anonymous.
+	Token TokenSource
+}
+
+// New returns a Client for the backend at baseURL authenticating with token.
+func New(baseURL string, token TokenSource) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		Token:      token,
+	}
+}
+
+// User is a user as returned by the backend. Price is an exact decimal
+// amount in Currency, e.g. "9.99".
+type User struct {
+	ID          string      `json:"uid"`
+	Name        string      `json:"displayName"`
+	Price       json.Number `json:"price"`
+	Currency    string      `json:"currency"`
+	Type        string      `json:"type"`
+	Year        string      `json:"year"`
+	Image       string      `json:"image"`
+	Description string      `json:"description"`
+	Slug        string      `json:"slug"`
+	CreatedAt   time.Time   `json:"createdAt"`
+	UpdatedAt   time.Time   `json:"updatedAt"`
+}
+
+// userInput is the body the backend decodes users from, keyed by the field
+// names of the server-side type rather than its Firestore names.
+type userInput struct {
+	ID          string
+	Name        string
+	Price       json.Number
+	Currency    string
+	Type        string
+	Year        string
+	Image       string
+	Description string
+	Slug        string
+}
+
+func newUserInput(u User) userInput {
+	return userInput{
+		ID:          u.ID,
+		Name:        u.Name,
+		Price:       u.Price,
+		Currency:    u.Currency,
+		Type:        u.Type,
+		Year:        u.Year,
+		Image:       u.Image,
+		Description: u.Description,
+		Slug:        u.Slug,
+	}
+}
+
+// Subscription is a user's subscription as returned by the backend. Cost is
+// an exact decimal amount in Currency, e.g. "9.99".
+type Subscription struct {
+	ID              string      `json:"uid"`
+	Expired         bool        `json:"expired"`
+	SuscriptionType string      `json:"suscriptionType"`
+	Cost            json.Number `json:"cost"`
+	Currency        string      `json:"currency"`
+	ExpireAt        time.Time   `json:"expireAt"`
+	CreatedAt       string      `json:"createdAt"`
+}
+
+// Me is the profile and subscription of the authenticated user.
+type Me struct {
+	User         User          `json:"user"`
+	Subscription *Subscription `json:"suscription"`
+}
+
+// Error is the error envelope returned by the backend. Responses failing
+// without an envelope only carry their StatusCode.
+type Error struct {
+	Code       string      `json:"error"`
+	StatusCode int         `json:"statusCode"`
+	Message    string      `json:"message"`
+	Data       interface{} `json:"data"`
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("talkit: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("talkit: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// GetUser returns the user with the given uid.
+func (c *Client) GetUser(ctx context.Context, uid string) (*User, error) {
+	var user User
+	err := c.do(ctx, http.MethodGet, "/users?uid="+url.QueryEscape(uid), nil, &user)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// CreateUser creates u and returns the user as stored, timestamps included.
+// An empty u.ID defaults to the caller's uid, or is assigned by the backend
+// when the caller is an admin.
+func (c *Client) CreateUser(ctx context.Context, u User) (*User, error) {
+	var user User
+	err := c.do(ctx, http.MethodPost, "/users", newUserInput(u), &user)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// UpdateUser replaces the user u.ID with u.
+func (c *Client) UpdateUser(ctx context.Context, u User) error {
+	return c.do(ctx, http.MethodPut, "/users", newUserInput(u), nil)
+}
+
+// DeleteUser deletes the user with the given uid.
+func (c *Client) DeleteUser(ctx context.Context, uid string) error {
+	body := map[string]string{"id": uid, "confirm": uid}
+	return c.do(ctx, http.MethodDelete, "/users", body, nil)
+}
+
+// GetSubscription returns the subscription of the user with the given uid.
+func (c *Client) GetSubscription(ctx context.Context, uid string) (*Subscription, error) {
+	var subscription Subscription
+	err := c.do(ctx, http.MethodGet, "/suscriptions?uid="+url.QueryEscape(uid), nil, &subscription)
+	if err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+// GetMe returns the profile and subscription of the authenticated user.
+func (c *Client) GetMe(ctx context.Context) (*Me, error) {
+	var me Me
+	if err := c.do(ctx, http.MethodGet, "/me", nil, &me); err != nil {
+		return nil, err
+	}
+	return &me, nil
+}
+
+// do sends body as JSON and decodes the response into out unless it's nil.
+// Non-2xx responses are returned as an *Error.
+func (c *Client) do(ctx context.Context, method string, path string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != nil {
+		token, err := c.Token(ctx)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", token)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &Error{}
+		// Not every failure carries an envelope, the status is enough then
+		json.NewDecoder(resp.Body).Decode(apiErr)
+		apiErr.StatusCode = resp.StatusCode
+		return apiErr
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
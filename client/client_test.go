@@ -0,0 +1,96 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestErrorEnvelope(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		want        Error
+		wantMessage string
+	}{
+		{
+			name:        "envelope",
+			status:      http.StatusNotFound,
+			contentType: "application/json",
+			body:        `{"error": "NOT_FOUND", "statusCode": 404, "message": "User not found", "data": {"uid": "bob"}}`,
+			want:        Error{Code: "NOT_FOUND", StatusCode: http.StatusNotFound, Message: "User not found"},
+			wantMessage: "talkit: 404 NOT_FOUND: User not found",
+		},
+		{
+			name:        "server error without envelope",
+			status:      http.StatusBadGateway,
+			contentType: "text/html",
+			body:        "<html>Bad Gateway</html>",
+			want:        Error{StatusCode: http.StatusBadGateway},
+			wantMessage: "talkit: 502 Bad Gateway",
+		},
+		{
+			name:        "empty body",
+			status:      http.StatusInternalServerError,
+			want:        Error{StatusCode: http.StatusInternalServerError},
+			wantMessage: "talkit: 500 Internal Server Error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			}))
+			defer server.Close()
+
+			_, err := New(server.URL, nil).GetUser(context.Background(), "bob")
+
+			var apiErr *Error
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("GetUser error = %v, want an *Error", err)
+			}
+			if apiErr.Code != tt.want.Code || apiErr.StatusCode != tt.want.StatusCode || apiErr.Message != tt.want.Message {
+				t.Errorf("error = %+v, want %+v", apiErr, tt.want)
+			}
+			if apiErr.Error() != tt.wantMessage {
+				t.Errorf("Error() = %q, want %q", apiErr.Error(), tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestRequestHeaders(t *testing.T) {
+	var got http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+	}))
+	defer server.Close()
+
+	token := func(ctx context.Context) (string, error) { return "secret", nil }
+	if err := New(server.URL+"/", token).UpdateUser(context.Background(), User{ID: "alice"}); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if got.Get("Authorization") != "secret" || got.Get("Content-Type") != "application/json" {
+		t.Errorf("headers = %v, want the token and a JSON body", got)
+	}
+}
+
+func TestTokenSourceError(t *testing.T) {
+	tokenErr := errors.New("signed out")
+	token := func(ctx context.Context) (string, error) { return "", tokenErr }
+
+	_, err := New("http://127.0.0.1:0", token).GetMe(context.Background())
+	if !errors.Is(err, tokenErr) {
+		t.Errorf("GetMe error = %v, want %v", err, tokenErr)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+)
+
+// gzipMinSize is the smallest response body worth compressing.
+const gzipMinSize = 1024
+
+// gzipMiddleware compresses responses for clients that accept gzip. Bodies
+// smaller than gzipMinSize are sent as is.
+func gzipMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// WebSocket handshakes need the raw connection
+		if !acceptsGzip(r) || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		gw := &gzipResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		defer gw.Close()
+
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether the request's Accept-Encoding allows gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		encoding = strings.TrimSpace(strings.Split(encoding, ";")[0])
+		if encoding == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter buffers the start of the body to decide whether it's
+// large enough to compress, then either gzips or passes it through.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	decided     bool
+	buf         []byte
+	gz          *gzip.Writer
+}
+
+func (gw *gzipResponseWriter) WriteHeader(code int) {
+	if gw.wroteHeader {
+		return
+	}
+	gw.wroteHeader = true
+	gw.status = code
+}
+
+func (gw *gzipResponseWriter) Write(b []byte) (int, error) {
+	gw.wroteHeader = true
+	if gw.decided {
+		if gw.gz != nil {
+			return gw.gz.Write(b)
+		}
+		return gw.ResponseWriter.Write(b)
+	}
+
+	gw.buf = append(gw.buf, b...)
+	if len(gw.buf) >= gzipMinSize {
+		if err := gw.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// decide sends the header and the buffered body, compressing them when
+// compress is set and the response is eligible.
+func (gw *gzipResponseWriter) decide(compress bool) error {
+	gw.decided = true
+	header := gw.ResponseWriter.Header()
+
+	// Event streams are flushed per event and already encoded responses stay untouched
+	if strings.HasPrefix(header.Get("Content-Type"), "text/event-stream") || header.Get("Content-Encoding") != "" {
+		compress = false
+	}
+
+	if compress {
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		gw.gz = gzip.NewWriter(gw.ResponseWriter)
+	}
+	gw.ResponseWriter.WriteHeader(gw.status)
+
+	if len(gw.buf) == 0 {
+		return nil
+	}
+	var err error
+	if gw.gz != nil {
+		_, err = gw.gz.Write(gw.buf)
+	} else {
+		_, err = gw.ResponseWriter.Write(gw.buf)
+	}
+	gw.buf = nil
+	return err
+}
+
+// Flush sends what was written so far. A stream flushed before reaching
+// gzipMinSize is compressed, since more data is expected to follow.
+func (gw *gzipResponseWriter) Flush() {
+	if !gw.decided {
+		gw.decide(len(gw.buf) > 0)
+	}
+	if gw.gz != nil {
+		gw.gz.Flush()
+	}
+	if flusher, ok := gw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Close writes any pending data once the handler is done.
+func (gw *gzipResponseWriter) Close() {
+	if !gw.decided {
+		// Small or empty bodies aren't worth compressing
+		if !gw.wroteHeader {
+			return
+		}
+		gw.decide(false)
+	}
+	if gw.gz != nil {
+		gw.gz.Close()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (gw *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return gw.ResponseWriter
+}
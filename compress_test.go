@@ -0,0 +1,92 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGzipMiddleware(t *testing.T) {
+	large := strings.Repeat("talkit ", gzipMinSize)
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		upgrade        string
+		contentType    string
+		encoding       string
+		status         int
+		body           string
+		wantGzip       bool
+	}{
+		{name: "large body", acceptEncoding: "gzip", body: large, wantGzip: true},
+		{name: "large body with status", acceptEncoding: "br;q=1.0, gzip;q=0.8", status: http.StatusCreated, body: large, wantGzip: true},
+		{name: "small body", acceptEncoding: "gzip", body: `{"uid": "alice"}`},
+		{name: "empty body", acceptEncoding: "gzip", status: http.StatusNoContent},
+		{name: "gzip not accepted", acceptEncoding: "br", body: large},
+		{name: "no Accept-Encoding", body: large},
+		{name: "WebSocket upgrade", acceptEncoding: "gzip", upgrade: "websocket", body: large},
+		{name: "already encoded", acceptEncoding: "gzip", encoding: "br", body: large},
+		{name: "event stream", acceptEncoding: "gzip", contentType: "text/event-stream", body: large},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := gzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				if tt.encoding != "" {
+					w.Header().Set("Content-Encoding", tt.encoding)
+				}
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+				}
+				// Written in two parts so buffering across writes is exercised
+				io.WriteString(w, tt.body[:len(tt.body)/2])
+				io.WriteString(w, tt.body[len(tt.body)/2:])
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/users/list", nil)
+			if tt.acceptEncoding != "" {
+				r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			if tt.upgrade != "" {
+				r.Header.Set("Upgrade", tt.upgrade)
+			}
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, r)
+
+			wantStatus := tt.status
+			if wantStatus == 0 {
+				wantStatus = http.StatusOK
+			}
+			if w.Code != wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, wantStatus)
+			}
+			gzipped := w.Header().Get("Content-Encoding") == "gzip"
+			if gzipped != tt.wantGzip {
+				t.Fatalf("gzipped = %v, want %v", gzipped, tt.wantGzip)
+			}
+
+			body := w.Body.String()
+			if gzipped {
+				gz, err := gzip.NewReader(w.Body)
+				if err != nil {
+					t.Fatalf("Reading gzip body: %v", err)
+				}
+				decoded, err := io.ReadAll(gz)
+				if err != nil {
+					t.Fatalf("Reading gzip body: %v", err)
+				}
+				body = string(decoded)
+			}
+			if body != tt.body {
+				t.Errorf("body has %d bytes, want %d", len(body), len(tt.body))
+			}
+		})
+	}
+}
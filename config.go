@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/option"
+)
+
+// Default HTTP server timeouts, overridable through the environment
+const (
+	defaultReadTimeout  = 10 * time.Second
+	defaultWriteTimeout = 10 * time.Second
+	defaultIdleTimeout  = 60 * time.Second
+)
+
+// defaultCORSAllowedHeaders are the request headers browsers may send,
+// Authorization included so authenticated preflights succeed.
+const defaultCORSAllowedHeaders = "Content-Type, Authorization, Idempotency-Key, If-None-Match, If-Unmodified-Since, X-Firebase-Project"
+
+// corsAllowedHeaders is overridable with the CORS_ALLOWED_HEADERS env var.
+var corsAllowedHeaders = stringFromEnv("CORS_ALLOWED_HEADERS", defaultCORSAllowedHeaders)
+
+// checkRevokedTokens makes authorizeRequest reject revoked ID tokens, at the
+// cost of an extra Auth lookup per request. Disable it with CHECK_REVOKED_TOKENS.
+var checkRevokedTokens = boolFromEnv("CHECK_REVOKED_TOKENS", true)
+
+// defaultTimezone is the zone subscription timestamps are computed in.
+const defaultTimezone = "America/Buenos_Aires"
+
+// defaultLocation is overridable with the DEFAULT_TIMEZONE env var.
+var defaultLocation = loadLocation(stringFromEnv("DEFAULT_TIMEZONE", defaultTimezone))
+
+// loadLocation loads the named IANA zone, falling back to UTC when it's
+// unknown or the zone database is missing.
+func loadLocation(name string) *time.Location {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		logWarnf("Invalid DEFAULT_TIMEZONE %q, using UTC: %v", name, err)
+		return time.UTC
+	}
+	return location
+}
+
+// defaultPublicCacheMaxAge is how long public reads may be cached, unless
+// PUBLIC_CACHE_MAX_AGE configures another duration. Zero disables caching.
+const defaultPublicCacheMaxAge = 60 * time.Second
+
+var publicCacheMaxAge = durationFromEnv("PUBLIC_CACHE_MAX_AGE", defaultPublicCacheMaxAge)
+
+// collectionNames holds the names of the Firestore collections.
+type collectionNames struct {
+	Users        string
+	Suscriptions string
+	Chats        string
+	Messages     string
+	Groups       string
+	Talks        string
+	ReadReceipts string
+}
+
+// newCollectionNames returns the collection names, each starting with prefix.
+func newCollectionNames(prefix string) collectionNames {
+	return collectionNames{
+		Users:        prefix + "Users",
+		Suscriptions: prefix + "Suscriptions",
+		Chats:        prefix + "Chats",
+		Messages:     prefix + "Messages",
+		Groups:       prefix + "Groups",
+		Talks:        prefix + "Talks",
+		ReadReceipts: prefix + "ReadReceipts",
+	}
+}
+
+// collections are the collection names in use. Setting COLLECTION_PREFIX,
+// e.g. to "staging_", lets several deployments share a database.
+var collections = newCollectionNames(stringFromEnv("COLLECTION_PREFIX", ""))
+
+// defaultFreeTrialDays is the length of the free trial given to new users.
+const defaultFreeTrialDays = 84
+
+// enableFreeTrial makes HandleUserCreate give new users a free trial. Disable
+// it with ENABLE_FREE_TRIAL on deployments that sell every subscription.
+var enableFreeTrial = boolFromEnv("ENABLE_FREE_TRIAL", true)
+
+// stringFromEnv reads the named env var, falling back to def when it's unset.
+func stringFromEnv(name string, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+// intFromEnv reads an integer from the named env var, falling back to def
+// when it's unset or invalid.
+func intFromEnv(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		logWarnf("Invalid %s %q, using %d", name, value, def)
+		return def
+	}
+	return n
+}
+
+// boolFromEnv reads a boolean ("true", "1", "false", ...) from the named env
+// var, falling back to def when it's unset or invalid.
+func boolFromEnv(name string, def bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		logWarnf("Invalid %s %q, using %t", name, value, def)
+		return def
+	}
+	return b
+}
+
+// durationFromEnv reads a duration (e.g. "30s") from the named env var,
+// falling back to def when it's unset or invalid.
+func durationFromEnv(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		logWarnf("Invalid %s %q, using %v", name, value, def)
+		return def
+	}
+	return d
+}
+
+// firestoreEmulatorHost returns the address of the local Firestore emulator,
+// or an empty string when running against the real backend.
+func firestoreEmulatorHost() string {
+	return os.Getenv("FIRESTORE_EMULATOR_HOST")
+}
+
+// firestoreDatabaseID selects a named Firestore database, set with the
+// FIRESTORE_DATABASE_ID env var.
+var firestoreDatabaseID = stringFromEnv("FIRESTORE_DATABASE_ID", firestore.DefaultDatabaseID)
+
+// newFirestoreClient creates a client on the configured database of the project.
+func newFirestoreClient(ctx context.Context, projectID string, opts ...option.ClientOption) (*firestore.Client, error) {
+	return firestore.NewClientWithDatabase(ctx, projectID, firestoreDatabaseID, opts...)
+}
+
+// firebaseOptions returns the client options used to create the Firebase app.
+func firebaseOptions() []option.ClientOption {
+	// The Firestore client dials the emulator on its own, and the emulator
+	// doesn't need any Google credentials
+	if firestoreEmulatorHost() != "" {
+		return []option.ClientOption{option.WithoutAuthentication()}
+	}
+	return firestoreMetricsOptions()
+}
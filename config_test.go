@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/option"
+)
+
+func TestLoadLocation(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{defaultTimezone, defaultTimezone},
+		{"Europe/Madrid", "Europe/Madrid"},
+		{"UTC", "UTC"},
+		{"Mars/Olympus_Mons", "UTC"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := time.LoadLocation(tt.want); err != nil {
+				t.Skipf("Zone database lacks %s: %v", tt.want, err)
+			}
+			if got := loadLocation(tt.name).String(); got != tt.want {
+				t.Errorf("loadLocation(%q) = %s, want %s", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewCollectionNames(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   collectionNames
+	}{
+		{"", collectionNames{Users: "Users", Suscriptions: "Suscriptions", Chats: "Chats", Messages: "Messages",
+			Groups: "Groups", Talks: "Talks", ReadReceipts: "ReadReceipts"}},
+		{"staging_", collectionNames{Users: "staging_Users", Suscriptions: "staging_Suscriptions", Chats: "staging_Chats",
+			Messages: "staging_Messages", Groups: "staging_Groups", Talks: "staging_Talks", ReadReceipts: "staging_ReadReceipts"}},
+	}
+
+	for _, tt := range tests {
+		if got := newCollectionNames(tt.prefix); got != tt.want {
+			t.Errorf("newCollectionNames(%q) = %+v, want %+v", tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestFirebaseOptions(t *testing.T) {
+	tests := []struct {
+		name         string
+		emulatorHost string
+		wantOptions  int
+		wantNoAuth   bool
+	}{
+		{"production", "", len(firestoreMetricsOptions()), false},
+		{"emulator", "localhost:8080", 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("FIRESTORE_EMULATOR_HOST", tt.emulatorHost)
+
+			opts := firebaseOptions()
+
+			if len(opts) != tt.wantOptions {
+				t.Errorf("firebaseOptions() has %d options, want %d", len(opts), tt.wantOptions)
+			}
+			noAuth := false
+			for _, opt := range opts {
+				if opt == option.WithoutAuthentication() {
+					noAuth = true
+				}
+			}
+			if noAuth != tt.wantNoAuth {
+				t.Errorf("firebaseOptions() without authentication = %v, want %v", noAuth, tt.wantNoAuth)
+			}
+		})
+	}
+}
+
+func TestNewFirestoreClientDatabase(t *testing.T) {
+	// Pointing at the emulator keeps the client from looking up credentials
+	t.Setenv("FIRESTORE_EMULATOR_HOST", "127.0.0.1:1")
+	restore := firestoreDatabaseID
+	t.Cleanup(func() { firestoreDatabaseID = restore })
+
+	tests := []struct {
+		name       string
+		databaseID string
+		wantPath   string
+	}{
+		{"default database", firestore.DefaultDatabaseID, "projects/talkit-test/databases/(default)/documents/Users/alice"},
+		{"named database", "analytics", "projects/talkit-test/databases/analytics/documents/Users/alice"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			firestoreDatabaseID = tt.databaseID
+
+			client, err := newFirestoreClient(context.Background(), "talkit-test", firebaseOptions()...)
+			if err != nil {
+				t.Fatalf("newFirestoreClient: %v", err)
+			}
+			defer client.Close()
+
+			if got := client.Collection("Users").Doc("alice").Path; got != tt.wantPath {
+				t.Errorf("document path = %s, want %s", got, tt.wantPath)
+			}
+		})
+	}
+}
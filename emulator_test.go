@@ -0,0 +1,1136 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newTestClient returns a Firestore client on the emulator at
+// FIRESTORE_EMULATOR_HOST, skipping the test when it isn't set. The database
+// is emptied before the test and again when it ends.
+func newTestClient(t *testing.T) *firestore.Client {
+	t.Helper()
+
+	if firestoreEmulatorHost() == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST is not set")
+	}
+
+	client, err := newFirestoreClient(context.Background(), defaultProjectID, firebaseOptions()...)
+	if err != nil {
+		t.Fatalf("Creating Firestore client: %v", err)
+	}
+	clearEmulator(t)
+	t.Cleanup(func() {
+		clearEmulator(t)
+		client.Close()
+	})
+	return client
+}
+
+// clearEmulator deletes every document of the emulator database, subcollections
+// included, through the emulator's REST endpoint.
+func clearEmulator(t *testing.T) {
+	t.Helper()
+
+	url := fmt.Sprintf("http://%s/emulator/v1/projects/%s/databases/%s/documents",
+		firestoreEmulatorHost(), defaultProjectID, firestoreDatabaseID)
+	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	if err != nil {
+		t.Fatalf("Clearing emulator: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Clearing emulator: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Clearing emulator: status %d", resp.StatusCode)
+	}
+}
+
+func TestUsersAPIEmulator(t *testing.T) {
+	client := newTestClient(t)
+	fakeFirebase(t, client, nil)
+
+	r := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(`{"Name": "Alice Liddell", "Price": 9.99}`))
+	r.Header.Set("Content-Type", jsonContentType)
+	r.Header.Set("Authorization", "alice")
+	w := httptest.NewRecorder()
+	UsersAPI(w, r)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+	var created map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Decoding created user: %v", err)
+	}
+	uid, _ := created["uid"].(string)
+	if uid != "alice" || created["slug"] != "alice-liddell" {
+		t.Errorf("created user = %v, want the caller's uid and a slug", created)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(`{"Name": "Alice Again"}`))
+	r.Header.Set("Content-Type", jsonContentType)
+	r.Header.Set("Authorization", "alice")
+	w = httptest.NewRecorder()
+	UsersAPI(w, r)
+
+	if w.Code != http.StatusConflict {
+		t.Errorf("second POST status = %d, want %d: %s", w.Code, http.StatusConflict, w.Body)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/users?uid="+uid, nil)
+	w = httptest.NewRecorder()
+	UsersAPI(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	if !strings.Contains(w.Body.String(), `"price":9.99`) {
+		t.Errorf("GET body %s doesn't contain the stored price", w.Body)
+	}
+}
+
+func TestUsersAPICreateIDEmulator(t *testing.T) {
+	client := newTestClient(t)
+	fakeFirebase(t, client, nil)
+
+	tests := []struct {
+		name       string
+		uid        string
+		body       string
+		wantStatus int
+		wantID     string
+	}{
+		{"caller's ID by default", "bob", `{"Name": "Bob"}`, http.StatusCreated, "bob"},
+		{"admin for another user", "admin", `{"ID": "carol", "Name": "Carol"}`, http.StatusCreated, "carol"},
+		{"generated by Firestore for admins", "admin", `{"Name": "Dave"}`, http.StatusCreated, ""},
+		{"taken ID", "admin", `{"ID": "bob", "Name": "Bob Again"}`, http.StatusConflict, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveJSON(UsersAPI, http.MethodPost, "/users", tt.uid, tt.body)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if w.Code != http.StatusCreated {
+				return
+			}
+			var created map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+				t.Fatalf("Decoding created user: %v", err)
+			}
+			id, _ := created["uid"].(string)
+			if id == "" || id == "admin" || (tt.wantID != "" && id != tt.wantID) {
+				t.Fatalf("created uid = %q, want %q or a generated one", id, tt.wantID)
+			}
+			if location := w.Header().Get("Location"); location != "/users?uid="+id {
+				t.Errorf("Location = %q, want /users?uid=%s", location, id)
+			}
+
+			readCache.Delete(cacheKey(context.Background(), collections.Users, id))
+			if w := serveJSON(UsersAPI, http.MethodGet, "/users?uid="+id, "", ""); w.Code != http.StatusOK {
+				t.Errorf("GET by the returned uid status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+			}
+		})
+	}
+}
+
+func TestHandleUserCreateTrialEmulator(t *testing.T) {
+	client := newTestClient(t)
+
+	tests := []struct {
+		name      string
+		trialDays string
+		wantDays  int
+	}{
+		{"default trial", "", defaultFreeTrialDays},
+		{"configured trial", "10", 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("FREE_TRIAL_DAYS", tt.trialDays)
+			uid := "trial-" + strings.ReplaceAll(tt.name, " ", "-")
+
+			var e FirestoreEvent
+			e.Value.Fields.ID = uid
+			start := time.Now()
+			w := httptest.NewRecorder()
+			if err := HandleUserCreate(context.Background(), client, w, httptest.NewRequest(http.MethodPost, "/", nil), e); err != nil {
+				t.Fatalf("HandleUserCreate: %v", err)
+			}
+			if w.Code != http.StatusCreated {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusCreated)
+			}
+
+			doc, err := client.Collection(collections.Suscriptions).Doc(uid).Get(context.Background())
+			if err != nil {
+				t.Fatalf("Reading subscription: %v", err)
+			}
+			var suscription SubscriptionFieldsType
+			if err := doc.DataTo(&suscription); err != nil {
+				t.Fatalf("Decoding subscription: %v", err)
+			}
+			want := start.In(defaultLocation).AddDate(0, 0, tt.wantDays)
+			if diff := suscription.ExpireAt.Sub(want); diff < -time.Minute || diff > time.Minute {
+				t.Errorf("expireAt = %v, want about %v", suscription.ExpireAt, want)
+			}
+			if suscription.SuscriptionType != "free-trial" {
+				t.Errorf("suscriptionType = %q, want free-trial", suscription.SuscriptionType)
+			}
+		})
+	}
+}
+
+func TestHandleUserCreateTrialFlagEmulator(t *testing.T) {
+	client := newTestClient(t)
+
+	tests := []struct {
+		name       string
+		enabled    bool
+		wantStatus int
+		wantTrial  bool
+	}{
+		{"enabled", true, http.StatusCreated, true},
+		{"disabled", false, http.StatusOK, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := enableFreeTrial
+			enableFreeTrial = tt.enabled
+			t.Cleanup(func() { enableFreeTrial = previous })
+
+			var e FirestoreEvent
+			e.Value.Fields.ID = "flag-" + tt.name
+			w := httptest.NewRecorder()
+			if err := HandleUserCreate(context.Background(), client, w, httptest.NewRequest(http.MethodPost, "/", nil), e); err != nil {
+				t.Fatalf("HandleUserCreate: %v", err)
+			}
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+
+			doc, err := client.Collection(collections.Suscriptions).Doc(e.Value.Fields.ID).Get(context.Background())
+			if err != nil && status.Code(err) != codes.NotFound {
+				t.Fatalf("Reading subscription: %v", err)
+			}
+			if doc.Exists() != tt.wantTrial {
+				t.Errorf("subscription exists = %v, want %v", doc.Exists(), tt.wantTrial)
+			}
+		})
+	}
+}
+
+func TestHandleUserCreateTwiceEmulator(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	var e FirestoreEvent
+	e.Value.Fields.ID = "alice"
+	wantStatus := []int{http.StatusCreated, http.StatusOK}
+	for i, want := range wantStatus {
+		w := httptest.NewRecorder()
+		if err := HandleUserCreate(ctx, client, w, httptest.NewRequest(http.MethodPost, "/", nil), e); err != nil {
+			t.Fatalf("bootstrap %d: %v", i+1, err)
+		}
+		if w.Code != want {
+			t.Errorf("bootstrap %d status = %d, want %d", i+1, w.Code, want)
+		}
+
+		// A plan bought after the first bootstrap must survive a re-trigger
+		if i == 0 {
+			_, err := client.Collection(collections.Suscriptions).Doc("alice").Update(ctx, []firestore.Update{{Path: "suscriptionType", Value: "annual"}})
+			if err != nil {
+				t.Fatalf("Upgrading subscription: %v", err)
+			}
+		}
+	}
+
+	doc, err := client.Collection(collections.Suscriptions).Doc("alice").Get(ctx)
+	if err != nil {
+		t.Fatalf("Reading subscription: %v", err)
+	}
+	if plan := doc.Data()["suscriptionType"]; plan != "annual" {
+		t.Errorf("suscriptionType = %v, want the annual plan left untouched", plan)
+	}
+}
+
+func TestUsersAPIBySlugEmulator(t *testing.T) {
+	client := newTestClient(t)
+	fakeFirebase(t, client, nil)
+
+	for _, uid := range []string{"alice", "bob"} {
+		user := map[string]interface{}{"uid": uid, "slug": uid + "-liddell"}
+		if _, err := client.Collection(collections.Users).Doc(uid).Set(context.Background(), user); err != nil {
+			t.Fatalf("Seeding %s: %v", uid, err)
+		}
+		readCache.Delete(cacheKey(context.Background(), collections.Users, uid))
+	}
+
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+		wantUID    string
+	}{
+		{"by slug", "/users?slug=bob-liddell", http.StatusOK, "bob"},
+		{"uid wins over slug", "/users?uid=alice&slug=bob-liddell", http.StatusOK, "alice"},
+		{"unknown slug", "/users?slug=carol", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveJSON(UsersAPI, http.MethodGet, tt.target, "", "")
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantUID != "" && !strings.Contains(w.Body.String(), `"uid":"`+tt.wantUID+`"`) {
+				t.Errorf("body %s isn't the user %s", w.Body, tt.wantUID)
+			}
+		})
+	}
+}
+
+func TestUsersAPIByIDsEmulator(t *testing.T) {
+	client := newTestClient(t)
+	fakeFirebase(t, client, nil)
+
+	for _, uid := range []string{"alice", "bob"} {
+		user := map[string]interface{}{"uid": uid}
+		if _, err := client.Collection(collections.Users).Doc(uid).Set(context.Background(), user); err != nil {
+			t.Fatalf("Seeding %s: %v", uid, err)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		uids        string
+		wantUIDs    []string
+		wantMissing []string
+	}{
+		{"all existing", "bob,alice", []string{"bob", "alice"}, []string{}},
+		{"mixed", "carol,alice,dave,bob", []string{"", "alice", "", "bob"}, []string{"carol", "dave"}},
+		{"all missing", "carol", []string{""}, []string{"carol"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveJSON(UsersAPI, http.MethodGet, "/users?uids="+tt.uids, "", "")
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+			}
+			var body struct {
+				Data    []map[string]interface{} `json:"data"`
+				Missing []string                 `json:"missing"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Decoding users: %v", err)
+			}
+			if len(body.Data) != len(tt.wantUIDs) {
+				t.Fatalf("got %d users, want %d: %s", len(body.Data), len(tt.wantUIDs), w.Body)
+			}
+			for i, want := range tt.wantUIDs {
+				if want == "" {
+					if body.Data[i] != nil {
+						t.Errorf("user %d = %v, want null", i, body.Data[i])
+					}
+					continue
+				}
+				if body.Data[i]["uid"] != want {
+					t.Errorf("user %d uid = %v, want %s", i, body.Data[i]["uid"], want)
+				}
+			}
+			if strings.Join(body.Missing, ",") != strings.Join(tt.wantMissing, ",") {
+				t.Errorf("missing = %v, want %v", body.Missing, tt.wantMissing)
+			}
+		})
+	}
+}
+
+func TestUsersAPIPatchAndPutEmulator(t *testing.T) {
+	client := newTestClient(t)
+	fakeFirebase(t, client, nil)
+
+	tests := []struct {
+		name      string
+		method    string
+		body      string
+		wantName  string
+		wantImage interface{}
+	}{
+		{"patch keeps other fields", http.MethodPatch, `{"ID": "alice", "Name": "Alice Liddell"}`, "Alice Liddell", "alice.png"},
+		{"put replaces the document", http.MethodPut, `{"ID": "alice", "Name": "Alice Liddell"}`, "Alice Liddell", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docRef := client.Collection(collections.Users).Doc("alice")
+			seed := map[string]interface{}{"uid": "alice", "displayName": "Alice", "image": "alice.png", "slug": "alice"}
+			if _, err := docRef.Set(context.Background(), seed); err != nil {
+				t.Fatalf("Seeding alice: %v", err)
+			}
+			readCache.Delete(cacheKey(context.Background(), collections.Users, "alice"))
+
+			if w := serveJSON(UsersAPI, tt.method, "/users", "alice", tt.body); w.Code != http.StatusOK {
+				t.Fatalf("%s status = %d, want %d: %s", tt.method, w.Code, http.StatusOK, w.Body)
+			}
+
+			doc, err := docRef.Get(context.Background())
+			if err != nil {
+				t.Fatalf("Reading alice: %v", err)
+			}
+			data := doc.Data()
+			if data["displayName"] != tt.wantName || data["image"] != tt.wantImage {
+				t.Errorf("displayName, image = %v, %v, want %v, %v", data["displayName"], data["image"], tt.wantName, tt.wantImage)
+			}
+		})
+	}
+}
+
+func TestCreateLocationEmulator(t *testing.T) {
+	client := newTestClient(t)
+	fakeFirebase(t, client, nil)
+
+	tests := []struct {
+		name         string
+		handler      http.HandlerFunc
+		target       string
+		body         string
+		wantLocation string
+	}{
+		{"user", UsersAPI, "/users", `{"Name": "Alice Smith"}`, "/users?uid=alice+smith"},
+		{"suscription", SuscriptionsAPI, "/suscriptions", `{"uid": "alice smith", "suscriptionType": "monthly"}`, "/suscriptions?uid=alice+smith"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveJSON(tt.handler, http.MethodPost, tt.target, "alice smith", tt.body)
+
+			if w.Code != http.StatusCreated {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+			}
+			if got := w.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+		})
+	}
+}
+
+func TestUsersListAPIEmulator(t *testing.T) {
+	client := newTestClient(t)
+	fakeFirebase(t, client, nil)
+
+	for _, uid := range []string{"alice", "bob", "carol"} {
+		_, err := client.Collection(collections.Users).Doc(uid).Set(context.Background(), map[string]interface{}{"uid": uid})
+		if err != nil {
+			t.Fatalf("Seeding %s: %v", uid, err)
+		}
+	}
+
+	var listed []string
+	cursor := ""
+	for page := 0; page < 3; page++ {
+		r := httptest.NewRequest(http.MethodGet, "/users/list?limit=2&includeTotal=true&startAfter="+cursor, nil)
+		w := httptest.NewRecorder()
+		UsersListAPI(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+		}
+		if got := w.Header().Get(totalCountHeader); got != "3" {
+			t.Errorf("%s = %q, want 3", totalCountHeader, got)
+		}
+		var body struct {
+			Data []map[string]interface{} `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Decoding users: %v", err)
+		}
+		for _, user := range body.Data {
+			listed = append(listed, user["uid"].(string))
+		}
+
+		cursor = w.Header().Get(nextCursorHeader)
+		if cursor == "" {
+			break
+		}
+	}
+
+	if strings.Join(listed, ",") != "alice,bob,carol" {
+		t.Errorf("listed users = %v, want alice, bob and carol", listed)
+	}
+}
+
+func TestSetTotalCountEmulator(t *testing.T) {
+	client := newTestClient(t)
+
+	users := []map[string]interface{}{
+		{"uid": "alice", "type": "speaker"},
+		{"uid": "bob", "type": "attendee"},
+		{"uid": "carol", "type": "speaker"},
+	}
+	for _, user := range users {
+		if _, err := client.Collection(collections.Users).Doc(user["uid"].(string)).Set(context.Background(), user); err != nil {
+			t.Fatalf("Seeding %s: %v", user["uid"], err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query firestore.Query
+		want  string
+	}{
+		{"all users", client.Collection(collections.Users).Query, "3"},
+		{"filtered", client.Collection(collections.Users).Where("type", "==", "speaker"), "2"},
+		{"no match", client.Collection(collections.Users).Where("type", "==", "organizer"), "0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/users/list?includeTotal=true", nil)
+
+			if !setTotalCount(context.Background(), w, r, tt.query) {
+				t.Fatalf("setTotalCount failed: %s", w.Body)
+			}
+			if got := w.Header().Get(totalCountHeader); got != tt.want {
+				t.Errorf("%s = %q, want %s", totalCountHeader, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUsersListAPIFiltersEmulator(t *testing.T) {
+	client := newTestClient(t)
+	fakeFirebase(t, client, nil)
+
+	users := []map[string]interface{}{
+		{"uid": "alice", "type": "speaker", "year": "2023"},
+		{"uid": "bob", "type": "speaker", "year": "2024"},
+		{"uid": "carol", "type": "attendee", "year": "2024"},
+	}
+	for _, user := range users {
+		if _, err := client.Collection(collections.Users).Doc(user["uid"].(string)).Set(context.Background(), user); err != nil {
+			t.Fatalf("Seeding %s: %v", user["uid"], err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"no filter", "", "alice,bob,carol"},
+		{"by type", "?type=speaker", "alice,bob"},
+		{"by type and year", "?type=speaker&year=2024", "bob"},
+		{"no match", "?year=2020", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveJSON(UsersListAPI, http.MethodGet, "/users/list"+tt.query, "", "")
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+			}
+			var body struct {
+				Data []map[string]interface{} `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Decoding users: %v", err)
+			}
+			var listed []string
+			for _, user := range body.Data {
+				listed = append(listed, user["uid"].(string))
+			}
+			if got := strings.Join(listed, ","); got != tt.want {
+				t.Errorf("listed users = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUsersExportAPICSVEmulator(t *testing.T) {
+	client := newTestClient(t)
+	fakeFirebase(t, client, nil)
+
+	user := UsersFieldsType{ID: "alice", Name: "Alice", Price: 999, Currency: "USD", Type: "speaker"}
+	if _, err := client.Collection(collections.Users).Doc("alice").Set(context.Background(), &user); err != nil {
+		t.Fatalf("Seeding alice: %v", err)
+	}
+
+	w := serveJSON(UsersExportAPI, http.MethodGet, "/users/export?format=csv", "admin", "")
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("Reading csv: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("csv has %d rows, want a header and one user", len(rows))
+	}
+	if got, want := strings.Join(rows[0], ","), "uid,displayName,price,currency,type,year,image,description,slug,createdAt,updatedAt"; got != want {
+		t.Errorf("header = %s, want %s", got, want)
+	}
+	if got, want := strings.Join(rows[1][:5], ","), "alice,Alice,9.99,USD,speaker"; got != want {
+		t.Errorf("row starts with %s, want %s", got, want)
+	}
+}
+
+func TestUsersExportAPINDJSONEmulator(t *testing.T) {
+	client := newTestClient(t)
+	fakeFirebase(t, client, nil)
+
+	for _, uid := range []string{"alice", "bob", "carol"} {
+		_, err := client.Collection(collections.Users).Doc(uid).Set(context.Background(), map[string]interface{}{"uid": uid, "price": int64(999)})
+		if err != nil {
+			t.Fatalf("Seeding %s: %v", uid, err)
+		}
+	}
+
+	w := serveJSON(UsersExportAPI, http.MethodGet, "/users/export?format=ndjson", "admin", "")
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/x-ndjson" {
+		t.Errorf("Content-Type = %q, want application/x-ndjson", got)
+	}
+	var exported []string
+	for _, line := range strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n") {
+		var user struct {
+			ID    string      `json:"uid"`
+			Price json.Number `json:"price"`
+		}
+		if err := json.Unmarshal([]byte(line), &user); err != nil {
+			t.Fatalf("Decoding line %q: %v", line, err)
+		}
+		if user.Price != "9.99" {
+			t.Errorf("price of %s = %s, want 9.99", user.ID, user.Price)
+		}
+		exported = append(exported, user.ID)
+	}
+	if got := strings.Join(exported, ","); got != "alice,bob,carol" {
+		t.Errorf("exported users = %s, want alice,bob,carol", got)
+	}
+}
+
+func TestUsersImportAPIEmulator(t *testing.T) {
+	client := newTestClient(t)
+	fakeFirebase(t, client, nil)
+
+	tests := []struct {
+		name        string
+		body        string
+		wantCreated int
+		wantLines   []int
+	}{
+		{"valid file", "{\"uid\": \"alice\", \"displayName\": \"Alice\"}\n{\"uid\": \"bob\", \"displayName\": \"Bob\"}\n", 2, []int{}},
+		{"one malformed line", "{\"uid\": \"carol\", \"displayName\": \"Carol\"}\n{\"uid\": \n{\"uid\": \"dave\", \"displayName\": \"Dave\"}\n", 2, []int{2}},
+		{"already imported", "{\"uid\": \"alice\", \"displayName\": \"Alice\"}\n", 0, []int{1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/users/import", strings.NewReader(tt.body))
+			r.Header.Set("Authorization", "admin")
+			r.Header.Set("Content-Type", "application/x-ndjson")
+			w := httptest.NewRecorder()
+			UsersImportAPI(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+			}
+			var result ImportResultType
+			if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+				t.Fatalf("Decoding result: %v", err)
+			}
+			lines := []int{}
+			for _, failure := range result.Failures {
+				lines = append(lines, failure.Line)
+			}
+			if result.Created != tt.wantCreated || fmt.Sprint(lines) != fmt.Sprint(tt.wantLines) {
+				t.Errorf("result = %+v, want %d created and failures on lines %v", result, tt.wantCreated, tt.wantLines)
+			}
+		})
+	}
+}
+
+func TestUsersSearchAPIEmulator(t *testing.T) {
+	client := newTestClient(t)
+	fakeFirebase(t, client, nil)
+
+	users := []map[string]interface{}{
+		{"uid": "alice", "type": "speaker", "year": "2024", "price": int64(999)},
+		{"uid": "bob", "type": "speaker", "year": "2024", "price": int64(2500)},
+		{"uid": "carol", "type": "speaker", "year": "2023", "price": int64(999)},
+		{"uid": "dave", "type": "attendee", "year": "2024", "price": int64(999)},
+	}
+	for _, user := range users {
+		if _, err := client.Collection(collections.Users).Doc(user["uid"].(string)).Set(context.Background(), user); err != nil {
+			t.Fatalf("Seeding %s: %v", user["uid"], err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"two filters", `{"filters": [{"field": "type", "op": "==", "value": "speaker"}, {"field": "year", "op": "==", "value": "2024"}]}`, "alice,bob"},
+		{"amount range", `{"filters": [{"field": "type", "op": "==", "value": "speaker"}, {"field": "price", "op": "<", "value": 10}]}`, "alice,carol"},
+		{"in filter with limit", `{"filters": [{"field": "year", "op": "in", "value": ["2023", "2024"]}], "limit": 2}`, "alice,bob"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveJSON(UsersSearchAPI, http.MethodPost, "/users/search", "", tt.body)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+			}
+			var body struct {
+				Data []map[string]interface{} `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Decoding users: %v", err)
+			}
+			var found []string
+			for _, user := range body.Data {
+				found = append(found, user["uid"].(string))
+			}
+			if got := strings.Join(found, ","); got != tt.want {
+				t.Errorf("found users = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateUsersKeepsPresenceEmulator(t *testing.T) {
+	client := newTestClient(t)
+	fakeFirebase(t, client, nil)
+
+	if w := serveJSON(UsersAPI, http.MethodPost, "/users", "alice", `{"Name": "Alice"}`); w.Code != http.StatusCreated {
+		t.Fatalf("POST status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+	w := serveJSON(UsersAPI, http.MethodGet, "/users?uid=alice", "", "")
+	var user struct {
+		UpdatedAt time.Time `json:"updatedAt"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &user); err != nil {
+		t.Fatalf("Decoding user: %v", err)
+	}
+
+	// A heartbeat must neither be lost by the PUT nor fail its precondition
+	if w := serveJSON(UsersPresenceAPI, http.MethodPut, "/users/presence", "alice", `{"online": true}`); w.Code != http.StatusOK {
+		t.Fatalf("presence status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	target := "/users?updateTime=" + user.UpdatedAt.Format(time.RFC3339Nano)
+	if w := serveJSON(UsersAPI, http.MethodPut, target, "alice", `{"ID": "alice", "Name": "Alice Liddell"}`); w.Code != http.StatusOK {
+		t.Fatalf("PUT status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	doc, err := client.Collection(collections.Users).Doc("alice").Get(context.Background())
+	if err != nil {
+		t.Fatalf("Reading alice: %v", err)
+	}
+	if online, _ := doc.DataAt("online"); online != true {
+		t.Errorf("online = %v, want true", online)
+	}
+	if name, _ := doc.DataAt("displayName"); name != "Alice Liddell" {
+		t.Errorf("displayName = %v, want Alice Liddell", name)
+	}
+}
+
+func TestSuscriptionsAPICreateOwnerEmulator(t *testing.T) {
+	client := newTestClient(t)
+	fakeFirebase(t, client, nil)
+
+	tests := []struct {
+		name       string
+		caller     string
+		uid        string
+		wantStatus int
+	}{
+		{"self", "alice", "alice", http.StatusCreated},
+		{"another user", "alice", "bob", http.StatusForbidden},
+		{"admin override", "admin", "bob", http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := fmt.Sprintf(`{"uid": %q, "suscriptionType": "monthly"}`, tt.uid)
+			w := serveJSON(SuscriptionsAPI, http.MethodPost, "/suscriptions", tt.caller, body)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			doc, err := client.Collection(collections.Suscriptions).Doc(tt.uid).Get(context.Background())
+			if err != nil && status.Code(err) != codes.NotFound {
+				t.Fatalf("Reading subscription: %v", err)
+			}
+			if want := tt.wantStatus == http.StatusCreated; doc.Exists() != want {
+				t.Errorf("subscription of %s exists = %v, want %v", tt.uid, doc.Exists(), want)
+			}
+		})
+	}
+}
+
+func TestSuscriptionsRenewAPIRecordsHistoryEmulator(t *testing.T) {
+	client := newTestClient(t)
+	fakeFirebase(t, client, nil)
+
+	expireAt := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	suscription := SubscriptionFieldsType{ID: "alice", SuscriptionType: "monthly", ExpireAt: expireAt}
+	if _, err := client.Collection(collections.Suscriptions).Doc("alice").Set(context.Background(), &suscription); err != nil {
+		t.Fatalf("Seeding subscription: %v", err)
+	}
+
+	if w := serveJSON(SuscriptionsRenewAPI, http.MethodPost, "/suscriptions/renew", "admin", `{"uid": "alice", "days": 10}`); w.Code != http.StatusOK {
+		t.Fatalf("renew status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	w := serveJSON(SuscriptionsHistoryAPI, http.MethodGet, "/suscriptions/history?uid=alice", "alice", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("history status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var history []SubscriptionHistoryType
+	if err := json.Unmarshal(w.Body.Bytes(), &history); err != nil {
+		t.Fatalf("Decoding history: %v", err)
+	}
+	if len(history) != 1 {
+		t.Fatalf("history has %d entries, want 1", len(history))
+	}
+	if entry := history[0]; entry.Change != "renewal" || !entry.Previous.ExpireAt.Equal(expireAt) || entry.ChangedAt.IsZero() {
+		t.Errorf("history entry = %+v, want the renewal of the subscription expiring at %v", entry, expireAt)
+	}
+}
+
+func TestSuscriptionsTransferAPIEmulator(t *testing.T) {
+	client := newTestClient(t)
+	fakeFirebase(t, client, nil)
+
+	for _, uid := range []string{"alice", "carol"} {
+		suscription := SubscriptionFieldsType{ID: uid, SuscriptionType: "monthly", ExpireAt: time.Now().Add(24 * time.Hour)}
+		if _, err := client.Collection(collections.Suscriptions).Doc(uid).Set(context.Background(), &suscription); err != nil {
+			t.Fatalf("Seeding %s: %v", uid, err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantExists map[string]bool
+	}{
+		{"destination conflict", `{"fromUid": "alice", "toUid": "carol"}`, http.StatusConflict,
+			map[string]bool{"alice": true, "carol": true}},
+		{"transfer", `{"fromUid": "alice", "toUid": "bob"}`, http.StatusOK,
+			map[string]bool{"alice": false, "bob": true}},
+		{"missing source", `{"fromUid": "alice", "toUid": "dave"}`, http.StatusNotFound,
+			map[string]bool{"dave": false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveJSON(SuscriptionsTransferAPI, http.MethodPost, "/suscriptions/transfer", "admin", tt.body)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			for uid, want := range tt.wantExists {
+				doc, err := client.Collection(collections.Suscriptions).Doc(uid).Get(context.Background())
+				if err != nil && status.Code(err) != codes.NotFound {
+					t.Fatalf("Reading %s: %v", uid, err)
+				}
+				if doc.Exists() != want {
+					t.Errorf("subscription of %s exists = %v, want %v", uid, doc.Exists(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestGroupsAPIEmulator(t *testing.T) {
+	client := newTestClient(t)
+	fakeFirebase(t, client, nil)
+
+	groups := []GroupFieldsType{
+		{ID: "speakers", Name: "Speakers", Members: []string{"alice", "bob"}},
+		{ID: "organizers", Name: "Organizers", Members: []string{"bob", "carol"}},
+		{ID: "volunteers", Name: "Volunteers", Members: []string{"carol"}},
+	}
+	for _, group := range groups {
+		group := group
+		if _, err := client.Collection(collections.Groups).Doc(group.ID).Set(context.Background(), &group); err != nil {
+			t.Fatalf("Seeding %s: %v", group.ID, err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		member string
+		uid    string
+		want   string
+	}{
+		{"single group", "alice", "alice", "speakers"},
+		{"overlapping groups", "bob", "bob", "organizers,speakers"},
+		{"read by an admin", "carol", "admin", "organizers,volunteers"},
+		{"no groups", "dave", "dave", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveJSON(GroupsAPI, http.MethodGet, "/groups?member="+tt.member, tt.uid, "")
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+			}
+			var body struct {
+				Data []GroupFieldsType `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Decoding groups: %v", err)
+			}
+			var found []string
+			for _, group := range body.Data {
+				found = append(found, group.ID)
+			}
+			if got := strings.Join(found, ","); got != tt.want {
+				t.Errorf("groups of %s = %s, want %s", tt.member, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarkIdleUsersOfflineEmulator(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	now := time.Now()
+	users := map[string]map[string]interface{}{
+		"idle":    {"online": true, "lastSeen": now.Add(-2 * presenceTimeout)},
+		"active":  {"online": true, "lastSeen": now},
+		"offline": {"online": false, "lastSeen": now.Add(-2 * presenceTimeout)},
+	}
+	for uid, presence := range users {
+		if _, err := client.Collection(collections.Users).Doc(uid).Set(ctx, presence); err != nil {
+			t.Fatalf("Seeding %s: %v", uid, err)
+		}
+	}
+
+	marked, err := markIdleUsersOffline(ctx, client)
+	if err != nil || marked != 1 {
+		t.Fatalf("markIdleUsersOffline = %d, %v, want 1 user marked", marked, err)
+	}
+
+	for uid, wantOnline := range map[string]bool{"idle": false, "active": true, "offline": false} {
+		doc, err := client.Collection(collections.Users).Doc(uid).Get(ctx)
+		if err != nil {
+			t.Fatalf("Reading %s: %v", uid, err)
+		}
+		if online := doc.Data()["online"]; online != wantOnline {
+			t.Errorf("online of %s = %v, want %v", uid, online, wantOnline)
+		}
+	}
+}
+
+func TestChatsAPIParticipantsEmulator(t *testing.T) {
+	client := newTestClient(t)
+	fakeFirebase(t, client, nil)
+
+	for _, uid := range []string{"alice", "bob"} {
+		if _, err := client.Collection(collections.Users).Doc(uid).Set(context.Background(), map[string]interface{}{"uid": uid}); err != nil {
+			t.Fatalf("Seeding %s: %v", uid, err)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		body        string
+		wantStatus  int
+		wantUnknown []string
+	}{
+		{"existing participants", `{"participants": ["alice", "bob"]}`, http.StatusCreated, nil},
+		{"bogus participant", `{"participants": ["alice", "bob", "mallory"]}`, http.StatusBadRequest, []string{"mallory"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveJSON(ChatsAPI, http.MethodPost, "/chats", "alice", tt.body)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantUnknown == nil {
+				return
+			}
+			var body struct {
+				Data struct {
+					Unknown []string `json:"unknown"`
+				} `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Decoding error: %v", err)
+			}
+			if got := strings.Join(body.Data.Unknown, ","); got != strings.Join(tt.wantUnknown, ",") {
+				t.Errorf("unknown participants = %s, want %v", got, tt.wantUnknown)
+			}
+		})
+	}
+}
+
+func TestMessagesAPIPagingEmulator(t *testing.T) {
+	client := newTestClient(t)
+	fakeFirebase(t, client, nil)
+	ctx := context.Background()
+
+	if _, err := client.Collection(collections.Chats).Doc("chat1").Set(ctx, map[string]interface{}{"participants": []string{"alice", "bob"}}); err != nil {
+		t.Fatalf("Seeding chat: %v", err)
+	}
+	sentAt := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	for i := 1; i <= 5; i++ {
+		message := MessageFieldsType{ChatID: "chat1", SenderID: "alice", Text: fmt.Sprint("message ", i), SentAt: sentAt.Add(time.Duration(i) * time.Minute)}
+		if _, err := client.Collection(collections.Messages).Doc(fmt.Sprint("m", i)).Set(ctx, &message); err != nil {
+			t.Fatalf("Seeding message %d: %v", i, err)
+		}
+	}
+	other := MessageFieldsType{ChatID: "chat2", SenderID: "carol", SentAt: sentAt}
+	if _, err := client.Collection(collections.Messages).Doc("other").Set(ctx, &other); err != nil {
+		t.Fatalf("Seeding other message: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		want       string
+	}{
+		{"latest page", "&limit=2", http.StatusOK, "m5,m4"},
+		{"older page", "&limit=2&before=m4", http.StatusOK, "m3,m2"},
+		{"oldest page", "&limit=2&before=m2", http.StatusOK, "m1"},
+		{"past the start", "&limit=2&before=m1", http.StatusOK, ""},
+		{"unknown cursor", "&before=m9", http.StatusNotFound, ""},
+		{"cursor of another chat", "&before=other", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveJSON(MessagesAPI, http.MethodGet, "/messages?chatId=chat1"+tt.query, "bob", "")
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var body struct {
+				Data []MessageFieldsType `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Decoding messages: %v", err)
+			}
+			var paged []string
+			for _, message := range body.Data {
+				paged = append(paged, message.ID)
+			}
+			if got := strings.Join(paged, ","); got != tt.want {
+				t.Errorf("messages = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrateExpireAtEmulator(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	expireAt := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	legacy := expireAt.Format(http.TimeFormat)
+	docRef := client.Collection(collections.Suscriptions).Doc("alice")
+	if _, err := docRef.Set(ctx, map[string]interface{}{"uid": "alice", "expireAt": legacy}); err != nil {
+		t.Fatalf("Seeding subscription: %v", err)
+	}
+	entry := map[string]interface{}{"previous": map[string]interface{}{"uid": "alice", "expireAt": legacy}}
+	if _, err := docRef.Collection("history").Doc("first").Set(ctx, entry); err != nil {
+		t.Fatalf("Seeding history: %v", err)
+	}
+
+	summary, err := migrateExpireAt(ctx, client)
+	if err != nil {
+		t.Fatalf("migrateExpireAt: %v", err)
+	}
+	if summary.Migrated != 2 || summary.Failed != 0 {
+		t.Errorf("summary = %+v, want 2 migrated", summary)
+	}
+
+	doc, err := docRef.Get(ctx)
+	if err != nil {
+		t.Fatalf("Reading subscription: %v", err)
+	}
+	var suscription SubscriptionFieldsType
+	if err := doc.DataTo(&suscription); err != nil || !suscription.ExpireAt.Equal(expireAt) {
+		t.Errorf("decoded expireAt = %v, %v, want %v", suscription.ExpireAt, err, expireAt)
+	}
+
+	// Running it again finds nothing left to migrate
+	summary, err = migrateExpireAt(ctx, client)
+	if err != nil || summary.Migrated != 0 {
+		t.Errorf("second run = %+v, %v, want nothing migrated", summary, err)
+	}
+}
+
+func TestUsersCountAPIEmulator(t *testing.T) {
+	client := newTestClient(t)
+	fakeFirebase(t, client, nil)
+
+	for uid, userType := range map[string]string{"alice": "speaker", "bob": "speaker", "carol": "attendee"} {
+		_, err := client.Collection(collections.Users).Doc(uid).Set(context.Background(), map[string]interface{}{"uid": uid, "type": userType})
+		if err != nil {
+			t.Fatalf("Seeding %s: %v", uid, err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		want   string
+	}{
+		{"all users", "/users/count", `{"count":3}`},
+		{"by type", "/users/count?type=speaker", `{"count":2}`},
+		{"unknown type", "/users/count?type=organizer", `{"count":0}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveJSON(UsersCountAPI, http.MethodGet, tt.target, "admin", "")
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tt.want {
+				t.Errorf("body = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
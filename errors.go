@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// apiError is an entry of the error catalog: the code sent in the error
+// envelope, the HTTP status it's sent with and its default message.
+type apiError struct {
+	Code    string
+	Status  int
+	Message string
+}
+
+// The error catalog. Every error envelope is written from one of these.
+var (
+	errorBadRequest           = apiError{Code: "BAD_REQUEST", Status: http.StatusBadRequest, Message: "The request is invalid"}
+	errorUnauthorized         = apiError{Code: "UNAUTHORIZED", Status: http.StatusUnauthorized, Message: "Authentication is required"}
+	errorForbidden            = apiError{Code: "FORBIDDEN", Status: http.StatusForbidden, Message: "You are not allowed to perform this operation"}
+	errorHTTPSRequired        = apiError{Code: "HTTPS_REQUIRED", Status: http.StatusForbidden, Message: "This api is only available over HTTPS"}
+	errorNotFound             = apiError{Code: "NOT_FOUND", Status: http.StatusNotFound, Message: "Resource not found"}
+	errorMethodNotAllowed     = apiError{Code: "METHOD_NOT_ALLOWED", Status: http.StatusMethodNotAllowed, Message: "Unsupported method"}
+	errorConflict             = apiError{Code: "CONFLICT", Status: http.StatusConflict, Message: "The resource already exists"}
+	errorPreconditionFailed   = apiError{Code: "PRECONDITION_FAILED", Status: http.StatusPreconditionFailed, Message: "The resource was modified since the given time"}
+	errorUnsupportedMediaType = apiError{Code: "UNSUPPORTED_MEDIA_TYPE", Status: http.StatusUnsupportedMediaType, Message: "Content-Type must be application/json"}
+	errorClientClosedRequest  = apiError{Code: "CLIENT_CLOSED_REQUEST", Status: statusClientClosedRequest, Message: "The client closed the request"}
+	errorInternal             = apiError{Code: "INTERNAL_SERVER_ERROR", Status: http.StatusInternalServerError, Message: "Unexpected error while handling the request"}
+	errorBadGateway           = apiError{Code: "BAD_GATEWAY", Status: http.StatusBadGateway, Message: "An upstream service failed"}
+	errorServiceUnavailable   = apiError{Code: "SERVICE_UNAVAILABLE", Status: http.StatusServiceUnavailable, Message: "Too many requests in flight, please retry later"}
+	errorGatewayTimeout       = apiError{Code: "GATEWAY_TIMEOUT", Status: http.StatusGatewayTimeout, Message: "The database took too long to answer"}
+)
+
+// writeError writes the error envelope of the catalog entry e. An empty
+// message falls back to the entry's default one.
+func writeError(w http.ResponseWriter, e apiError, message string, data interface{}) {
+	if message == "" {
+		message = e.Message
+	}
+
+	// Errors must not be cached in place of the public read that may follow,
+	// such as a 404 for a user created right after
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":      e.Code,
+		"statusCode": e.Status,
+		"data":       data,
+		"message":    message,
+	})
+}
+
+// notFoundHandler answers requests to unknown routes with the 404 envelope.
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, errorNotFound, "No route matches "+r.URL.Path, nil)
+}
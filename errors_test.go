@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// decodeEnvelope decodes the error envelope of a response.
+func decodeEnvelope(t *testing.T, w *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+
+	var envelope map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil {
+		t.Fatalf("Decoding envelope %q: %v", w.Body, err)
+	}
+	return envelope
+}
+
+func TestWriteError(t *testing.T) {
+	w := httptest.NewRecorder()
+	setPublicCache(w)
+	writeError(w, errorNotFound, "", map[string]interface{}{"uid": "alice"})
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if got := w.Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("Cache-Control = %q, want no-store", got)
+	}
+	envelope := decodeEnvelope(t, w)
+	if envelope["error"] != "NOT_FOUND" || envelope["statusCode"] != float64(http.StatusNotFound) || envelope["message"] != errorNotFound.Message {
+		t.Errorf("envelope = %v, want the NOT_FOUND entry with its default message", envelope)
+	}
+	if data, _ := envelope["data"].(map[string]interface{}); data["uid"] != "alice" {
+		t.Errorf("data = %v, want the given data", envelope["data"])
+	}
+}
+
+func TestNotFoundHandler(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodGet, "/nowhere"},
+		{http.MethodPost, "/users/unknown"},
+		{http.MethodDelete, "/suscriptions/renew/alice"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			notFoundHandler(w, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if w.Code != http.StatusNotFound {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+			}
+			if got := w.Header().Get("Content-Type"); got != jsonContentType {
+				t.Errorf("Content-Type = %q, want %q", got, jsonContentType)
+			}
+			envelope := decodeEnvelope(t, w)
+			if envelope["error"] != "NOT_FOUND" || envelope["statusCode"] != float64(http.StatusNotFound) {
+				t.Errorf("envelope = %v, want the NOT_FOUND entry", envelope)
+			}
+			if message, _ := envelope["message"].(string); !strings.Contains(message, tt.path) {
+				t.Errorf("message = %q, want it to name %s", message, tt.path)
+			}
+		})
+	}
+}
+
+func TestWriteFirestoreError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantCode string
+		want     int
+	}{
+		{"canceled", context.Canceled, "CLIENT_CLOSED_REQUEST", statusClientClosedRequest},
+		{"canceled status", status.Error(codes.Canceled, "canceled"), "CLIENT_CLOSED_REQUEST", statusClientClosedRequest},
+		{"deadline", context.DeadlineExceeded, "GATEWAY_TIMEOUT", http.StatusGatewayTimeout},
+		{"deadline status", status.Error(codes.DeadlineExceeded, "too slow"), "GATEWAY_TIMEOUT", http.StatusGatewayTimeout},
+		{"document too large", status.Error(codes.InvalidArgument, "Document exceeds the maximum allowed size"), "BAD_REQUEST", http.StatusBadRequest},
+		{"other", errors.New("backend down"), "INTERNAL_SERVER_ERROR", http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			setPublicCache(w)
+			writeFirestoreError(w, "Reading failed", tt.err)
+
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if got := w.Header().Get("Cache-Control"); got != "no-store" {
+				t.Errorf("Cache-Control = %q, want no-store", got)
+			}
+			if envelope := decodeEnvelope(t, w); envelope["error"] != tt.wantCode {
+				t.Errorf("error = %v, want %s", envelope["error"], tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestIsDocumentTooLarge(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"maximum size", status.Error(codes.InvalidArgument, "Document 'Users/alice' cannot be written because its size (1,048,600 bytes) exceeds the maximum allowed size of 1,048,576 bytes."), true},
+		{"entity too big", status.Error(codes.InvalidArgument, "entity is too big"), true},
+		{"other invalid argument", status.Error(codes.InvalidArgument, "Property name is invalid"), false},
+		{"size message with another code", status.Error(codes.Internal, "exceeds the maximum allowed size"), false},
+		{"plain error", errors.New("exceeds the maximum allowed size"), false},
+		{"nil", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isDocumentTooLarge(tt.err); got != tt.want {
+				t.Errorf("isDocumentTooLarge(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteFirestoreErrorTooLarge(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeFirestoreError(w, "Updating user failed", status.Error(codes.InvalidArgument, "Document exceeds the maximum allowed size"))
+
+	envelope := decodeEnvelope(t, w)
+	if message, _ := envelope["message"].(string); !strings.Contains(message, "1 MiB") {
+		t.Errorf("message = %q, want it to explain the size limit", message)
+	}
+	data, _ := envelope["data"].(map[string]interface{})
+	if data["maxBytes"] != float64(maxDocumentBytes) {
+		t.Errorf("data = %v, want maxBytes %d", envelope["data"], maxDocumentBytes)
+	}
+}
+
+func TestWriteJSONWithETagMarshalFailure(t *testing.T) {
+	w := httptest.NewRecorder()
+	setPublicCache(w)
+	writeJSONWithETag(w, httptest.NewRequest(http.MethodGet, "/users", nil), make(chan int))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if got := w.Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("Cache-Control = %q, want no-store", got)
+	}
+	if envelope := decodeEnvelope(t, w); envelope["error"] != "INTERNAL_SERVER_ERROR" {
+		t.Errorf("error = %v, want INTERNAL_SERVER_ERROR", envelope["error"])
+	}
+}
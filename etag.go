@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// setPublicCache lets browsers and CDNs cache a response that's the same for
+// every caller of a project, for publicCacheMaxAge.
+func setPublicCache(w http.ResponseWriter) {
+	w.Header().Add("Vary", projectHeader)
+	if publicCacheMaxAge <= 0 {
+		w.Header().Set("Cache-Control", "no-cache")
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(publicCacheMaxAge.Seconds())))
+}
+
+// setPrivateNoStore keeps authenticated or user-specific responses out of
+// shared caches.
+func setPrivateNoStore(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "private, no-store")
+}
+
+// writeJSONWithETag encodes value as the JSON response body along with an
+// ETag derived from its content. When the request's If-None-Match matches,
+// a 304 is returned without a body.
+func writeJSONWithETag(w http.ResponseWriter, r *http.Request, value interface{}) {
+	body, err := json.Marshal(value)
+	if err != nil {
+		logErrorf("Marshalling json failed %v", err)
+		writeInternalError(w)
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", jsonContentType)
+	w.Write(append(body, '\n'))
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
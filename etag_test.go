@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEtagMatches(t *testing.T) {
+	const etag = `"abc"`
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		want        bool
+	}{
+		{"empty", "", false},
+		{"same", `"abc"`, true},
+		{"different", `"def"`, false},
+		{"weak", `W/"abc"`, true},
+		{"list", `"def", "abc"`, true},
+		{"list without match", `"def", "ghi"`, false},
+		{"wildcard", "*", true},
+		{"unquoted", "abc", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := etagMatches(tt.ifNoneMatch, etag); got != tt.want {
+				t.Errorf("etagMatches(%q, %q) = %v, want %v", tt.ifNoneMatch, etag, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteJSONWithETag(t *testing.T) {
+	value := map[string]interface{}{"uid": "alice"}
+	first := httptest.NewRecorder()
+	writeJSONWithETag(first, httptest.NewRequest(http.MethodGet, "/users?uid=alice", nil), value)
+	etag := first.Header().Get("ETag")
+	if etag == "" || first.Code != http.StatusOK || first.Body.String() != "{\"uid\":\"alice\"}\n" {
+		t.Fatalf("first response = %d %q with ETag %q, want the JSON body and an ETag", first.Code, first.Body, etag)
+	}
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		value       interface{}
+		wantStatus  int
+		wantBody    bool
+	}{
+		{"no If-None-Match", "", value, http.StatusOK, true},
+		{"matching ETag", etag, value, http.StatusNotModified, false},
+		{"weak matching ETag", "W/" + etag, value, http.StatusNotModified, false},
+		{"stale ETag", `"stale"`, value, http.StatusOK, true},
+		{"changed value", etag, map[string]interface{}{"uid": "bob"}, http.StatusOK, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/users?uid=alice", nil)
+			if tt.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			w := httptest.NewRecorder()
+
+			writeJSONWithETag(w, r, tt.value)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if hasBody := w.Body.Len() > 0; hasBody != tt.wantBody {
+				t.Errorf("body = %q, want a body %v", w.Body, tt.wantBody)
+			}
+			if w.Header().Get("ETag") == "" {
+				t.Error("ETag header missing")
+			}
+		})
+	}
+}
+
+func TestSetPublicCache(t *testing.T) {
+	restore := publicCacheMaxAge
+	t.Cleanup(func() { publicCacheMaxAge = restore })
+
+	tests := []struct {
+		maxAge time.Duration
+		want   string
+	}{
+		{60 * time.Second, "public, max-age=60"},
+		{90 * time.Minute, "public, max-age=5400"},
+		{0, "no-cache"},
+		{-time.Second, "no-cache"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.maxAge.String(), func(t *testing.T) {
+			publicCacheMaxAge = tt.maxAge
+			w := httptest.NewRecorder()
+
+			setPublicCache(w)
+
+			if got := w.Header().Get("Cache-Control"); got != tt.want {
+				t.Errorf("Cache-Control = %q, want %q", got, tt.want)
+			}
+			if got := w.Header().Get("Vary"); got != projectHeader {
+				t.Errorf("Vary = %q, want %q", got, projectHeader)
+			}
+		})
+	}
+}
+
+func TestReadCacheControl(t *testing.T) {
+	restore := publicCacheMaxAge
+	publicCacheMaxAge = time.Minute
+	t.Cleanup(func() { publicCacheMaxAge = restore })
+
+	tests := []struct {
+		name        string
+		handler     http.HandlerFunc
+		target      string
+		uid         string
+		wantStatus  int
+		wantControl string
+	}{
+		{"public user", UsersAPI, "/users?uid=alice", "", http.StatusOK, "public, max-age=60"},
+		{"public user read by its owner", UsersAPI, "/users?uid=alice", "alice", http.StatusOK, "public, max-age=60"},
+		{"own profile", MeAPI, "/me", "alice", http.StatusOK, "private, no-store"},
+		{"profile unauthenticated", MeAPI, "/me", "", http.StatusForbidden, "no-store"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemoryStore()
+			store.Put(collections.Users, "alice", map[string]interface{}{"uid": "alice", "displayName": "Alice"})
+			fakeFirebase(t, nil, store)
+			readCache.Delete(cacheKey(context.Background(), collections.Users, "alice"))
+
+			w := serveJSON(tt.handler, http.MethodGet, tt.target, tt.uid, "")
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if got := w.Header().Get("Cache-Control"); got != tt.wantControl {
+				t.Errorf("Cache-Control = %q, want %q", got, tt.wantControl)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// exportFlushEvery is the number of rows written between flushes to the client.
+const exportFlushEvery = 100
+
+// UsersExportAPI is an HTTP Cloud Function that exports every user.
+func UsersExportAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := projectContext(r)
+
+	app, client, ok := initFirebase(w, r)
+	if !ok {
+		return
+	}
+
+	if handleCORS(w, r, http.MethodGet, http.MethodOptions) {
+		return
+	}
+
+	switch method := r.Method; method {
+	case http.MethodGet:
+		if !authorizeAdmin(w, app, r) {
+			return
+		}
+		setPrivateNoStore(w)
+		exportUsers(ctx, client, w, r)
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodOptions)
+	}
+}
+
+func exportUsers(ctx context.Context, client *firestore.Client, w http.ResponseWriter, r *http.Request) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "csv":
+		exportUsersCSV(ctx, client, w)
+	case "ndjson":
+		exportUsersNDJSON(ctx, client, w)
+	default:
+		writeBadRequest(w, fmt.Sprintf("unsupported export format %q", format))
+	}
+}
+
+// exportUsersCSV streams the users as CSV, one row per document, flushing as
+// it goes instead of buffering the whole collection.
+func exportUsersCSV(ctx context.Context, client *firestore.Client, w http.ResponseWriter) {
+	iter := client.Collection(collections.Users).Documents(ctx)
+	defer iter.Stop()
+
+	// Fetch the first document before committing to a 200 so an early
+	// Firestore failure can still be reported
+	doc, err := iter.Next()
+	if err != nil && err != iterator.Done {
+		writeFirestoreError(w, "Iteration over documents failed", err)
+		return
+	}
+
+	// Large exports take longer than the server write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="users.csv"`)
+	flusher, _ := w.(http.Flusher)
+
+	writer := csv.NewWriter(w)
+	writer.Write(firestoreFieldNames(reflect.TypeOf(UsersFieldsType{})))
+
+	for rows := 1; err != iterator.Done; rows++ {
+		var user UsersFieldsType
+		if err := doc.DataTo(&user); err != nil {
+			logErrorf("Decoding document %s failed %v", doc.Ref.ID, err)
+		} else {
+			writer.Write(firestoreFieldValues(reflect.ValueOf(user)))
+		}
+
+		if rows%exportFlushEvery == 0 {
+			writer.Flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+
+		doc, err = iter.Next()
+		if err != nil && err != iterator.Done {
+			// The response is already under way, so the export just ends early
+			logErrorf("Iteration over documents failed %v", err)
+			break
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		logErrorf("Writing csv failed %v", err)
+	}
+}
+
+// exportUsersNDJSON streams the users as newline-delimited JSON, encoding each
+// document straight from the iterator.
+func exportUsersNDJSON(ctx context.Context, client *firestore.Client, w http.ResponseWriter) {
+	iter := client.Collection(collections.Users).Documents(ctx)
+	defer iter.Stop()
+
+	// Fetch the first document before committing to a 200 so an early
+	// Firestore failure can still be reported
+	doc, err := iter.Next()
+	if err != nil && err != iterator.Done {
+		writeFirestoreError(w, "Iteration over documents failed", err)
+		return
+	}
+
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+
+	// Encode writes a trailing newline after every value
+	encoder := json.NewEncoder(w)
+	for rows := 1; err != iterator.Done; rows++ {
+		if err := encoder.Encode(formatAmounts(doc.Data())); err != nil {
+			logErrorf("Writing ndjson failed %v", err)
+			return
+		}
+
+		if rows%exportFlushEvery == 0 && flusher != nil {
+			flusher.Flush()
+		}
+
+		doc, err = iter.Next()
+		if err != nil && err != iterator.Done {
+			// The response is already under way, so the export just ends early
+			logErrorf("Iteration over documents failed %v", err)
+			return
+		}
+	}
+}
+
+// firestoreFieldNames returns the Firestore names of the fields of a struct type.
+func firestoreFieldNames(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("firestore"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = t.Field(i).Name
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// firestoreFieldValues formats the fields of a struct value, in the same
+// order as firestoreFieldNames.
+func firestoreFieldValues(v reflect.Value) []string {
+	var values []string
+	for i := 0; i < v.NumField(); i++ {
+		if strings.Split(v.Type().Field(i).Tag.Get("firestore"), ",")[0] == "-" {
+			continue
+		}
+
+		switch field := v.Field(i).Interface().(type) {
+		case string:
+			values = append(values, field)
+		case float64:
+			values = append(values, strconv.FormatFloat(field, 'f', -1, 64))
+		case time.Time:
+			if field.IsZero() {
+				values = append(values, "")
+			} else {
+				values = append(values, field.Format(time.RFC3339))
+			}
+		default:
+			values = append(values, fmt.Sprint(field))
+		}
+	}
+	return values
+}
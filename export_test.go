@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFirestoreFieldNames(t *testing.T) {
+	type tagged struct {
+		ID       string    `firestore:"uid"`
+		Created  time.Time `firestore:"createdAt,serverTimestamp"`
+		Internal string    `firestore:"-"`
+		Untagged string
+	}
+
+	tests := []struct {
+		name string
+		typ  reflect.Type
+		want []string
+	}{
+		{"tag options and skipped fields", reflect.TypeOf(tagged{}), []string{"uid", "createdAt", "Untagged"}},
+		{"users", reflect.TypeOf(UsersFieldsType{}), []string{"uid", "displayName", "price", "currency", "type", "year", "image", "description", "slug", "createdAt", "updatedAt"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := firestoreFieldNames(tt.typ); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("firestoreFieldNames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFirestoreFieldValues(t *testing.T) {
+	createdAt := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		user UsersFieldsType
+		want string
+	}{
+		{
+			"complete user",
+			UsersFieldsType{ID: "alice", Name: "Alice", Price: 999, Currency: "USD", Type: "speaker", Year: "2024", Slug: "alice", CreatedAt: createdAt},
+			"alice,Alice,9.99,USD,speaker,2024,,,alice,2024-03-01T10:00:00Z,",
+		},
+		{"empty user", UsersFieldsType{}, ",,0.00,,,,,,,,"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := firestoreFieldValues(reflect.ValueOf(tt.user))
+
+			if len(got) != len(firestoreFieldNames(reflect.TypeOf(tt.user))) {
+				t.Errorf("%d values for %d names", len(got), len(firestoreFieldNames(reflect.TypeOf(tt.user))))
+			}
+			if strings.Join(got, ",") != tt.want {
+				t.Errorf("firestoreFieldValues() = %s, want %s", strings.Join(got, ","), tt.want)
+			}
+		})
+	}
+}
+
+func TestUsersExportAPI(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		uid        string
+		wantStatus int
+	}{
+		{"unauthenticated", http.MethodGet, "/users/export?format=csv", "", http.StatusForbidden},
+		{"non-admin", http.MethodGet, "/users/export?format=csv", "alice", http.StatusForbidden},
+		{"non-admin ndjson", http.MethodGet, "/users/export?format=ndjson", "alice", http.StatusForbidden},
+		{"unsupported format", http.MethodGet, "/users/export?format=xml", "admin", http.StatusBadRequest},
+		{"unsupported method", http.MethodPost, "/users/export", "admin", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeFirebase(t, newOfflineClient(t), nil)
+
+			w := serveJSON(UsersExportAPI, tt.method, tt.target, tt.uid, "")
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
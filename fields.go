@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// userFieldNames are the fields a user projection may select.
+var userFieldNames = firestoreFieldNames(reflect.TypeOf(UsersFieldsType{}))
+
+// parseFields reads the comma-separated fields query parameter, rejecting
+// names missing from allowed. It returns nil when no projection is requested.
+func parseFields(r *http.Request, allowed []string) ([]string, *FieldError) {
+	param := r.URL.Query().Get("fields")
+	if param == "" {
+		return nil, nil
+	}
+
+	var fields []string
+	for _, field := range strings.Split(param, ",") {
+		field = strings.TrimSpace(field)
+		if !containsString(allowed, field) {
+			return nil, &FieldError{Field: "fields", Message: "unknown field " + field}
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// projectFields returns a copy of data holding only the given fields, or data
+// itself when fields is nil.
+func projectFields(data map[string]interface{}, fields []string) map[string]interface{} {
+	if fields == nil {
+		return data
+	}
+
+	projected := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		if value, ok := data[field]; ok {
+			projected[field] = value
+		}
+	}
+	return projected
+}
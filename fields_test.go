@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  string
+		want    []string
+		wantErr bool
+	}{
+		{"no projection", "", nil, false},
+		{"single", "uid", []string{"uid"}, false},
+		{"several with spaces", "uid, displayName ,price", []string{"uid", "displayName", "price"}, false},
+		{"unknown", "uid,password", nil, true},
+		{"Go field name", "Name", nil, true},
+		{"empty name", "uid,", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/users?uid=alice&fields="+url.QueryEscape(tt.fields), nil)
+
+			got, fieldErr := parseFields(r, userFieldNames)
+
+			if (fieldErr != nil) != tt.wantErr {
+				t.Fatalf("parseFields(%q) error = %v, want error %v", tt.fields, fieldErr, tt.wantErr)
+			}
+			if fieldErr != nil && fieldErr.Field != "fields" {
+				t.Errorf("error field = %s, want fields", fieldErr.Field)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") || (got == nil) != (tt.want == nil) {
+				t.Errorf("parseFields(%q) = %v, want %v", tt.fields, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProjectFields(t *testing.T) {
+	data := map[string]interface{}{"uid": "alice", "displayName": "Alice", "price": int64(999)}
+
+	tests := []struct {
+		name   string
+		fields []string
+		want   map[string]interface{}
+	}{
+		{"no projection", nil, data},
+		{"subset", []string{"uid", "price"}, map[string]interface{}{"uid": "alice", "price": int64(999)}},
+		{"missing field", []string{"uid", "slug"}, map[string]interface{}{"uid": "alice"}},
+		{"empty", []string{}, map[string]interface{}{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := projectFields(data, tt.fields)
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("projectFields(%v) = %v, want %v", tt.fields, got, tt.want)
+			}
+			for field, want := range tt.want {
+				if got[field] != want {
+					t.Errorf("%s = %v, want %v", field, got[field], want)
+				}
+			}
+			if len(data) != 3 {
+				t.Errorf("data changed to %v", data)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"cloud.google.com/go/firestore"
+	"firebase.google.com/go/auth"
+	"google.golang.org/api/iterator"
+)
+
+// GroupsType represents the Groups collection in the database
+type GroupsType []map[string]interface{}
+
+// GroupFieldsType defines the structure of the fields in a Group from the Groups collection.
+type GroupFieldsType struct {
+	ID      string   `firestore:"uid" json:"uid"`
+	Name    string   `firestore:"displayName" json:"displayName"`
+	Members []string `firestore:"members" json:"members"`
+}
+
+// GroupsAPI is an HTTP Cloud Function listing the groups a user belongs to.
+func GroupsAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := projectContext(r)
+
+	app, client, ok := initFirebase(w, r)
+	if !ok {
+		return
+	}
+
+	if handleCORS(w, r, http.MethodGet, http.MethodOptions) {
+		return
+	}
+
+	// Bound the Firestore work so a hung call can't block the request
+	ctx, cancel := context.WithTimeout(ctx, firestoreTimeout)
+	defer cancel()
+
+	switch method := r.Method; method {
+	case http.MethodGet:
+		token := authorizeRequest(w, app, r)
+		if token == nil {
+			return
+		}
+		setPrivateNoStore(w)
+		getGroups(ctx, client, token, w, r)
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodOptions)
+	}
+}
+
+func getGroups(ctx context.Context, client *firestore.Client, token *auth.Token, w http.ResponseWriter, r *http.Request) {
+	member := r.URL.Query().Get("member")
+	if member == "" {
+		writeBadRequest(w, "member query parameter is required")
+		return
+	}
+	if token.UID != member && !requireClaim(token, "admin") {
+		writeForbidden(w, "You can only list your own groups")
+		return
+	}
+
+	Groups := GroupsType{}
+
+	iter := client.Collection(collections.Groups).Where("members", "array-contains", member).Limit(parseLimit(r)).Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			writeFirestoreError(w, "Iteration over documents failed", err)
+			return
+		}
+
+		Groups = append(Groups, doc.Data())
+	}
+
+	writeJSONWithETag(w, r, map[string]interface{}{
+		"data": Groups,
+	})
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestGroupsAPI(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		uid        string
+		wantStatus int
+	}{
+		{"unsupported method", http.MethodPost, "/groups?member=alice", "alice", http.StatusMethodNotAllowed},
+		{"unauthenticated", http.MethodGet, "/groups?member=alice", "", http.StatusForbidden},
+		{"without member", http.MethodGet, "/groups", "alice", http.StatusBadRequest},
+		{"groups of another user", http.MethodGet, "/groups?member=bob", "alice", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeFirebase(t, newOfflineClient(t), nil)
+
+			w := serveJSON(GroupsAPI, tt.method, tt.target, tt.uid, "")
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"firebase.google.com/go/auth"
+	"golang.org/x/sync/singleflight"
+)
+
+// idempotencyTTL is how long a completed response is kept for replay.
+const idempotencyTTL = 24 * time.Hour
+
+// idempotencyCache holds completed responses keyed by caller, route and
+// Idempotency-Key.
+var idempotencyCache = newTTLCache(idempotencyTTL)
+
+// recordedResponse is a response captured so it can be replayed verbatim.
+type recordedResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// responseCapture is an http.ResponseWriter that buffers the response.
+type responseCapture struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (c *responseCapture) Header() http.Header {
+	return c.header
+}
+
+func (c *responseCapture) WriteHeader(code int) {
+	if c.status == 0 {
+		c.status = code
+	}
+}
+
+func (c *responseCapture) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	return c.body.Write(b)
+}
+
+// idempotencyFlights tracks the requests still running for a key, so a
+// concurrent retry waits for the first one instead of running again.
+var idempotencyFlights singleflight.Group
+
+// withIdempotency runs handle unless a response was already recorded for the
+// caller's Idempotency-Key, in which case that response is replayed. Keys are
+// scoped to the project and the token owner, so callers can't replay each
+// other's responses. Requests without the header always run handle.
+func withIdempotency(w http.ResponseWriter, r *http.Request, token *auth.Token, handle func(w http.ResponseWriter)) {
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" {
+		handle(w)
+		return
+	}
+	cacheKey := requestProjectID(r) + " " + token.UID + " " + r.Method + " " + r.URL.Path + " " + key
+
+	recorded, _, _ := idempotencyFlights.Do(cacheKey, func() (interface{}, error) {
+		if cached, ok := idempotencyCache.Get(cacheKey); ok {
+			return cached, nil
+		}
+
+		capture := &responseCapture{header: http.Header{}}
+		handle(capture)
+		if capture.status == 0 {
+			capture.status = http.StatusOK
+		}
+
+		response := recordedResponse{
+			status: capture.status,
+			header: capture.header,
+			body:   capture.body.Bytes(),
+		}
+		// Server errors and abandoned requests are not recorded so the
+		// client can retry them
+		if response.status < http.StatusInternalServerError && response.status != statusClientClosedRequest {
+			idempotencyCache.Set(cacheKey, response)
+		}
+		return response, nil
+	})
+
+	writeRecordedResponse(w, recorded.(recordedResponse))
+}
+
+func writeRecordedResponse(w http.ResponseWriter, response recordedResponse) {
+	for name, values := range response.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(response.status)
+	w.Write(response.body)
+}
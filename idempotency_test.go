@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"firebase.google.com/go/auth"
+)
+
+func TestWithIdempotency(t *testing.T) {
+	tests := []struct {
+		name         string
+		firstStatus  int
+		noKey        bool
+		otherKey     bool
+		otherUID     bool
+		otherProject bool
+		otherPath    bool
+		wantRuns     int
+	}{
+		{name: "replayed", firstStatus: http.StatusCreated, wantRuns: 1},
+		{name: "client error replayed", firstStatus: http.StatusConflict, wantRuns: 1},
+		{name: "without key", firstStatus: http.StatusCreated, noKey: true, wantRuns: 2},
+		{name: "other key", firstStatus: http.StatusCreated, otherKey: true, wantRuns: 2},
+		{name: "other caller", firstStatus: http.StatusCreated, otherUID: true, wantRuns: 2},
+		{name: "other project", firstStatus: http.StatusCreated, otherProject: true, wantRuns: 2},
+		{name: "other route", firstStatus: http.StatusCreated, otherPath: true, wantRuns: 2},
+		{name: "server error retried", firstStatus: http.StatusInternalServerError, wantRuns: 2},
+		{name: "abandoned request retried", firstStatus: statusClientClosedRequest, wantRuns: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restore := idempotencyCache
+			idempotencyCache = newTTLCache(idempotencyTTL)
+			t.Cleanup(func() { idempotencyCache = restore })
+			const key = "8e0d7c1a"
+
+			runs := 0
+			send := func(uid string, project string, path string, key string) *httptest.ResponseRecorder {
+				r := httptest.NewRequest(http.MethodPost, path, nil)
+				if key != "" {
+					r.Header.Set("Idempotency-Key", key)
+				}
+				if project != "" {
+					r.Header.Set(projectHeader, project)
+				}
+				w := httptest.NewRecorder()
+				withIdempotency(w, r, &auth.Token{UID: uid}, func(w http.ResponseWriter) {
+					runs++
+					status := http.StatusCreated
+					if runs == 1 {
+						status = tt.firstStatus
+					}
+					w.Header().Set("Location", "/users?uid="+uid)
+					w.WriteHeader(status)
+					w.Write([]byte(`{"run": 1}`))
+				})
+				return w
+			}
+
+			first := send("alice", "", "/users", key)
+
+			uid, project, path, secondKey := "alice", "", "/users", key
+			switch {
+			case tt.noKey:
+				secondKey = ""
+			case tt.otherKey:
+				secondKey = key + " again"
+			case tt.otherUID:
+				uid = "bob"
+			case tt.otherProject:
+				project = "other-project"
+			case tt.otherPath:
+				path = "/suscriptions"
+			}
+			second := send(uid, project, path, secondKey)
+
+			if runs != tt.wantRuns {
+				t.Errorf("handler ran %d times, want %d", runs, tt.wantRuns)
+			}
+			if first.Code != tt.firstStatus {
+				t.Errorf("first status = %d, want %d", first.Code, tt.firstStatus)
+			}
+			if tt.wantRuns == 1 {
+				if second.Code != first.Code || second.Body.String() != first.Body.String() || second.Header().Get("Location") != first.Header().Get("Location") {
+					t.Errorf("replay = %d %s, want the first response %d %s", second.Code, second.Body, first.Code, first.Body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// maxImportLineSize is the longest NDJSON line accepted by the import.
+const maxImportLineSize = 1024 * 1024
+
+// ImportFailureType describes an NDJSON line that couldn't be imported
+type ImportFailureType struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// ImportResultType represents the outcome of an import
+type ImportResultType struct {
+	Created  int                 `json:"created"`
+	Failed   int                 `json:"failed"`
+	Failures []ImportFailureType `json:"failures"`
+}
+
+// UsersImportAPI is an HTTP Cloud Function that imports users from NDJSON.
+func UsersImportAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := projectContext(r)
+
+	app, client, ok := initFirebase(w, r)
+	if !ok {
+		return
+	}
+
+	if handleCORS(w, r, http.MethodPost, http.MethodOptions) {
+		return
+	}
+
+	switch method := r.Method; method {
+	case http.MethodPost:
+		if !authorizeAdmin(w, app, r) {
+			return
+		}
+		importUsers(ctx, client, w, r)
+	default:
+		writeMethodNotAllowed(w, http.MethodPost, http.MethodOptions)
+	}
+}
+
+func importUsers(ctx context.Context, client *firestore.Client, w http.ResponseWriter, r *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/x-ndjson" && mediaType != "application/ndjson" {
+		writeError(w, errorUnsupportedMediaType, "Content-Type must be application/x-ndjson", nil)
+		return
+	}
+	defer r.Body.Close()
+
+	result := ImportResultType{Failures: []ImportFailureType{}}
+	fail := func(line int, message string) {
+		result.Failed++
+		result.Failures = append(result.Failures, ImportFailureType{Line: line, Error: message})
+	}
+
+	// The BulkWriter groups the creates into batched writes
+	jobs := map[int]*firestore.BulkWriterJob{}
+	var lines []int
+	bw := client.BulkWriter(ctx)
+
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxImportLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var user UsersFieldsType
+		if err := decodeStrict(strings.NewReader(text), &user); err != nil {
+			fail(line, err.Error())
+			continue
+		}
+		if user.ID == "" {
+			fail(line, "missing user ID")
+			continue
+		}
+		if fieldErr := sanitizeUser(&user); fieldErr != nil {
+			fail(line, fieldErr.Error())
+			continue
+		}
+
+		// Timestamps are always assigned by the server
+		user.CreatedAt = time.Time{}
+		user.UpdatedAt = time.Time{}
+
+		job, err := bw.Create(client.Collection(collections.Users).Doc(user.ID), &user)
+		if err != nil {
+			fail(line, err.Error())
+			continue
+		}
+		jobs[line] = job
+		lines = append(lines, line)
+		readCache.Delete(cacheKey(ctx, collections.Users, user.ID))
+	}
+	bw.End()
+
+	if err := scanner.Err(); err != nil {
+		logErrorf("Reading import body failed %v", err)
+		writeBadRequest(w, "Reading import body failed: "+err.Error())
+		return
+	}
+
+	for _, line := range lines {
+		if _, err := jobs[line].Results(); err != nil {
+			fail(line, err.Error())
+			continue
+		}
+		result.Created++
+	}
+	sort.Slice(result.Failures, func(i, j int) bool {
+		return result.Failures[i].Line < result.Failures[j].Line
+	})
+
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUsersImportAPI(t *testing.T) {
+	tests := []struct {
+		name        string
+		uid         string
+		contentType string
+		body        string
+		wantStatus  int
+		wantLines   []int
+	}{
+		{"non-admin", "alice", "application/x-ndjson", `{"uid": "bob", "displayName": "Bob"}`, http.StatusForbidden, nil},
+		{"wrong content type", "admin", jsonContentType, `{"uid": "bob", "displayName": "Bob"}`, http.StatusUnsupportedMediaType, nil},
+		{"empty file", "admin", "application/x-ndjson", "", http.StatusOK, []int{}},
+		{
+			"only invalid lines",
+			"admin",
+			"application/ndjson",
+			"{\"uid\": \n\n{\"displayName\": \"No uid\"}\n{\"uid\": \"bob\", \"pirce\": 10}\n",
+			http.StatusOK,
+			[]int{1, 3, 4},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeFirebase(t, newOfflineClient(t), nil)
+
+			r := httptest.NewRequest(http.MethodPost, "/users/import", strings.NewReader(tt.body))
+			r.Header.Set("Authorization", tt.uid)
+			r.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			UsersImportAPI(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantLines == nil {
+				return
+			}
+			var result ImportResultType
+			if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+				t.Fatalf("Decoding result: %v", err)
+			}
+			lines := []int{}
+			for _, failure := range result.Failures {
+				lines = append(lines, failure.Line)
+			}
+			if result.Created != 0 || result.Failed != len(tt.wantLines) || !reflect.DeepEqual(lines, tt.wantLines) {
+				t.Errorf("result = %+v, want failures on lines %v", result, tt.wantLines)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// jobSecretHeader carries the shared secret schedulers authenticate with.
+const jobSecretHeader = "X-Job-Secret"
+
+// jobSecret is set with the JOB_SECRET env var. Jobs are disabled without it.
+var jobSecret = stringFromEnv("JOB_SECRET", "")
+
+// authorizeJob checks the shared secret of a scheduled job call. It returns
+// false after writing a 403 response otherwise.
+func authorizeJob(w http.ResponseWriter, r *http.Request) bool {
+	secret := r.Header.Get(jobSecretHeader)
+	if jobSecret == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(jobSecret)) != 1 {
+		writeForbidden(w, "Missing or invalid job secret")
+		return false
+	}
+	return true
+}
+
+// ExpireSubscriptionsJobAPI is an HTTP entrypoint for Cloud Scheduler that
+// runs the subscription expiry sweep and returns its summary.
+func ExpireSubscriptionsJobAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	if !authorizeJob(w, r) {
+		return
+	}
+
+	ctx := projectContext(r)
+
+	_, client, ok := initFirebase(w, r)
+	if !ok {
+		return
+	}
+
+	// The sweep visits every active subscription, so it isn't bound by
+	// firestoreTimeout nor the server write timeout like request handlers are
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	summary, err := expireSubscriptions(ctx, client)
+	if err != nil {
+		writeFirestoreError(w, "Expiring subscriptions failed", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", jsonContentType)
+	json.NewEncoder(w).Encode(summary)
+}
+
+// MigrateAmountsJobAPI is an HTTP entrypoint that converts the float amounts
+// stored before amounts were kept in minor units, returning its summary.
+func MigrateAmountsJobAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	if !authorizeJob(w, r) {
+		return
+	}
+
+	ctx := projectContext(r)
+
+	_, client, ok := initFirebase(w, r)
+	if !ok {
+		return
+	}
+
+	// Every document with an amount is visited, like the expiry sweep
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	summary, err := migrateAmounts(ctx, client)
+	if err != nil {
+		writeFirestoreError(w, "Migrating amounts failed", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", jsonContentType)
+	json.NewEncoder(w).Encode(summary)
+}
+
+// MigrateExpireAtJobAPI is an HTTP entrypoint that converts the expireAt
+// strings stored before it became a timestamp, returning its summary.
+func MigrateExpireAtJobAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	if !authorizeJob(w, r) {
+		return
+	}
+
+	ctx := projectContext(r)
+
+	_, client, ok := initFirebase(w, r)
+	if !ok {
+		return
+	}
+
+	// Every subscription and its history is visited, like the expiry sweep
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	summary, err := migrateExpireAt(ctx, client)
+	if err != nil {
+		writeFirestoreError(w, "Migrating expireAt failed", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", jsonContentType)
+	json.NewEncoder(w).Encode(summary)
+}
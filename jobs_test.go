@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthorizeJob(t *testing.T) {
+	restore := jobSecret
+	t.Cleanup(func() { jobSecret = restore })
+
+	tests := []struct {
+		name   string
+		secret string
+		header string
+		want   bool
+	}{
+		{"matching secret", "s3cret", "s3cret", true},
+		{"wrong secret", "s3cret", "guess", false},
+		{"missing header", "s3cret", "", false},
+		{"secret prefix", "s3cret", "s3c", false},
+		{"jobs disabled", "", "", false},
+		{"jobs disabled with header", "", "s3cret", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobSecret = tt.secret
+			r := httptest.NewRequest(http.MethodPost, "/jobs/expire-subscriptions", nil)
+			if tt.header != "" {
+				r.Header.Set(jobSecretHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+
+			if got := authorizeJob(w, r); got != tt.want {
+				t.Errorf("authorizeJob = %v, want %v", got, tt.want)
+			}
+			if !tt.want && w.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+			}
+		})
+	}
+}
+
+func TestJobAPIsRejectUnauthorizedCalls(t *testing.T) {
+	restore := jobSecret
+	jobSecret = "s3cret"
+	t.Cleanup(func() { jobSecret = restore })
+
+	jobs := map[string]http.HandlerFunc{
+		"/jobs/expire-subscriptions": ExpireSubscriptionsJobAPI,
+		"/jobs/migrate-amounts":      MigrateAmountsJobAPI,
+		"/jobs/migrate-expire-at":    MigrateExpireAtJobAPI,
+	}
+	tests := []struct {
+		name       string
+		method     string
+		secret     string
+		wantStatus int
+	}{
+		{"GET", http.MethodGet, "s3cret", http.StatusMethodNotAllowed},
+		{"no secret", http.MethodPost, "", http.StatusForbidden},
+		{"wrong secret", http.MethodPost, "guess", http.StatusForbidden},
+	}
+
+	for path, handler := range jobs {
+		for _, tt := range tests {
+			t.Run(path+" "+tt.name, func(t *testing.T) {
+				r := httptest.NewRequest(tt.method, path, nil)
+				r.Header.Set(jobSecretHeader, tt.secret)
+				w := httptest.NewRecorder()
+
+				handler(w, r)
+
+				if w.Code != tt.wantStatus {
+					t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"log"
+	"strings"
+)
+
+// logLevel orders the severities logs are written with.
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevelNames = map[string]logLevel{
+	"debug": levelDebug,
+	"info":  levelInfo,
+	"warn":  levelWarn,
+	"error": levelError,
+}
+
+// minLogLevel is set with the LOG_LEVEL env var. Logs below it are dropped.
+var minLogLevel = parseLogLevel(stringFromEnv("LOG_LEVEL", "info"))
+
+// parseLogLevel converts a LOG_LEVEL value, falling back to info when it's
+// unknown.
+func parseLogLevel(name string) logLevel {
+	level, ok := logLevelNames[strings.ToLower(name)]
+	if !ok {
+		log.Printf("WARN Invalid LOG_LEVEL %q, using info", name)
+		return levelInfo
+	}
+	return level
+}
+
+// logf writes the message prefixed by its level, unless minLogLevel drops it.
+func logf(level logLevel, prefix string, format string, args ...interface{}) {
+	if level < minLogLevel {
+		return
+	}
+	log.Printf(prefix+" "+format, args...)
+}
+
+func logDebugf(format string, args ...interface{}) { logf(levelDebug, "DEBUG", format, args...) }
+func logInfof(format string, args ...interface{})  { logf(levelInfo, "INFO", format, args...) }
+func logWarnf(format string, args ...interface{})  { logf(levelWarn, "WARN", format, args...) }
+func logErrorf(format string, args ...interface{}) { logf(levelError, "ERROR", format, args...) }
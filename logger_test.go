@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	tests := []struct {
+		name string
+		want logLevel
+	}{
+		{"debug", levelDebug},
+		{"INFO", levelInfo},
+		{"Warn", levelWarn},
+		{"error", levelError},
+		{"verbose", levelInfo},
+		{"", levelInfo},
+	}
+
+	for _, tt := range tests {
+		if got := parseLogLevel(tt.name); got != tt.want {
+			t.Errorf("parseLogLevel(%q) = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLogLevelFiltering(t *testing.T) {
+	var buf bytes.Buffer
+	restoreOutput, restoreFlags, restoreLevel := log.Writer(), log.Flags(), minLogLevel
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(restoreOutput)
+		log.SetFlags(restoreFlags)
+		minLogLevel = restoreLevel
+	})
+
+	tests := []struct {
+		name     string
+		minLevel logLevel
+		want     string
+	}{
+		{"debug", levelDebug, "DEBUG d|INFO i|WARN w|ERROR e"},
+		{"info", levelInfo, "INFO i|WARN w|ERROR e"},
+		{"warn", levelWarn, "WARN w|ERROR e"},
+		{"error", levelError, "ERROR e"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			minLogLevel = tt.minLevel
+
+			logDebugf("d")
+			logInfof("i")
+			logWarnf("w")
+			logErrorf("e")
+
+			got := strings.ReplaceAll(strings.TrimSpace(buf.String()), "\n", "|")
+			if got != tt.want {
+				t.Errorf("logged %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -6,12 +6,18 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
 	"github.com/gorilla/mux"
 	"errors"
 	"time"
 	firebase "firebase.google.com/go"
+	"firebase.google.com/go/auth"
 	"cloud.google.com/go/firestore"
+	"golang.org/x/sync/singleflight"
 	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type FirestoreEvent struct {
@@ -34,14 +40,29 @@ type UsersType []map[string]interface{}
 
 // UsersFieldsType defines the structure of the fields in an Users from the Users collection.
 type UsersFieldsType struct {
-	ID          string  `firestore:"uid"`
-	Name        string  `firestore:"displayName"`
-	Price       float64 `firestore:"price"`
-	Type        string  `firestore:"type"`
-	Year        string  `firestore:"year"`
-	Image       string  `firestore:"image"`
-	Description string  `firestore:"description"`
-	Slug        string  `firestore:"slug"`
+	ID          string `firestore:"uid" validate:"required"`
+	Name        string `firestore:"displayName" validate:"required"`
+	Price       Amount `firestore:"price" validate:"gte=0"`
+	Currency    string `firestore:"currency"`
+	Type        string `firestore:"type"`
+	Year        string `firestore:"year"`
+	Image       string `firestore:"image"`
+	Description string `firestore:"description"`
+	Slug        string `firestore:"slug"`
+	// Audit timestamps, assigned by Firestore when left empty on write
+	CreatedAt time.Time `firestore:"createdAt,serverTimestamp"`
+	UpdatedAt time.Time `firestore:"updatedAt,serverTimestamp"`
+}
+
+// SubscriptionFieldsType defines the structure of the fields in a Suscription from the Suscriptions collection.
+type SubscriptionFieldsType struct {
+	ID              string    `firestore:"uid" json:"uid"`
+	Expired         bool      `firestore:"expired" json:"expired"`
+	SuscriptionType string    `firestore:"suscriptionType" json:"suscriptionType"`
+	Cost            Amount    `firestore:"cost" json:"cost"`
+	Currency        string    `firestore:"currency" json:"currency"`
+	ExpireAt        time.Time `firestore:"expireAt" json:"expireAt"`
+	CreatedAt       string    `firestore:"createdAt" json:"createdAt"`
 }
 
 // DeleteType represents the body expected structure of a delete http call
@@ -49,105 +70,304 @@ type DeleteType struct {
 	ID string `json:"id"`
 }
 
+// UserDeleteType represents the body expected structure of a user delete http call
+type UserDeleteType struct {
+	ID      string `json:"id" validate:"required"`
+	// Confirm must repeat the ID, so a stray request can't delete a user
+	Confirm string `json:"confirm" validate:"required"`
+}
+
 
 func main() {
 	// This example uses gorilla/mux as the router, whereas cloud functions are simple Http handlers
 	router := mux.NewRouter()
-	router.HandleFunc("/users", UsersAPI)
-	//router.HandleFunc("/chats", UsersAPI)
-	//router.HandleFunc("/messages", UsersAPI)
-	//router.HandleFunc("/groups", UsersAPI)
+	router.HandleFunc("/me", instrument("/me", MeAPI))
+	router.HandleFunc("/users", instrument("/users", UsersAPI))
+	router.HandleFunc("/users/batch", instrument("/users/batch", UsersBatchAPI))
+	router.HandleFunc("/users/count", instrument("/users/count", UsersCountAPI))
+	router.HandleFunc("/users/list", instrument("/users/list", UsersListAPI))
+	router.HandleFunc("/users/search", instrument("/users/search", UsersSearchAPI))
+	router.HandleFunc("/users/presence", instrument("/users/presence", UsersPresenceAPI))
+	router.HandleFunc("/users/claims", instrument("/users/claims", UsersClaimsAPI))
+	router.HandleFunc("/users/revoke", instrument("/users/revoke", UsersRevokeAPI))
+	router.HandleFunc("/users/avatar", instrument("/users/avatar", UsersAvatarAPI))
+	router.HandleFunc("/storage/signed-url", instrument("/storage/signed-url", StorageSignedURLAPI))
+	router.HandleFunc("/users/export", UsersExportAPI)
+	router.HandleFunc("/users/import", instrument("/users/import", UsersImportAPI))
+	router.HandleFunc("/chats", instrument("/chats", ChatsAPI))
+	router.HandleFunc("/ws/chats/{chatId}", ChatsWebSocketAPI)
+	router.HandleFunc("/messages", instrument("/messages", MessagesAPI))
+	router.HandleFunc("/messages/read", instrument("/messages/read", MessagesReadAPI))
+	router.HandleFunc("/messages/unread", instrument("/messages/unread", MessagesUnreadAPI))
+	router.HandleFunc("/groups", instrument("/groups", GroupsAPI))
 	//router.HandleFunc("/talks", UsersAPI)
-	router.HandleFunc("/suscriptions", SuscriptionsAPI)
+	router.HandleFunc("/talks/search", instrument("/talks/search", TalksSearchAPI))
+	router.HandleFunc("/suscriptions", instrument("/suscriptions", SuscriptionsAPI))
+	router.HandleFunc("/suscriptions/renew", instrument("/suscriptions/renew", SuscriptionsRenewAPI))
+	router.HandleFunc("/suscriptions/transfer", instrument("/suscriptions/transfer", SuscriptionsTransferAPI))
+	router.HandleFunc("/suscriptions/stream", SuscriptionsStreamAPI)
+	router.HandleFunc("/suscriptions/expired", instrument("/suscriptions/expired", SuscriptionsExpiredAPI))
+	router.HandleFunc("/suscriptions/history", instrument("/suscriptions/history", SuscriptionsHistoryAPI))
+	router.HandleFunc("/suscriptions/status", instrument("/suscriptions/status", SuscriptionsStatusAPI))
+	router.HandleFunc("/suscriptions/expiring", instrument("/suscriptions/expiring", SuscriptionsExpiringAPI))
+	router.HandleFunc("/jobs/expire-subscriptions", instrument("/jobs/expire-subscriptions", ExpireSubscriptionsJobAPI))
+	router.HandleFunc("/jobs/migrate-amounts", instrument("/jobs/migrate-amounts", MigrateAmountsJobAPI))
+	router.HandleFunc("/jobs/migrate-expire-at", instrument("/jobs/migrate-expire-at", MigrateExpireAtJobAPI))
+	router.HandleFunc("/openapi.json", OpenAPIHandler)
+	router.Handle("/metrics", metricsHandler)
+	router.NotFoundHandler = http.HandlerFunc(notFoundHandler)
+
+	// The chain wraps the whole router rather than going through router.Use,
+	// which mux skips for unmatched routes
+	handler := chain(
+		loggingMiddleware,
+		recoverMiddleware,
+		httpsMiddleware,
+		concurrencyMiddleware,
+		gzipMiddleware,
+	)(router)
+
+	srv := newServer(handler)
+
+	if host := firestoreEmulatorHost(); host != "" {
+		logInfof("Using Firestore emulator at %s", host)
+	}
+
+	logInfof("Running server on http://localhost:8000")
+	log.Fatal(srv.ListenAndServe())
+}
 
-	srv := &http.Server{
-		Handler:      router,
+// newServer returns the server listening on port 8000 for handler, with the
+// timeouts configured in the environment.
+func newServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:      handler,
 		Addr:         "0.0.0.0:8000",
-		WriteTimeout: 10 * time.Second,
-		ReadTimeout:  10 * time.Second,
+		WriteTimeout: durationFromEnv("WRITE_TIMEOUT", defaultWriteTimeout),
+		ReadTimeout:  durationFromEnv("READ_TIMEOUT", defaultReadTimeout),
+		IdleTimeout:  durationFromEnv("IDLE_TIMEOUT", defaultIdleTimeout),
 	}
-
-	log.Println("Running server on http://localhost:8000")
-	log.Fatal(srv.ListenAndServe())
 }
 
 // UsersAPI is an HTTP Cloud Function with a request parameter.
 func UsersAPI(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
+	ctx := projectContext(r)
 
-	conf := &firebase.Config{ProjectID: "talkit-199f9"}
-
-	app, err := firebase.NewApp(ctx, conf)
-	if err != nil {
-		log.Printf("error initializing app: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
+	app, client, ok := initFirebase(w, r)
+	if !ok {
 		return
 	}
-	client, err := app.Firestore(ctx)
-	if err != nil {
-		log.Printf("Firestore init: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+
+	if handleCORS(w, r, http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions) {
 		return
 	}
-	defer client.Close()
 
+	// Bound the Firestore work so a hung call can't block the request
+	ctx, cancel := context.WithTimeout(ctx, firestoreTimeout)
+	defer cancel()
+
+	switch method := r.Method; method {
+	case http.MethodGet:
+		setPublicCache(w)
+		getUsers(ctx, client, w, r)
+	case http.MethodPost:
+		token := authorizeRequest(w, app, r)
+		if token == nil {
+			return
+		}
+		withIdempotency(w, r, token, func(w http.ResponseWriter) {
+			setUsers(ctx, client, token, w, r)
+		})
+	case http.MethodDelete:
+		token := authorizeRequest(w, app, r)
+		if token == nil {
+			return
+		}
+		deleteUsers(ctx, storeFor(client), token, w, r)
+	case http.MethodPut:
+		token := authorizeRequest(w, app, r)
+		if token == nil {
+			return
+		}
+		updateUsers(ctx, client, token, w, r)
+	case http.MethodPatch:
+		token := authorizeRequest(w, app, r)
+		if token == nil {
+			return
+		}
+		patchUsers(ctx, storeFor(client), token, w, r)
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions)
+	}
+
+}
+
+// jsonContentType is the Content-Type of every JSON response.
+const jsonContentType = "application/json; charset=utf-8"
+
+// firestoreTimeout bounds how long a request may wait on Firestore.
+const firestoreTimeout = 5 * time.Second
+
+// statusClientClosedRequest is the non-standard status, borrowed from nginx,
+// recorded when the client went away before the response was written.
+const statusClientClosedRequest = 499
+
+// firestoreAPIError maps a Firestore error to the catalog entry returned to the client.
+func firestoreAPIError(err error) apiError {
+	if errors.Is(err, context.Canceled) || status.Code(err) == codes.Canceled {
+		return errorClientClosedRequest
+	}
+	if errors.Is(err, context.DeadlineExceeded) || status.Code(err) == codes.DeadlineExceeded {
+		return errorGatewayTimeout
+	}
+	return errorInternal
+}
+
+// maxDocumentBytes is the largest document Firestore accepts.
+const maxDocumentBytes = 1024 * 1024
+
+// isDocumentTooLarge reports whether Firestore rejected a write because the
+// document exceeds maxDocumentBytes.
+func isDocumentTooLarge(err error) bool {
+	if status.Code(err) != codes.InvalidArgument {
+		return false
+	}
+	message := status.Convert(err).Message()
+	return strings.Contains(message, "exceeds the maximum allowed size") || strings.Contains(message, "entity is too big")
+}
+
+// writeFirestoreError logs a failed Firestore call and writes its error
+// envelope. Calls canceled by the client disconnecting aren't server errors,
+// so they aren't logged.
+func writeFirestoreError(w http.ResponseWriter, message string, err error) {
+	// The client sent more than fits in a document, which is its error to fix
+	if isDocumentTooLarge(err) {
+		logDebugf("%s %v", message, err)
+		writeError(w, errorBadRequest, "Document exceeds Firestore's 1 MiB size limit, shorten its fields", map[string]interface{}{
+			"maxBytes": maxDocumentBytes,
+		})
+		return
+	}
 
+	e := firestoreAPIError(err)
+	if e != errorClientClosedRequest {
+		logErrorf("%s %v", message, err)
+	}
+	writeError(w, e, "", nil)
+}
 
+// handleCORS sets the CORS headers for the request, allowing the given
+// methods of the route. It returns true when the request was a preflight and
+// has already been answered.
+func handleCORS(w http.ResponseWriter, r *http.Request, methods ...string) bool {
 	// Set CORS headers for the preflight request
 	if r.Method == http.MethodOptions {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "*")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
 		w.Header().Set("Access-Control-Max-Age", "3600")
 		w.WriteHeader(http.StatusNoContent)
-		return
+		return true
 	}
 	// Set CORS headers for the main request.
 	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Expose-Headers", "ETag, Location, X-Total-Count, X-Next-Cursor")
+	return false
+}
 
-	switch method := r.Method; method {
-	case http.MethodGet:
-		getUsers(ctx, client, w, r)
-	case http.MethodPost:
-		authorizeRequest(w, app, r)
-		setUsers(ctx, client, w, r)
-	case http.MethodDelete:
-		authorizeRequest(w, app, r)
-		deleteUsers(ctx, client, w, r)
-	case http.MethodPut:
-		authorizeRequest(w, app, r)
-		updateUsers(ctx, client, w, r)
-	default:
-		http.Error(w, "UNSUPPORTED METHOD", http.StatusNotFound)
+// authorizeRequest verifies the ID token sent in the Authorization header.
+// It returns nil after writing a 401 response when the token was revoked, or
+// a 403 response when it is otherwise invalid.
+func authorizeRequest(w http.ResponseWriter, app *firebase.App, r *http.Request) *auth.Token {
+	// Read Auth Jwt to access to this api
+	idToken := r.Header.Get("Authorization")
+	token, authErr := verifyIDToken(r.Context(), app, idToken)
+
+	if auth.IsIDTokenRevoked(authErr) {
+		writeError(w, errorUnauthorized, "Your session was revoked, please sign in again", nil)
+		return nil
+	}
+	if authErr != nil {
+		writeForbidden(w, "You are trying to access to this api with malformed or unhauthenticated user")
+		return nil
 	}
 
+	// The token carries PII and claims, only the uid is worth logging
+	logDebugf("Verified ID token for uid %s", token.UID)
+
+	return token
 }
 
-func authorizeRequest(w http.ResponseWriter, app *firebase.App, r *http.Request ) {
-	ctx := context.Background()
+// verifyIDToken verifies idToken with the Auth client of app. Tests replace it
+// to authenticate requests without a Firebase project.
+var verifyIDToken = func(ctx context.Context, app *firebase.App, idToken string) (*auth.Token, error) {
+	authClient, err := app.Auth(ctx)
+	if err != nil {
+		log.Fatalf("error getting Auth client: %v\n", err)
+	}
 
-	auth, authErr := app.Auth(ctx)
-	if authErr != nil {
-		log.Fatalf("error getting Auth client: %v\n", authErr)
+	if checkRevokedTokens {
+		return authClient.VerifyIDTokenAndCheckRevoked(ctx, idToken)
 	}
+	return authClient.VerifyIDToken(ctx, idToken)
+}
 
-	// Read Auth Jwt to access to this api
-	token, authErr := auth.VerifyIDToken(ctx, r.Header.Get("Authorization"))
+// requireClaim reports whether the verified token carries the given boolean
+// custom claim, e.g. "admin".
+func requireClaim(token *auth.Token, claim string) bool {
+	if token == nil {
+		return false
+	}
+	value, ok := token.Claims[claim].(bool)
+	return ok && value
+}
 
-	if authErr != nil {
-		w.Header().Set("content-type", "application/json")
-		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"error": "FORBIDDEN",
-			"statusCode": 403,
-			"data": nil,
-			"message": "You are trying to access to this api with malformed or unhauthenticated user",
-		})
-		return
+// authorizeAdmin verifies the request token and requires the admin claim.
+// It returns false after writing a 403 response otherwise.
+func authorizeAdmin(w http.ResponseWriter, app *firebase.App, r *http.Request) bool {
+	token := authorizeRequest(w, app, r)
+	if token == nil {
+		return false
+	}
+	if !requireClaim(token, "admin") {
+		writeForbidden(w, "This operation requires admin privileges")
+		return false
 	}
+	return true
+}
 
-	log.Printf("Verified ID token: %v\n", token)
+// writeNotFound writes the 404 error envelope with the given message.
+func writeNotFound(w http.ResponseWriter, message string) {
+	writeError(w, errorNotFound, message, nil)
+}
+
+// writeBadRequest writes the 400 error envelope with the given message.
+func writeBadRequest(w http.ResponseWriter, message string) {
+	writeError(w, errorBadRequest, message, nil)
+}
+
+// writeInternalError writes the 500 error envelope. The cause is only logged,
+// never sent to the client.
+func writeInternalError(w http.ResponseWriter) {
+	writeError(w, errorInternal, "", nil)
+}
+
+// writeMethodNotAllowed writes the 405 error envelope along with the Allow
+// header listing the methods the route supports.
+func writeMethodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, errorMethodNotAllowed, "", nil)
+}
 
+// canModifyUser reports whether the token owner may modify the user document
+// with the given ID. Admins may modify any user.
+func canModifyUser(token *auth.Token, uid string) bool {
+	return token.UID == uid || requireClaim(token, "admin")
+}
+
+// writeForbidden writes the 403 error envelope with the given message.
+func writeForbidden(w http.ResponseWriter, message string) {
+	writeError(w, errorForbidden, message, nil)
 }
 
 
@@ -161,28 +381,40 @@ func HandleUserCreate(ctx context.Context, client *firestore.Client, w http.Resp
 	// This is the data that's in the database itself
 	newFields := e.Value.Fields
 
-	//Set time for createdAt and ExpireAt
-	location,_ := time.LoadLocation("America/Buenos_Aires")
+	if !enableFreeTrial {
+		logDebugf("Free trials are disabled, skipping the bootstrap of %s", newFields.ID)
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
 
-	// this should give you time in location
-	t := time.Now().In(location)
+	//Set time for createdAt and ExpireAt in the configured timezone
+	t := time.Now().In(defaultLocation)
 
 
 	//
-	suscription := map[string]interface{}{
-		"expired":   false,
-		"suscriptionType": "free-trial",
-		"cost":    0,
-		"expireAt": t.AddDate(0, 0, 7 * 12).Format(http.TimeFormat),
-		"createdAt": t.Format(http.TimeFormat),
+	suscription := SubscriptionFieldsType{
+		ID:              newFields.ID,
+		Expired:         false,
+		SuscriptionType: "free-trial",
+		Cost:            0,
+		Currency:        defaultCurrency,
+		ExpireAt:        t.AddDate(0, 0, intFromEnv("FREE_TRIAL_DAYS", defaultFreeTrialDays)),
+		CreatedAt:       t.Format(http.TimeFormat),
+	}
+
+	err := withWriteRetry(ctx, func() error {
+		_, err := client.Collection(collections.Suscriptions).Doc(newFields.ID).Create(ctx, &suscription)
+		return err
+	})
+	readCache.Delete(cacheKey(ctx, collections.Suscriptions, newFields.ID))
+	// A re-triggered bootstrap finds the subscription already in place, which
+	// must be left untouched rather than reset to a new trial
+	if status.Code(err) == codes.AlreadyExists {
+		w.WriteHeader(http.StatusOK)
+		return nil
 	}
-
-	jsonSuscription, err := json.Marshal(suscription)
-
-	_, err = client.Collection("Suscriptions").Doc(newFields.ID).Create(ctx, &jsonSuscription)
 	if err != nil {
-		log.Printf("Collection update failed %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeFirestoreError(w, "Collection update failed", err)
 		return err
 	}
 
@@ -191,234 +423,460 @@ func HandleUserCreate(ctx context.Context, client *firestore.Client, w http.Resp
 	return nil
 }
 
+// userReads collapses concurrent identical user lookups of getUsers.
+var userReads singleflight.Group
+
 func getUsers(ctx context.Context, client *firestore.Client, w http.ResponseWriter, r *http.Request) {
-	var Users UsersType
+	if r.URL.Query().Get("uids") != "" {
+		getUsersByIDs(ctx, client, w, r)
+		return
+	}
+
 	uid := r.URL.Query().Get("uid")
-	iter := client.Collection("user").Where("uid", "==", uid).Documents(ctx)
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
+	slug := r.URL.Query().Get("slug")
+
+	if uid == "" && slug == "" {
+		writeBadRequest(w, "Either uid, uids or slug query parameter is required")
+		return
+	}
+
+	fields, fieldErr := parseFields(r, userFieldNames)
+	if fieldErr != nil {
+		writeFieldError(w, fieldErr)
+		return
+	}
+
+	// Only uid lookups are cached, since writes invalidate entries by uid.
+	// Entries hold the whole document, the projection is applied on reads
+	if uid != "" {
+		if cached, ok := readCache.Get(cacheKey(ctx, collections.Users, uid)); ok {
+			writeJSONWithETag(w, r, projectFields(formatAmounts(cached.(map[string]interface{})), fields))
+			return
 		}
-		if err != nil {
-			log.Printf("Iteration over documents failed %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
+	}
+
+	// Concurrent reads of the same user in the same project share a single
+	// Firestore call
+	key := projectFromContext(ctx) + " uid:" + uid
+	if uid == "" {
+		key = projectFromContext(ctx) + " slug:" + slug
+	}
+	// The flight outlives any single caller, so it runs on its own deadline
+	// and each caller only stops waiting on its own cancellation
+	flight := userReads.DoChan(key, func() (interface{}, error) {
+		flightCtx, cancel := context.WithTimeout(context.Background(), firestoreTimeout)
+		defer cancel()
+		return findUser(flightCtx, client, uid, slug)
+	})
+	var user map[string]interface{}
+	select {
+	case result := <-flight:
+		if result.Err != nil {
+			writeFirestoreError(w, "Fetching user failed", result.Err)
 			return
 		}
+		user = result.Val.(map[string]interface{})
+	case <-ctx.Done():
+		writeFirestoreError(w, "Fetching user failed", ctx.Err())
+		return
+	}
 
-		Users = append(Users, doc.Data())
+	if uid != "" && user != nil {
+		readCache.Set(cacheKey(ctx, collections.Users, uid), user)
 	}
 
-	if Users != nil {
-		json.NewEncoder(w).Encode(Users[0])
+	if user != nil {
+		writeJSONWithETag(w, r, projectFields(formatAmounts(user), fields))
 	} else {
-		w.Header().Set("content-type", "application/json")
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"error": "NOT_FOUND",
-			"statusCode": 404,
-			"data": nil,
-			"message": "User uid not found",
-		})
+		writeNotFound(w, "User not found")
 	}
 }
 
-func setUsers(ctx context.Context, client *firestore.Client, w http.ResponseWriter, r *http.Request) {
-	body, err := ioutil.ReadAll(r.Body)
+// findUser fetches a user document by uid, or by slug when no uid is given.
+// It returns a nil map when no document matches.
+func findUser(ctx context.Context, client *firestore.Client, uid string, slug string) (map[string]interface{}, error) {
+	// uid is the document ID, so it can be read directly
+	if uid != "" {
+		return findDocument(ctx, storeFor(client), collections.Users, uid)
+	}
+
+	iter := client.Collection(collections.Users).Where("slug", "==", slug).Limit(1).Documents(ctx)
+	defer iter.Stop()
+	doc, err := iter.Next()
+	if err == iterator.Done {
+		return nil, nil
+	}
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	return doc.Data(), nil
+}
+
+func setUsers(ctx context.Context, client *firestore.Client, token *auth.Token, w http.ResponseWriter, r *http.Request) {
+	if !requireJSON(w, r) {
+		return
+	}
+
 	defer r.Body.Close()
 
 	var newUsers UsersFieldsType
 
-	err = json.Unmarshal(body, &newUsers)
+	err := decodeStrict(r.Body, &newUsers)
 	if err != nil {
-		log.Printf("Unmarshalling json failed %v", err)
-		w.WriteHeader(http.StatusBadRequest)
+		logDebugf("Unmarshalling json failed %v", err)
+		writeDecodeError(w, err)
+		return
+	}
+
+	// Users are keyed by their uid, so the ID defaults to the caller's. Only
+	// admins create users for someone else, or leave the ID out for Firestore
+	// to assign it; the response and its Location header tell which it got
+	if newUsers.ID == "" && !requireClaim(token, "admin") {
+		newUsers.ID = token.UID
+	}
+	if !canModifyUser(token, newUsers.ID) {
+		writeForbidden(w, "You can only create your own user")
+		return
+	}
+
+	var docRef *firestore.DocumentRef
+	if newUsers.ID == "" {
+		docRef = client.Collection(collections.Users).NewDoc()
+		newUsers.ID = docRef.ID
+	} else {
+		docRef = client.Collection(collections.Users).Doc(newUsers.ID)
+	}
+
+	if fieldErr := sanitizeUser(&newUsers); fieldErr != nil {
+		writeFieldError(w, fieldErr)
+		return
+	}
+	if fieldErrs := validateStruct(&newUsers); fieldErrs != nil {
+		writeFieldErrors(w, fieldErrs)
+		return
+	}
+
+	// Derive a slug from the name when the client didn't send one
+	if newUsers.Slug == "" {
+		if base := slugify(newUsers.Name); base != "" {
+			newUsers.Slug, err = uniqueSlug(ctx, client, collections.Users, base)
+			if err != nil {
+				writeFirestoreError(w, "Generating slug failed", err)
+				return
+			}
+		}
+	}
+
+	// Timestamps are always assigned by the server
+	newUsers.CreatedAt = time.Time{}
+	newUsers.UpdatedAt = time.Time{}
+
+	// Check the slug inside the transaction so two creates can't claim it at once
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if newUsers.Slug != "" {
+			docs, err := tx.Documents(client.Collection(collections.Users).Where("slug", "==", newUsers.Slug).Limit(1)).GetAll()
+			if err != nil {
+				return err
+			}
+			if len(docs) > 0 {
+				return errSlugTaken
+			}
+		}
+		return tx.Create(docRef, &newUsers)
+	})
+	readCache.Delete(cacheKey(ctx, collections.Users, newUsers.ID))
+	if errors.Is(err, errSlugTaken) {
+		writeError(w, errorConflict, "Slug is already in use", nil)
+		return
+	}
+	if status.Code(err) == codes.AlreadyExists {
+		writeError(w, errorConflict, "User already exists", nil)
 		return
 	}
-	_, err = client.Collection("Users").Doc(newUsers.ID).Create(ctx, &newUsers)
 	if err != nil {
-		log.Printf("Collection update failed %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeFirestoreError(w, "Collection update failed", err)
 		return
 	}
 
+	// Read the document back so the response includes server-assigned fields
+	doc, err := docRef.Get(ctx)
+	if err != nil {
+		writeFirestoreError(w, "Reading created document failed", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", jsonContentType)
+	w.Header().Set("Location", "/users?uid="+url.QueryEscape(newUsers.ID))
 	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(formatAmounts(doc.Data()))
 }
 
-func deleteUsers(ctx context.Context, client *firestore.Client, w http.ResponseWriter, r *http.Request) {
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		log.Printf("Reading request body failed %v", err)
-		w.WriteHeader(http.StatusBadRequest)
+func deleteUsers(ctx context.Context, store documentStore, token *auth.Token, w http.ResponseWriter, r *http.Request) {
+	if !requireJSON(w, r) {
 		return
 	}
+
 	defer r.Body.Close()
 
-	var Body DeleteType
+	var Body UserDeleteType
 
-	err = json.Unmarshal(body, &Body)
+	err := decodeStrict(r.Body, &Body)
 	if err != nil {
-		log.Printf("Unmarshalling json failed %v", err)
-		w.WriteHeader(http.StatusBadRequest)
+		logDebugf("Unmarshalling json failed %v", err)
+		writeDecodeError(w, err)
 		return
 	}
 
-	_, err = client.Collection("Users").Doc(Body.ID).Delete(ctx)
+	if fieldErrs := validateStruct(&Body); fieldErrs != nil {
+		writeFieldErrors(w, fieldErrs)
+		return
+	}
+
+	if Body.Confirm != Body.ID {
+		writeFieldError(w, &FieldError{Field: "confirm", Message: "must equal id to confirm the deletion"})
+		return
+	}
+
+	if !canModifyUser(token, Body.ID) {
+		writeForbidden(w, "You can only modify your own user")
+		return
+	}
+
+	err = withWriteRetry(ctx, func() error {
+		return store.Delete(ctx, collections.Users, Body.ID)
+	})
+	readCache.Delete(cacheKey(ctx, collections.Users, Body.ID))
+	if status.Code(err) == codes.NotFound {
+		writeNotFound(w, "User not found")
+		return
+	}
 	if err != nil {
-		log.Printf("Document deletion failed %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeFirestoreError(w, "Document deletion failed", err)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
-func updateUsers(ctx context.Context, client *firestore.Client, w http.ResponseWriter, r *http.Request) {
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		log.Printf("Reading request body failed %v", err)
-		w.WriteHeader(http.StatusBadRequest)
+func updateUsers(ctx context.Context, client *firestore.Client, token *auth.Token, w http.ResponseWriter, r *http.Request) {
+	if !requireJSON(w, r) {
 		return
 	}
+
 	defer r.Body.Close()
 
 	var Body UsersFieldsType
 
-	err = json.Unmarshal(body, &Body)
+	err := decodeStrict(r.Body, &Body)
 	if err != nil {
-		log.Printf("Unmarshalling json failed %v", err)
-		w.WriteHeader(http.StatusBadRequest)
+		logDebugf("Unmarshalling json failed %v", err)
+		writeDecodeError(w, err)
 		return
 	}
 
-	_, err = client.Collection("Users").Doc(Body.ID).Set(ctx, &Body)
+	if !canModifyUser(token, Body.ID) {
+		writeForbidden(w, "You can only modify your own user")
+		return
+	}
+
+	if fieldErr := sanitizeUser(&Body); fieldErr != nil {
+		writeFieldError(w, fieldErr)
+		return
+	}
+	if fieldErrs := validateStruct(&Body); fieldErrs != nil {
+		writeFieldErrors(w, fieldErrs)
+		return
+	}
+
+	precondition, err := parseUpdatePrecondition(r)
 	if err != nil {
-		log.Printf("Document update failed %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeBadRequest(w, err.Error())
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-}
+	docRef := client.Collection(collections.Users).Doc(Body.ID)
 
-// UsersAPI is an HTTP Cloud Function with a request parameter.
-func SuscriptionsAPI(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
+	// Timestamps are always assigned by the server
+	Body.CreatedAt = time.Time{}
+	Body.UpdatedAt = time.Time{}
 
-	conf := &firebase.Config{ProjectID: "talkit-199f9"}
+	// Read and write in a transaction so the precondition still holds when
+	// the document is replaced
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		current, err := tx.Get(docRef)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if precondition != nil && !precondition(documentUpdatedAt(current)) {
+			return errPreconditionFailed
+		}
 
-	app, err := firebase.NewApp(ctx, conf)
-	if err != nil {
-		log.Printf("error initializing app: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		// Keep the original createdAt so the full replace doesn't reset it
+		if current.Exists() {
+			if createdAt, err := current.DataAt("createdAt"); err == nil {
+				if t, ok := createdAt.(time.Time); ok {
+					Body.CreatedAt = t
+				}
+			}
+		}
+
+		// Only the user fields are replaced, the presence kept by
+		// /users/presence outlives the PUT
+		data := userData(Body)
+		if current.Exists() {
+			for _, field := range presenceFields {
+				if value, err := current.DataAt(field); err == nil {
+					data[field] = value
+				}
+			}
+		}
+		return tx.Set(docRef, data)
+	})
+	readCache.Delete(cacheKey(ctx, collections.Users, Body.ID))
+	if errors.Is(err, errPreconditionFailed) {
+		writeError(w, errorPreconditionFailed, "User was modified since the given update time", nil)
 		return
 	}
-	client, err := app.Firestore(ctx)
 	if err != nil {
-		log.Printf("Firestore init: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeFirestoreError(w, "Document update failed", err)
 		return
 	}
-	defer client.Close()
 
+	w.WriteHeader(http.StatusOK)
+}
 
+// SuscriptionsAPI is an HTTP Cloud Function with a request parameter.
+func SuscriptionsAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := projectContext(r)
 
-	// Set CORS headers for the preflight request
-	if r.Method == http.MethodOptions {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "*")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		w.Header().Set("Access-Control-Max-Age", "3600")
-		w.WriteHeader(http.StatusNoContent)
+	app, client, ok := initFirebase(w, r)
+	if !ok {
 		return
 	}
-	// Set CORS headers for the main request.
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if handleCORS(w, r, http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodOptions) {
+		return
+	}
+
+	// Bound the Firestore work so a hung call can't block the request
+	ctx, cancel := context.WithTimeout(ctx, firestoreTimeout)
+	defer cancel()
 
 	switch method := r.Method; method {
 	case http.MethodGet:
+		setPrivateNoStore(w)
 		getSuscriptions(ctx, client, w, r)
 	case http.MethodPost:
-		authorizeRequest(w, app, r)
-		setSuscriptions(ctx, client, w, r)
+		token := authorizeRequest(w, app, r)
+		if token == nil {
+			return
+		}
+		withIdempotency(w, r, token, func(w http.ResponseWriter) {
+			setSuscriptions(ctx, client, token, w, r)
+		})
 	case http.MethodDelete:
-		authorizeRequest(w, app, r)
+		if !authorizeAdmin(w, app, r) {
+			return
+		}
 		deleteSuscriptions(ctx, client, w, r)
 	case http.MethodPut:
-		authorizeRequest(w, app, r)
+		if !authorizeAdmin(w, app, r) {
+			return
+		}
 		updateSuscriptions(ctx, client, w, r)
 	default:
-		http.Error(w, "UNSUPPORTED METHOD", http.StatusNotFound)
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodOptions)
 	}
 
 }
 
 
 func getSuscriptions(ctx context.Context, client *firestore.Client, w http.ResponseWriter, r *http.Request) {
-	var Users UsersType
+	var Suscriptions []SubscriptionFieldsType
 	uid := r.URL.Query().Get("uid")
-	iter := client.Collection("user").Where("uid", "==", uid).Documents(ctx)
+
+	if cached, ok := readCache.Get(cacheKey(ctx, collections.Suscriptions, uid)); ok {
+		writeJSONWithETag(w, r, cached)
+		return
+	}
+
+	iter := client.Collection(collections.Suscriptions).Where("uid", "==", uid).Documents(ctx)
 	for {
 		doc, err := iter.Next()
 		if err == iterator.Done {
 			break
 		}
 		if err != nil {
-			log.Printf("Iteration over documents failed %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
+			writeFirestoreError(w, "Iteration over documents failed", err)
 			return
 		}
 
-		Users = append(Users, doc.Data())
+		var suscription SubscriptionFieldsType
+		if err := doc.DataTo(&suscription); err != nil {
+			logErrorf("Decoding document failed %v", err)
+			writeInternalError(w)
+			return
+		}
+
+		Suscriptions = append(Suscriptions, suscription)
 	}
 
-	if Users != nil {
-		json.NewEncoder(w).Encode(Users[0])
+	if Suscriptions != nil {
+		readCache.Set(cacheKey(ctx, collections.Suscriptions, uid), Suscriptions[0])
+		writeJSONWithETag(w, r, Suscriptions[0])
 	} else {
-		w.Header().Set("content-type", "application/json")
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"error": "NOT_FOUND",
-			"statusCode": 404,
-			"data": nil,
-			"message": "User uid not found",
-		})
+		writeNotFound(w, "Suscription uid not found")
 	}
 }
 
-func setSuscriptions(ctx context.Context, client *firestore.Client, w http.ResponseWriter, r *http.Request) {
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		panic(err)
+func setSuscriptions(ctx context.Context, client *firestore.Client, token *auth.Token, w http.ResponseWriter, r *http.Request) {
+	if !requireJSON(w, r) {
+		return
 	}
+
 	defer r.Body.Close()
 
-	var newUsers UsersFieldsType
+	var newSuscription SubscriptionFieldsType
 
-	err = json.Unmarshal(body, &newUsers)
+	err := decodeStrict(r.Body, &newSuscription)
 	if err != nil {
-		log.Printf("Unmarshalling json failed %v", err)
-		w.WriteHeader(http.StatusBadRequest)
+		logDebugf("Unmarshalling json failed %v", err)
+		writeDecodeError(w, err)
+		return
+	}
+
+	if !canModifyUser(token, newSuscription.ID) {
+		writeForbidden(w, "You can only create your own suscription")
+		return
+	}
+
+	if fieldErr := validateSuscriptionType(newSuscription.SuscriptionType); fieldErr != nil {
+		writeFieldError(w, fieldErr)
+		return
+	}
+	if fieldErr := sanitizeCurrency(&newSuscription.Currency); fieldErr != nil {
+		writeFieldError(w, fieldErr)
 		return
 	}
-	_, err = client.Collection("Suscriptions").Doc(newUsers.ID).Create(ctx, &newUsers)
+	err = withWriteRetry(ctx, func() error {
+		_, err := client.Collection(collections.Suscriptions).Doc(newSuscription.ID).Create(ctx, &newSuscription)
+		return err
+	})
+	readCache.Delete(cacheKey(ctx, collections.Suscriptions, newSuscription.ID))
 	if err != nil {
-		log.Printf("Collection update failed %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeFirestoreError(w, "Collection update failed", err)
 		return
 	}
 
+	w.Header().Set("Location", "/suscriptions?uid="+url.QueryEscape(newSuscription.ID))
 	w.WriteHeader(http.StatusCreated)
 }
 
 func deleteSuscriptions(ctx context.Context, client *firestore.Client, w http.ResponseWriter, r *http.Request) {
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("Reading request body failed %v", err)
-		w.WriteHeader(http.StatusBadRequest)
+		logDebugf("Reading request body failed %v", err)
+		writeBadRequest(w, "Reading the request body failed")
 		return
 	}
 	defer r.Body.Close()
@@ -427,15 +885,18 @@ func deleteSuscriptions(ctx context.Context, client *firestore.Client, w http.Re
 
 	err = json.Unmarshal(body, &Body)
 	if err != nil {
-		log.Printf("Unmarshalling json failed %v", err)
-		w.WriteHeader(http.StatusBadRequest)
+		logDebugf("Unmarshalling json failed %v", err)
+		writeDecodeError(w, err)
 		return
 	}
 
-	_, err = client.Collection("Suscriptions").Doc(Body.ID).Delete(ctx)
+	err = withRetry(ctx, func() error {
+		_, err := client.Collection(collections.Suscriptions).Doc(Body.ID).Delete(ctx)
+		return err
+	})
+	readCache.Delete(cacheKey(ctx, collections.Suscriptions, Body.ID))
 	if err != nil {
-		log.Printf("Document deletion failed %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeFirestoreError(w, "Document deletion failed", err)
 		return
 	}
 
@@ -443,27 +904,58 @@ func deleteSuscriptions(ctx context.Context, client *firestore.Client, w http.Re
 }
 
 func updateSuscriptions(ctx context.Context, client *firestore.Client, w http.ResponseWriter, r *http.Request) {
+	if !requireJSON(w, r) {
+		return
+	}
+
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("Reading request body failed %v", err)
-		w.WriteHeader(http.StatusBadRequest)
+		logDebugf("Reading request body failed %v", err)
+		writeBadRequest(w, "Reading the request body failed")
 		return
 	}
 	defer r.Body.Close()
 
-	var Body UsersFieldsType
+	var Body SubscriptionFieldsType
 
 	err = json.Unmarshal(body, &Body)
 	if err != nil {
-		log.Printf("Unmarshalling json failed %v", err)
-		w.WriteHeader(http.StatusBadRequest)
+		logDebugf("Unmarshalling json failed %v", err)
+		writeDecodeError(w, err)
+		return
+	}
+
+	if fieldErr := validateSuscriptionType(Body.SuscriptionType); fieldErr != nil {
+		writeFieldError(w, fieldErr)
+		return
+	}
+	if fieldErr := sanitizeCurrency(&Body.Currency); fieldErr != nil {
+		writeFieldError(w, fieldErr)
 		return
 	}
 
-	_, err = client.Collection("Suscriptions").Doc(Body.ID).Set(ctx, &Body)
+	docRef := client.Collection(collections.Suscriptions).Doc(Body.ID)
+
+	// Replace the document and keep its previous state in the history
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		current, err := tx.Get(docRef)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if current.Exists() {
+			var previous SubscriptionFieldsType
+			if err := current.DataTo(&previous); err != nil {
+				return err
+			}
+			if err := recordSuscriptionHistory(tx, docRef, previous, "update"); err != nil {
+				return err
+			}
+		}
+		return tx.Set(docRef, &Body)
+	})
+	readCache.Delete(cacheKey(ctx, collections.Suscriptions, Body.ID))
 	if err != nil {
-		log.Printf("Document update failed %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeFirestoreError(w, "Document update failed", err)
 		return
 	}
 
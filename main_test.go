@@ -0,0 +1,734 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	firebase "firebase.google.com/go"
+	"firebase.google.com/go/auth"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// fakeFirebase makes handlers run on client, and on store when it isn't nil,
+// without a Firebase project. The Authorization header is accepted as the uid
+// of the caller, except when empty, and the caller "admin" has the admin claim.
+func fakeFirebase(t *testing.T, client *firestore.Client, store documentStore) {
+	t.Helper()
+
+	restoreInit, restoreVerify, restoreStore := initFirebase, verifyIDToken, storeFor
+	t.Cleanup(func() {
+		initFirebase, verifyIDToken, storeFor = restoreInit, restoreVerify, restoreStore
+	})
+
+	initFirebase = func(w http.ResponseWriter, r *http.Request) (*firebase.App, *firestore.Client, bool) {
+		return nil, client, true
+	}
+	verifyIDToken = func(ctx context.Context, app *firebase.App, idToken string) (*auth.Token, error) {
+		if idToken == "" {
+			return nil, errors.New("missing ID token")
+		}
+		if idToken == "admin" {
+			return &auth.Token{UID: idToken, Claims: map[string]interface{}{"admin": true}}, nil
+		}
+		return &auth.Token{UID: idToken}, nil
+	}
+	if store != nil {
+		storeFor = func(client *firestore.Client) documentStore {
+			return store
+		}
+	}
+}
+
+// newOfflineClient returns a Firestore client dialing an address nothing
+// listens on, so handlers can build queries and run their validation but
+// every call reaching Firestore fails.
+func newOfflineClient(t *testing.T) *firestore.Client {
+	t.Helper()
+
+	client, err := firestore.NewClient(context.Background(), defaultProjectID,
+		option.WithEndpoint("127.0.0.1:1"),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())))
+	if err != nil {
+		t.Fatalf("Creating Firestore client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// serveJSON runs handler on a request from uid carrying body as JSON.
+func serveJSON(handler http.HandlerFunc, method string, target string, uid string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Content-Type", jsonContentType)
+	r.Header.Set("Authorization", uid)
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestUsersAPI(t *testing.T) {
+	publicCache := httptest.NewRecorder()
+	setPublicCache(publicCache)
+
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		uid         string
+		contentType string
+		body        string
+		err         error
+		wantStatus  int
+		wantHeaders map[string]string
+	}{
+		{name: "get by uid", method: http.MethodGet, target: "/users?uid=alice", wantStatus: http.StatusOK,
+			wantHeaders: map[string]string{"Cache-Control": publicCache.Header().Get("Cache-Control")}},
+		{name: "get missing user", method: http.MethodGet, target: "/users?uid=bob", wantStatus: http.StatusNotFound,
+			wantHeaders: map[string]string{"Cache-Control": "no-store"}},
+		{name: "get without parameters", method: http.MethodGet, target: "/users", wantStatus: http.StatusBadRequest},
+		{name: "get failing store", method: http.MethodGet, target: "/users?uid=alice", err: errors.New("backend down"),
+			wantStatus: http.StatusInternalServerError},
+		{name: "post unauthenticated", method: http.MethodPost, target: "/users", contentType: jsonContentType,
+			body: `{"Name": "Bob"}`, wantStatus: http.StatusForbidden},
+		{name: "post without JSON", method: http.MethodPost, target: "/users", uid: "bob", contentType: "text/plain",
+			body: `{"Name": "Bob"}`, wantStatus: http.StatusUnsupportedMediaType},
+		{name: "post for another user", method: http.MethodPost, target: "/users", uid: "bob", contentType: jsonContentType,
+			body: `{"ID": "alice", "Name": "Alice"}`, wantStatus: http.StatusForbidden},
+		{name: "put negative price", method: http.MethodPut, target: "/users", uid: "alice", contentType: jsonContentType,
+			body: `{"ID": "alice", "Name": "Alice", "Price": -1}`, wantStatus: http.StatusBadRequest},
+		{name: "put without name", method: http.MethodPut, target: "/users", uid: "alice", contentType: jsonContentType,
+			body: `{"ID": "alice"}`, wantStatus: http.StatusBadRequest},
+		{name: "put unauthenticated", method: http.MethodPut, target: "/users", contentType: jsonContentType,
+			body: `{"ID": "alice", "Name": "Alice"}`, wantStatus: http.StatusForbidden},
+		{name: "put without JSON", method: http.MethodPut, target: "/users", uid: "alice", contentType: "text/plain",
+			body: `{"ID": "alice", "Name": "Alice"}`, wantStatus: http.StatusUnsupportedMediaType},
+		{name: "patch unauthenticated", method: http.MethodPatch, target: "/users", contentType: jsonContentType,
+			body: `{"ID": "alice", "Name": "Alice Liddell"}`, wantStatus: http.StatusForbidden},
+		{name: "patch", method: http.MethodPatch, target: "/users", uid: "alice", contentType: jsonContentType,
+			body: `{"ID": "alice", "Name": "Alice Liddell"}`, wantStatus: http.StatusOK},
+		{name: "patch too large document", method: http.MethodPatch, target: "/users", uid: "alice", contentType: jsonContentType,
+			body: `{"ID": "alice", "Name": "Alice Liddell"}`, err: status.Error(codes.InvalidArgument, "Document exceeds the maximum allowed size"),
+			wantStatus: http.StatusBadRequest},
+		{name: "patch other user", method: http.MethodPatch, target: "/users", uid: "bob", contentType: jsonContentType,
+			body: `{"ID": "alice", "Name": "Bob"}`, wantStatus: http.StatusForbidden},
+		{name: "patch with unknown field", method: http.MethodPatch, target: "/users", uid: "alice", contentType: jsonContentType,
+			body: `{"ID": "alice", "Nickname": "Al"}`, wantStatus: http.StatusBadRequest},
+		{name: "delete with unknown field", method: http.MethodDelete, target: "/users", uid: "alice", contentType: jsonContentType,
+			body: `{"id": "alice", "confirm": "alice", "force": true}`, wantStatus: http.StatusBadRequest},
+		{name: "delete unauthenticated", method: http.MethodDelete, target: "/users", contentType: jsonContentType,
+			body: `{"id": "alice", "confirm": "alice"}`, wantStatus: http.StatusForbidden},
+		{name: "delete", method: http.MethodDelete, target: "/users", uid: "alice", contentType: jsonContentType,
+			body: `{"id": "alice", "confirm": "alice"}`, wantStatus: http.StatusOK},
+		{name: "preflight", method: http.MethodOptions, target: "/users", wantStatus: http.StatusNoContent,
+			wantHeaders: map[string]string{"Access-Control-Allow-Methods": "GET, POST, PUT, PATCH, DELETE, OPTIONS"}},
+		{name: "unsupported method", method: http.MethodHead, target: "/users?uid=alice", wantStatus: http.StatusMethodNotAllowed,
+			wantHeaders: map[string]string{"Allow": "GET, POST, PUT, PATCH, DELETE, OPTIONS"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemoryStore()
+			store.Put(collections.Users, "alice", map[string]interface{}{"uid": "alice", "displayName": "Alice", "price": int64(999)})
+			store.Err = tt.err
+			fakeFirebase(t, nil, store)
+			readCache.Delete(cacheKey(context.Background(), collections.Users, "alice"))
+
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.uid != "" {
+				r.Header.Set("Authorization", tt.uid)
+			}
+			w := httptest.NewRecorder()
+			UsersAPI(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			for header, want := range tt.wantHeaders {
+				if got := w.Header().Get(header); got != want {
+					t.Errorf("%s = %q, want %q", header, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestUsersAPIUnknownProject(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/users?uid=alice", nil)
+	r.Header.Set(projectHeader, "not-registered")
+	w := httptest.NewRecorder()
+	UsersAPI(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+	}
+}
+
+func TestUsersAPIProjectCache(t *testing.T) {
+	store := newMemoryStore()
+	store.Put(collections.Users, "alice", map[string]interface{}{"uid": "alice", "displayName": "Alice"})
+	fakeFirebase(t, nil, store)
+
+	// Reading alice in the default project must not fill the cache of another
+	otherCtx := context.WithValue(context.Background(), projectContextKey{}, "other-project")
+	readCache.Delete(cacheKey(context.Background(), collections.Users, "alice"))
+	readCache.Set(cacheKey(otherCtx, collections.Users, "alice"), map[string]interface{}{"uid": "alice", "displayName": "Other Alice"})
+	t.Cleanup(func() {
+		readCache.Delete(cacheKey(context.Background(), collections.Users, "alice"))
+		readCache.Delete(cacheKey(otherCtx, collections.Users, "alice"))
+	})
+
+	tests := []struct {
+		name     string
+		project  string
+		wantName string
+	}{
+		{"default project", "", `"displayName":"Alice"`},
+		{"other project", "other-project", `"displayName":"Other Alice"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/users?uid=alice", nil)
+			if tt.project != "" {
+				r.Header.Set(projectHeader, tt.project)
+			}
+			w := httptest.NewRecorder()
+			UsersAPI(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantName) {
+				t.Errorf("body %s doesn't contain %s", w.Body, tt.wantName)
+			}
+			if got := w.Header().Get("Vary"); got != projectHeader {
+				t.Errorf("Vary = %q, want %q", got, projectHeader)
+			}
+		})
+	}
+}
+
+func TestNewServer(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       map[string]string
+		wantRead  time.Duration
+		wantWrite time.Duration
+		wantIdle  time.Duration
+	}{
+		{"defaults", nil, defaultReadTimeout, defaultWriteTimeout, defaultIdleTimeout},
+		{"overrides", map[string]string{"READ_TIMEOUT": "3s", "WRITE_TIMEOUT": "1m", "IDLE_TIMEOUT": "2m30s"},
+			3 * time.Second, time.Minute, 150 * time.Second},
+		{"invalid override", map[string]string{"READ_TIMEOUT": "soon"}, defaultReadTimeout, defaultWriteTimeout, defaultIdleTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"READ_TIMEOUT", "WRITE_TIMEOUT", "IDLE_TIMEOUT"} {
+				t.Setenv(name, tt.env[name])
+			}
+			srv := newServer(http.NotFoundHandler())
+
+			if srv.ReadTimeout != tt.wantRead || srv.WriteTimeout != tt.wantWrite || srv.IdleTimeout != tt.wantIdle {
+				t.Errorf("timeouts = %v read, %v write, %v idle, want %v, %v, %v",
+					srv.ReadTimeout, srv.WriteTimeout, srv.IdleTimeout, tt.wantRead, tt.wantWrite, tt.wantIdle)
+			}
+			if srv.Addr != "0.0.0.0:8000" {
+				t.Errorf("Addr = %q, want 0.0.0.0:8000", srv.Addr)
+			}
+		})
+	}
+}
+
+func TestRequireClaim(t *testing.T) {
+	tests := []struct {
+		name  string
+		token *auth.Token
+		want  bool
+	}{
+		{"no token", nil, false},
+		{"no claims", &auth.Token{UID: "alice"}, false},
+		{"claim set", &auth.Token{UID: "alice", Claims: map[string]interface{}{"admin": true}}, true},
+		{"claim false", &auth.Token{UID: "alice", Claims: map[string]interface{}{"admin": false}}, false},
+		{"claim as string", &auth.Token{UID: "alice", Claims: map[string]interface{}{"admin": "true"}}, false},
+		{"other claim", &auth.Token{UID: "alice", Claims: map[string]interface{}{"editor": true}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := requireClaim(tt.token, "admin"); got != tt.want {
+				t.Errorf("requireClaim = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizeAdmin(t *testing.T) {
+	fakeFirebase(t, nil, nil)
+
+	tests := []struct {
+		name       string
+		idToken    string
+		want       bool
+		wantStatus int
+	}{
+		{"admin", "admin", true, http.StatusOK},
+		{"not admin", "alice", false, http.StatusForbidden},
+		{"unauthenticated", "", false, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/jobs", nil)
+			r.Header.Set("Authorization", tt.idToken)
+			w := httptest.NewRecorder()
+
+			if got := authorizeAdmin(w, nil, r); got != tt.want {
+				t.Errorf("authorizeAdmin = %v, want %v", got, tt.want)
+			}
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestAuthorizeRequestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	restoreOutput, restoreVerify, restoreLevel := log.Writer(), verifyIDToken, minLogLevel
+	log.SetOutput(&buf)
+	minLogLevel = levelDebug
+	verifyIDToken = func(ctx context.Context, app *firebase.App, idToken string) (*auth.Token, error) {
+		return &auth.Token{
+			UID:    "alice",
+			Issuer: "https://securetoken.google.com/talkit",
+			Claims: map[string]interface{}{"email": "alice@example.com", "premium": true},
+		}, nil
+	}
+	t.Cleanup(func() {
+		log.SetOutput(restoreOutput)
+		verifyIDToken = restoreVerify
+		minLogLevel = restoreLevel
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/me", nil)
+	r.Header.Set("Authorization", "secret-id-token")
+	if token := authorizeRequest(httptest.NewRecorder(), nil, r); token == nil {
+		t.Fatal("authorizeRequest rejected a verified token")
+	}
+
+	logged := buf.String()
+	if !strings.Contains(logged, "alice") {
+		t.Errorf("log %q doesn't name the uid", logged)
+	}
+	for _, secret := range []string{"secret-id-token", "alice@example.com", "premium", "securetoken"} {
+		if strings.Contains(logged, secret) {
+			t.Errorf("log %q leaks %s", logged, secret)
+		}
+	}
+}
+
+func TestCanModifyUser(t *testing.T) {
+	admin := map[string]interface{}{"admin": true}
+
+	tests := []struct {
+		name  string
+		token *auth.Token
+		uid   string
+		want  bool
+	}{
+		{"owner", &auth.Token{UID: "alice"}, "alice", true},
+		{"other user", &auth.Token{UID: "bob"}, "alice", false},
+		{"admin", &auth.Token{UID: "bob", Claims: admin}, "alice", true},
+		{"empty uid", &auth.Token{UID: "bob"}, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := canModifyUser(tt.token, tt.uid); got != tt.want {
+				t.Errorf("canModifyUser(%s, %q) = %v, want %v", tt.token.UID, tt.uid, got, tt.want)
+			}
+		})
+	}
+}
+
+// contextStore is a memoryStore failing reads and updates whose context is
+// done, and remembering the context of the last read.
+type contextStore struct {
+	*memoryStore
+	mu  sync.Mutex
+	ctx context.Context
+}
+
+func (s *contextStore) Get(ctx context.Context, collection string, id string) (map[string]interface{}, error) {
+	s.mu.Lock()
+	s.ctx = ctx
+	s.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.memoryStore.Get(ctx, collection, id)
+}
+
+func (s *contextStore) Update(ctx context.Context, collection string, id string, updates []firestore.Update) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.memoryStore.Update(ctx, collection, id, updates)
+}
+
+func TestHandleUserCreateTrialDisabled(t *testing.T) {
+	previous := enableFreeTrial
+	enableFreeTrial = false
+	t.Cleanup(func() { enableFreeTrial = previous })
+
+	// The offline client fails any write, so a 200 means none was attempted
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var e FirestoreEvent
+	e.Value.Fields.ID = "alice"
+	w := httptest.NewRecorder()
+	if err := HandleUserCreate(ctx, newOfflineClient(t), w, httptest.NewRequest(http.MethodPost, "/", nil), e); err != nil {
+		t.Fatalf("HandleUserCreate: %v", err)
+	}
+
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestMeAPIBoundsFirestoreCalls(t *testing.T) {
+	store := &contextStore{memoryStore: newMemoryStore()}
+	store.Put(collections.Users, "alice", map[string]interface{}{"uid": "alice"})
+	fakeFirebase(t, nil, store)
+
+	w := serveJSON(MeAPI, http.MethodGet, "/me", "alice", "")
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	deadline, ok := store.ctx.Deadline()
+	if !ok {
+		t.Fatal("Firestore read ran without a deadline")
+	}
+	if remaining := time.Until(deadline); remaining > firestoreTimeout {
+		t.Errorf("read deadline in %v, want at most %v", remaining, firestoreTimeout)
+	}
+}
+
+func TestMeAPIRequestCancellation(t *testing.T) {
+	tests := []struct {
+		name       string
+		cancel     bool
+		wantStatus int
+	}{
+		{"live request", false, http.StatusOK},
+		{"canceled request", true, statusClientClosedRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &contextStore{memoryStore: newMemoryStore()}
+			store.Put(collections.Users, "alice", map[string]interface{}{"uid": "alice"})
+			fakeFirebase(t, nil, store)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				cancel()
+			}
+			r := httptest.NewRequest(http.MethodGet, "/me", nil).WithContext(ctx)
+			r.Header.Set("Authorization", "alice")
+			w := httptest.NewRecorder()
+
+			MeAPI(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
+
+func TestJSONContentType(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		method     string
+		target     string
+		uid        string
+		body       string
+		wantStatus int
+	}{
+		{"read", UsersAPI, http.MethodGet, "/users?uid=alice", "", "", http.StatusOK},
+		{"not found", UsersAPI, http.MethodGet, "/users?uid=bob", "", "", http.StatusNotFound},
+		{"bad request", UsersAPI, http.MethodGet, "/users", "", "", http.StatusBadRequest},
+		{"bodiless write", UsersAPI, http.MethodPatch, "/users", "alice", `{"ID": "alice", "Name": "Alice Liddell"}`, http.StatusOK},
+		{"delete", UsersAPI, http.MethodDelete, "/users", "alice", `{"id": "alice", "confirm": "alice"}`, http.StatusOK},
+		{"forbidden", MeAPI, http.MethodGet, "/me", "", "", http.StatusForbidden},
+		{"method not allowed", MeAPI, http.MethodPost, "/me", "alice", "", http.StatusMethodNotAllowed},
+		{"unknown route", notFoundHandler, http.MethodGet, "/nowhere", "", "", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemoryStore()
+			store.Put(collections.Users, "alice", map[string]interface{}{"uid": "alice", "displayName": "Alice"})
+			fakeFirebase(t, nil, store)
+			readCache.Delete(cacheKey(context.Background(), collections.Users, "alice"))
+
+			w := serveJSON(tt.handler, tt.method, tt.target, tt.uid, tt.body)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			// Bodiless responses have no content to describe
+			if got := w.Header().Get("Content-Type"); w.Body.Len() > 0 && got != jsonContentType {
+				t.Errorf("Content-Type = %q, want %q", got, jsonContentType)
+			}
+		})
+	}
+}
+
+// blockingStore is a memoryStore counting its reads and holding them until
+// release is closed.
+type blockingStore struct {
+	*memoryStore
+	release chan struct{}
+	mu      sync.Mutex
+	reads   map[string]int
+}
+
+func (s *blockingStore) Get(ctx context.Context, collection string, id string) (map[string]interface{}, error) {
+	s.mu.Lock()
+	s.reads[id]++
+	s.mu.Unlock()
+
+	<-s.release
+	return s.memoryStore.Get(ctx, collection, id)
+}
+
+func TestGetUsersCollapsesConcurrentReads(t *testing.T) {
+	tests := []struct {
+		name      string
+		uids      []string
+		wantReads map[string]int
+	}{
+		{"same uid", []string{"alice", "alice", "alice", "alice", "alice", "alice", "alice", "alice"}, map[string]int{"alice": 1}},
+		{"distinct uids", []string{"alice", "bob", "alice", "bob"}, map[string]int{"alice": 1, "bob": 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &blockingStore{memoryStore: newMemoryStore(), release: make(chan struct{}), reads: map[string]int{}}
+			store.Put(collections.Users, "alice", map[string]interface{}{"uid": "alice"})
+			store.Put(collections.Users, "bob", map[string]interface{}{"uid": "bob"})
+			fakeFirebase(t, nil, store)
+			for _, uid := range []string{"alice", "bob"} {
+				readCache.Delete(cacheKey(context.Background(), collections.Users, uid))
+			}
+
+			var wg sync.WaitGroup
+			codes := make([]int, len(tt.uids))
+			for i, uid := range tt.uids {
+				wg.Add(1)
+				go func(i int, uid string) {
+					defer wg.Done()
+					codes[i] = serveJSON(UsersAPI, http.MethodGet, "/users?uid="+uid, "", "").Code
+				}(i, uid)
+			}
+			// Give every request the time to join the read in flight
+			time.Sleep(100 * time.Millisecond)
+			close(store.release)
+			wg.Wait()
+
+			for i, code := range codes {
+				if code != http.StatusOK {
+					t.Errorf("request %d for %s status = %d, want %d", i, tt.uids[i], code, http.StatusOK)
+				}
+			}
+			store.mu.Lock()
+			defer store.mu.Unlock()
+			for uid, want := range tt.wantReads {
+				if store.reads[uid] != want {
+					t.Errorf("reads of %s = %d, want %d", uid, store.reads[uid], want)
+				}
+			}
+		})
+	}
+}
+
+func TestUsersAPIWriteCancellation(t *testing.T) {
+	var buf bytes.Buffer
+	restore := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(restore) })
+
+	tests := []struct {
+		name       string
+		ctx        func() (context.Context, context.CancelFunc)
+		wantStatus int
+		wantLogged bool
+	}{
+		{"live request", func() (context.Context, context.CancelFunc) {
+			return context.WithCancel(context.Background())
+		}, http.StatusOK, false},
+		{"client disconnected", func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			return ctx, cancel
+		}, statusClientClosedRequest, false},
+		{"deadline exceeded", func() (context.Context, context.CancelFunc) {
+			return context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		}, http.StatusGatewayTimeout, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			store := &contextStore{memoryStore: newMemoryStore()}
+			store.Put(collections.Users, "alice", map[string]interface{}{"uid": "alice", "displayName": "Alice"})
+			fakeFirebase(t, nil, store)
+
+			ctx, cancel := tt.ctx()
+			defer cancel()
+			r := newJSONRequest(http.MethodPatch, "/users", `{"ID": "alice", "Name": "Alice Liddell"}`).WithContext(ctx)
+			r.Header.Set("Authorization", "alice")
+			w := httptest.NewRecorder()
+
+			UsersAPI(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if logged := strings.Contains(buf.String(), "ERROR"); logged != tt.wantLogged {
+				t.Errorf("error logged = %v, want %v: %s", logged, tt.wantLogged, buf.String())
+			}
+		})
+	}
+}
+
+// routeMethods lists the methods each API handler supports, OPTIONS aside.
+var routeMethods = []struct {
+	route   string
+	handler http.HandlerFunc
+	methods []string
+}{
+	{"/me", MeAPI, []string{http.MethodGet}},
+	{"/users", UsersAPI, []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}},
+	{"/users/avatar", UsersAvatarAPI, []string{http.MethodPost}},
+	{"/users/batch", UsersBatchAPI, []string{http.MethodPost}},
+	{"/users/claims", UsersClaimsAPI, []string{http.MethodPost}},
+	{"/users/count", UsersCountAPI, []string{http.MethodGet}},
+	{"/users/export", UsersExportAPI, []string{http.MethodGet}},
+	{"/users/import", UsersImportAPI, []string{http.MethodPost}},
+	{"/users/list", UsersListAPI, []string{http.MethodGet}},
+	{"/users/presence", UsersPresenceAPI, []string{http.MethodPut}},
+	{"/users/revoke", UsersRevokeAPI, []string{http.MethodPost}},
+	{"/users/search", UsersSearchAPI, []string{http.MethodPost}},
+	{"/chats", ChatsAPI, []string{http.MethodPost}},
+	{"/groups", GroupsAPI, []string{http.MethodGet}},
+	{"/messages", MessagesAPI, []string{http.MethodGet}},
+	{"/messages/read", MessagesReadAPI, []string{http.MethodPut}},
+	{"/messages/unread", MessagesUnreadAPI, []string{http.MethodGet}},
+	{"/storage/signed-url", StorageSignedURLAPI, []string{http.MethodGet}},
+	{"/suscriptions", SuscriptionsAPI, []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete}},
+	{"/suscriptions/expired", SuscriptionsExpiredAPI, []string{http.MethodDelete}},
+	{"/suscriptions/expiring", SuscriptionsExpiringAPI, []string{http.MethodGet}},
+	{"/suscriptions/history", SuscriptionsHistoryAPI, []string{http.MethodGet}},
+	{"/suscriptions/renew", SuscriptionsRenewAPI, []string{http.MethodPost}},
+	{"/suscriptions/status", SuscriptionsStatusAPI, []string{http.MethodGet}},
+	{"/suscriptions/stream", SuscriptionsStreamAPI, []string{http.MethodGet}},
+	{"/suscriptions/transfer", SuscriptionsTransferAPI, []string{http.MethodPost}},
+	{"/talks/search", TalksSearchAPI, []string{http.MethodGet}},
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	for _, tt := range routeMethods {
+		t.Run(tt.route, func(t *testing.T) {
+			fakeFirebase(t, newOfflineClient(t), newMemoryStore())
+
+			w := serveJSON(tt.handler, http.MethodTrace, tt.route, "admin", "")
+
+			if w.Code != http.StatusMethodNotAllowed {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusMethodNotAllowed, w.Body)
+			}
+			want := strings.Join(append(tt.methods, http.MethodOptions), ", ")
+			if got := w.Header().Get("Allow"); got != want {
+				t.Errorf("Allow = %q, want %q", got, want)
+			}
+			if envelope := decodeEnvelope(t, w); envelope["error"] != errorMethodNotAllowed.Code {
+				t.Errorf("error = %v, want %s", envelope["error"], errorMethodNotAllowed.Code)
+			}
+		})
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	for _, tt := range routeMethods {
+		t.Run(tt.route, func(t *testing.T) {
+			fakeFirebase(t, newOfflineClient(t), newMemoryStore())
+			r := httptest.NewRequest(http.MethodOptions, tt.route, nil)
+			r.Header.Set("Origin", "https://app.talkit.com")
+			r.Header.Set("Access-Control-Request-Method", tt.methods[0])
+			r.Header.Set("Access-Control-Request-Headers", "authorization, content-type")
+			w := httptest.NewRecorder()
+
+			tt.handler(w, r)
+
+			if w.Code != http.StatusNoContent {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusNoContent, w.Body)
+			}
+			want := strings.Join(append(tt.methods, http.MethodOptions), ", ")
+			if got := w.Header().Get("Access-Control-Allow-Methods"); got != want {
+				t.Errorf("Access-Control-Allow-Methods = %q, want %q", got, want)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Headers"); !strings.Contains(got, "Authorization") {
+				t.Errorf("Access-Control-Allow-Headers = %q, want Authorization allowed", got)
+			}
+		})
+	}
+}
+
+func TestPreflightAllowedHeaders(t *testing.T) {
+	restore := corsAllowedHeaders
+	t.Cleanup(func() { corsAllowedHeaders = restore })
+
+	tests := []struct {
+		name    string
+		headers string
+	}{
+		{"default", defaultCORSAllowedHeaders},
+		{"configured", "Authorization, X-Trace-Id"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			corsAllowedHeaders = tt.headers
+			w := httptest.NewRecorder()
+
+			handleCORS(w, httptest.NewRequest(http.MethodOptions, "/me", nil), http.MethodGet, http.MethodOptions)
+
+			if got := w.Header().Get("Access-Control-Allow-Headers"); got != tt.headers {
+				t.Errorf("Access-Control-Allow-Headers = %q, want %q", got, tt.headers)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"firebase.google.com/go/auth"
+	"golang.org/x/sync/errgroup"
+)
+
+// MeAPI is an HTTP Cloud Function returning the profile and subscription of
+// the authenticated user in a single call.
+func MeAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := projectContext(r)
+
+	app, client, ok := initFirebase(w, r)
+	if !ok {
+		return
+	}
+
+	if handleCORS(w, r, http.MethodGet, http.MethodOptions) {
+		return
+	}
+
+	// Bound the Firestore work so a hung call can't block the request
+	ctx, cancel := context.WithTimeout(ctx, firestoreTimeout)
+	defer cancel()
+
+	switch method := r.Method; method {
+	case http.MethodGet:
+		token := authorizeRequest(w, app, r)
+		if token == nil {
+			return
+		}
+		setPrivateNoStore(w)
+		getMe(ctx, storeFor(client), token, w, r)
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodOptions)
+	}
+}
+
+func getMe(ctx context.Context, store documentStore, token *auth.Token, w http.ResponseWriter, r *http.Request) {
+	var user, suscription map[string]interface{}
+
+	// Both reads run concurrently, the first failure cancels the other
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		var err error
+		user, err = findDocument(gctx, store, collections.Users, token.UID)
+		return err
+	})
+	g.Go(func() error {
+		var err error
+		suscription, err = findDocument(gctx, store, collections.Suscriptions, token.UID)
+		return err
+	})
+	if err := g.Wait(); err != nil {
+		writeFirestoreError(w, "Fetching profile failed", err)
+		return
+	}
+
+	if user == nil {
+		writeNotFound(w, "User not found")
+		return
+	}
+
+	writeJSONWithETag(w, r, map[string]interface{}{
+		"user":        formatAmounts(user),
+		"suscription": formatAmounts(suscription),
+	})
+}
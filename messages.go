@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"firebase.google.com/go/auth"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// MessageFieldsType defines the structure of the fields in a Message from the Messages collection.
+type MessageFieldsType struct {
+	ID       string    `firestore:"-" json:"id"`
+	ChatID   string    `firestore:"chatId" json:"chatId"`
+	SenderID string    `firestore:"senderId" json:"senderId"`
+	Text     string    `firestore:"text" json:"text"`
+	SentAt   time.Time `firestore:"sentAt" json:"sentAt"`
+}
+
+// ReadReceiptType records up to which message a user has read a chat. It is
+// stored in the ReadReceipts collection under readReceiptID.
+type ReadReceiptType struct {
+	ChatID            string    `firestore:"chatId" json:"chatId"`
+	UID               string    `firestore:"uid" json:"uid"`
+	LastReadMessageID string    `firestore:"lastReadMessageId" json:"upToMessageId"`
+	LastReadAt        time.Time `firestore:"lastReadAt" json:"-"`
+}
+
+// readReceiptID is the document ID of the read receipt of uid on a chat.
+func readReceiptID(chatID string, uid string) string {
+	return chatID + "_" + uid
+}
+
+// MessagesAPI is an HTTP Cloud Function returning the history of a chat, most
+// recent messages first, one page at a time.
+func MessagesAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := projectContext(r)
+
+	app, client, ok := initFirebase(w, r)
+	if !ok {
+		return
+	}
+
+	if handleCORS(w, r, http.MethodGet, http.MethodOptions) {
+		return
+	}
+
+	// Bound the Firestore work so a hung call can't block the request
+	ctx, cancel := context.WithTimeout(ctx, firestoreTimeout)
+	defer cancel()
+
+	switch method := r.Method; method {
+	case http.MethodGet:
+		token := authorizeRequest(w, app, r)
+		if token == nil {
+			return
+		}
+		setPrivateNoStore(w)
+		getMessages(ctx, client, token, w, r)
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodOptions)
+	}
+}
+
+// getMessages returns up to limit messages of the chat sent before the
+// message given in before, or the latest ones without it.
+func getMessages(ctx context.Context, client *firestore.Client, token *auth.Token, w http.ResponseWriter, r *http.Request) {
+	chatID := r.URL.Query().Get("chatId")
+	if chatID == "" {
+		writeBadRequest(w, "chatId query parameter is required")
+		return
+	}
+	if !authorizeChat(ctx, storeFor(client), token, chatID, w) {
+		return
+	}
+
+	query := client.Collection(collections.Messages).Where("chatId", "==", chatID).OrderBy("sentAt", firestore.Desc)
+
+	// The cursor is the last message of the previous page
+	if before := r.URL.Query().Get("before"); before != "" {
+		doc, err := client.Collection(collections.Messages).Doc(before).Get(ctx)
+		if status.Code(err) == codes.NotFound {
+			writeNotFound(w, "Message not found")
+			return
+		}
+		if err != nil {
+			writeFirestoreError(w, "Reading document failed", err)
+			return
+		}
+		if chat, _ := doc.DataAt("chatId"); chat != chatID {
+			writeBadRequest(w, "before must be a message of the chat")
+			return
+		}
+		query = query.StartAfter(doc)
+	}
+
+	Messages := []MessageFieldsType{}
+
+	iter := query.Limit(parseLimit(r)).Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			writeFirestoreError(w, "Iteration over documents failed", err)
+			return
+		}
+
+		var message MessageFieldsType
+		if err := doc.DataTo(&message); err != nil {
+			logErrorf("Decoding document failed %v", err)
+			writeInternalError(w)
+			return
+		}
+		message.ID = doc.Ref.ID
+
+		Messages = append(Messages, message)
+	}
+
+	writeJSONWithETag(w, r, map[string]interface{}{
+		"data": Messages,
+	})
+}
+
+// MessagesReadAPI is an HTTP Cloud Function recording up to which message a
+// user has read a chat.
+func MessagesReadAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := projectContext(r)
+
+	app, client, ok := initFirebase(w, r)
+	if !ok {
+		return
+	}
+
+	if handleCORS(w, r, http.MethodPut, http.MethodOptions) {
+		return
+	}
+
+	// Bound the Firestore work so a hung call can't block the request
+	ctx, cancel := context.WithTimeout(ctx, firestoreTimeout)
+	defer cancel()
+
+	switch method := r.Method; method {
+	case http.MethodPut:
+		token := authorizeRequest(w, app, r)
+		if token == nil {
+			return
+		}
+		markMessagesRead(ctx, client, token, w, r)
+	default:
+		writeMethodNotAllowed(w, http.MethodPut, http.MethodOptions)
+	}
+}
+
+func markMessagesRead(ctx context.Context, client *firestore.Client, token *auth.Token, w http.ResponseWriter, r *http.Request) {
+	if !requireJSON(w, r) {
+		return
+	}
+
+	var Body ReadReceiptType
+
+	err := decodeStrict(r.Body, &Body)
+	if err != nil {
+		logDebugf("Unmarshalling json failed %v", err)
+		writeDecodeError(w, err)
+		return
+	}
+	defer r.Body.Close()
+
+	if Body.ChatID == "" || Body.LastReadMessageID == "" {
+		writeBadRequest(w, "chatId and upToMessageId are required")
+		return
+	}
+	if Body.UID == "" {
+		Body.UID = token.UID
+	}
+	if !canModifyUser(token, Body.UID) {
+		writeForbidden(w, "You can only mark messages read for yourself")
+		return
+	}
+	if !authorizeChat(ctx, storeFor(client), token, Body.ChatID, w) {
+		return
+	}
+
+	messageRef := client.Collection(collections.Messages).Doc(Body.LastReadMessageID)
+	receiptRef := client.Collection(collections.ReadReceipts).Doc(readReceiptID(Body.ChatID, Body.UID))
+
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(messageRef)
+		if err != nil {
+			return err
+		}
+
+		var message MessageFieldsType
+		if err := doc.DataTo(&message); err != nil {
+			return err
+		}
+		if message.ChatID != Body.ChatID {
+			return status.Error(codes.NotFound, "message is not part of the chat")
+		}
+
+		// Reading an older message never moves the receipt backwards
+		current, err := tx.Get(receiptRef)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if current.Exists() {
+			var receipt ReadReceiptType
+			if err := current.DataTo(&receipt); err == nil && !message.SentAt.After(receipt.LastReadAt) {
+				return nil
+			}
+		}
+
+		Body.LastReadAt = message.SentAt
+		return tx.Set(receiptRef, &Body)
+	})
+	if status.Code(err) == codes.NotFound {
+		writeNotFound(w, "Message not found in chat")
+		return
+	}
+	if err != nil {
+		writeFirestoreError(w, "Recording read receipt failed", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// MessagesUnreadAPI is an HTTP Cloud Function returning how many messages a
+// user hasn't read yet on each of the requested chats.
+func MessagesUnreadAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := projectContext(r)
+
+	app, client, ok := initFirebase(w, r)
+	if !ok {
+		return
+	}
+
+	if handleCORS(w, r, http.MethodGet, http.MethodOptions) {
+		return
+	}
+
+	// Bound the Firestore work so a hung call can't block the request
+	ctx, cancel := context.WithTimeout(ctx, firestoreTimeout)
+	defer cancel()
+
+	switch method := r.Method; method {
+	case http.MethodGet:
+		token := authorizeRequest(w, app, r)
+		if token == nil {
+			return
+		}
+		setPrivateNoStore(w)
+		countUnreadMessages(ctx, client, token, w, r)
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodOptions)
+	}
+}
+
+func countUnreadMessages(ctx context.Context, client *firestore.Client, token *auth.Token, w http.ResponseWriter, r *http.Request) {
+	chatIDs := r.URL.Query()["chatId"]
+	if len(chatIDs) == 0 {
+		writeBadRequest(w, "chatId query parameter is required")
+		return
+	}
+	uid := r.URL.Query().Get("uid")
+	if uid == "" {
+		uid = token.UID
+	}
+	if !canModifyUser(token, uid) {
+		writeForbidden(w, "You can only count your own unread messages")
+		return
+	}
+
+	store := storeFor(client)
+	unread := map[string]int64{}
+	for _, chatID := range chatIDs {
+		if !authorizeChat(ctx, store, token, chatID, w) {
+			return
+		}
+		query := client.Collection(collections.Messages).Where("chatId", "==", chatID)
+
+		// Without a receipt every message of the chat is unread
+		doc, err := client.Collection(collections.ReadReceipts).Doc(readReceiptID(chatID, uid)).Get(ctx)
+		if err != nil && status.Code(err) != codes.NotFound {
+			writeFirestoreError(w, "Reading read receipt failed", err)
+			return
+		}
+		if doc.Exists() {
+			var receipt ReadReceiptType
+			if err := doc.DataTo(&receipt); err != nil {
+				logErrorf("Decoding document failed %v", err)
+				writeInternalError(w)
+				return
+			}
+			query = query.Where("sentAt", ">", receipt.LastReadAt)
+		}
+
+		count, err := countQuery(ctx, query)
+		if err != nil {
+			writeFirestoreError(w, "Counting documents failed", err)
+			return
+		}
+		unread[chatID] = count
+	}
+
+	w.Header().Set("Content-Type", jsonContentType)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": unread,
+	})
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMessagesAPIsRequireParticipation(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		method     string
+		target     string
+		uid        string
+		body       string
+		wantStatus int
+	}{
+		{"history without chatId", MessagesAPI, http.MethodGet, "/messages", "alice", "", http.StatusBadRequest},
+		{"history of another chat", MessagesAPI, http.MethodGet, "/messages?chatId=chat1", "mallory", "", http.StatusForbidden},
+		{"history of an unknown chat", MessagesAPI, http.MethodGet, "/messages?chatId=chat2", "alice", "", http.StatusNotFound},
+		{"read another chat", MessagesReadAPI, http.MethodPut, "/messages/read", "mallory",
+			`{"chatId": "chat1", "upToMessageId": "m1"}`, http.StatusForbidden},
+		{"read an unknown chat", MessagesReadAPI, http.MethodPut, "/messages/read", "alice",
+			`{"chatId": "chat2", "upToMessageId": "m1"}`, http.StatusNotFound},
+		{"read for someone else", MessagesReadAPI, http.MethodPut, "/messages/read", "alice",
+			`{"chatId": "chat1", "uid": "bob", "upToMessageId": "m1"}`, http.StatusForbidden},
+		{"unread of another chat", MessagesUnreadAPI, http.MethodGet, "/messages/unread?chatId=chat1", "mallory", "", http.StatusForbidden},
+		{"unread of an unknown chat", MessagesUnreadAPI, http.MethodGet, "/messages/unread?chatId=chat2", "alice", "", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemoryStore()
+			store.Put(collections.Chats, "chat1", map[string]interface{}{"participants": []interface{}{"alice", "bob"}})
+			fakeFirebase(t, nil, store)
+
+			w := serveJSON(tt.handler, tt.method, tt.target, tt.uid, tt.body)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Number of HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Duration of HTTP requests by route and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	firestoreCallDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "firestore_call_duration_seconds",
+		Help:    "Duration of Firestore RPCs by method and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "code"})
+)
+
+// metricsHandler serves the collected metrics in the Prometheus text format.
+var metricsHandler = promhttp.Handler()
+
+// instrument records request count and latency metrics for the given route.
+func instrument(route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := newStatusRecorder(w)
+
+		next(rec, r)
+
+		httpRequestsTotal.WithLabelValues(route, r.Method, strconv.Itoa(rec.Status())).Inc()
+		httpRequestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	}
+}
+
+// firestoreMetricsOptions returns client options that time every Firestore RPC.
+func firestoreMetricsOptions() []option.ClientOption {
+	return []option.ClientOption{
+		option.WithGRPCDialOption(grpc.WithChainUnaryInterceptor(firestoreUnaryInterceptor)),
+		option.WithGRPCDialOption(grpc.WithChainStreamInterceptor(firestoreStreamInterceptor)),
+	}
+}
+
+func observeFirestoreCall(method string, start time.Time, err error) {
+	firestoreCallDuration.WithLabelValues(path.Base(method), status.Code(err).String()).Observe(time.Since(start).Seconds())
+}
+
+func firestoreUnaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	start := time.Now()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	observeFirestoreCall(method, start, err)
+	return err
+}
+
+func firestoreStreamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	start := time.Now()
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		observeFirestoreCall(method, start, err)
+		return nil, err
+	}
+	return &timedClientStream{ClientStream: stream, method: method, start: start}, nil
+}
+
+// timedClientStream observes the duration of a streaming RPC once the stream
+// finishes, which is when RecvMsg first returns an error (io.EOF included).
+type timedClientStream struct {
+	grpc.ClientStream
+	method string
+	start  time.Time
+	once   sync.Once
+}
+
+func (s *timedClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.once.Do(func() {
+			// io.EOF marks a successful end of stream
+			if errors.Is(err, io.EOF) {
+				observeFirestoreCall(s.method, s.start, nil)
+				return
+			}
+			observeFirestoreCall(s.method, s.start, err)
+		})
+	}
+	return err
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestInstrument(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		wantStatus string
+	}{
+		{"implicit 200", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("{}")) }, "200"},
+		{"error", func(w http.ResponseWriter, r *http.Request) { writeNotFound(w, "User not found") }, "404"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := "/test/" + strings.ReplaceAll(tt.name, " ", "-")
+			counter := httpRequestsTotal.WithLabelValues(route, http.MethodGet, tt.wantStatus)
+			before := testutil.ToFloat64(counter)
+
+			instrument(route, tt.handler)(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, route, nil))
+
+			if got := testutil.ToFloat64(counter) - before; got != 1 {
+				t.Errorf("requests counted = %v, want 1", got)
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	metricsHandler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(w.Body.String(), `http_requests_total{method="GET",route="/test/error",status="404"}`) {
+		t.Errorf("/metrics doesn't expose the counted request:\n%s", w.Body)
+	}
+}
+
+func TestFirestoreUnaryInterceptor(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantCode string
+	}{
+		{"success", nil, "OK"},
+		{"failure", status.Error(codes.Unavailable, "down"), "Unavailable"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const method = "/google.firestore.v1.Firestore/TestInterceptor"
+			invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				return tt.err
+			}
+
+			err := firestoreUnaryInterceptor(context.Background(), method, nil, nil, nil, invoker)
+
+			if err != tt.err {
+				t.Errorf("error = %v, want %v", err, tt.err)
+			}
+			w := httptest.NewRecorder()
+			metricsHandler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			want := `firestore_call_duration_seconds_count{code="` + tt.wantCode + `",method="TestInterceptor"}`
+			if !strings.Contains(w.Body.String(), want) {
+				t.Errorf("/metrics doesn't contain %s", want)
+			}
+		})
+	}
+}
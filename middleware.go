@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Middleware wraps a handler with behavior that runs around it.
+type Middleware func(http.Handler) http.Handler
+
+// chain composes middleware into one, running them in the given order: the
+// first one sees the request first and the response last.
+func chain(mw ...Middleware) Middleware {
+	return func(next http.Handler) http.Handler {
+		for i := len(mw) - 1; i >= 0; i-- {
+			next = mw[i](next)
+		}
+		return next
+	}
+}
+
+// loggingMiddleware logs the method, path, status and duration of every
+// request once it has been handled.
+func loggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := newStatusRecorder(w)
+
+		next.ServeHTTP(rec, r)
+
+		logInfof("%s %s %d %s", r.Method, r.URL.Path, rec.Status(), time.Since(start))
+	})
+}
+
+// recoverMiddleware turns a panicking handler into a logged 500 response
+// instead of a dropped connection.
+func recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			// ErrAbortHandler is the sanctioned way to abort a response
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+
+			logErrorf("panic serving %s %s: %v\n%s", r.Method, r.URL.Path, err, debug.Stack())
+
+			writeError(w, errorInternal, "", nil)
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hstsHeader is sent on HTTPS responses so browsers stick to HTTPS.
+const hstsHeader = "max-age=31536000; includeSubDomains"
+
+// requireHTTPS is toggled with the REQUIRE_HTTPS env var.
+var requireHTTPS = boolFromEnv("REQUIRE_HTTPS", false)
+
+// httpsMiddleware enforces HTTPS behind a TLS-terminating proxy, using the
+// X-Forwarded-Proto header it sets. Plain HTTP reads are redirected and
+// anything else is rejected. It's a no-op unless REQUIRE_HTTPS is enabled.
+func httpsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !requireHTTPS {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if isHTTPS(r) {
+			w.Header().Set("Strict-Transport-Security", hstsHeader)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			target := "https://" + r.Host + r.URL.RequestURI()
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+
+		writeError(w, errorHTTPSRequired, "", nil)
+	})
+}
+
+// isHTTPS reports whether the client reached the service over HTTPS.
+func isHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	proto := strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")[0]
+	return strings.EqualFold(strings.TrimSpace(proto), "https")
+}
+
+// defaultMaxConcurrentRequests bounds the requests handled at once, unless
+// MAX_CONCURRENT_REQUESTS configures another limit. Zero disables the limit.
+const defaultMaxConcurrentRequests = 100
+
+var maxConcurrentRequests = intFromEnv("MAX_CONCURRENT_REQUESTS", defaultMaxConcurrentRequests)
+
+// requestSlots holds a token per request in flight. It's package-level so
+// the limit holds however many times concurrencyMiddleware wraps a handler.
+var requestSlots = newRequestSlots(maxConcurrentRequests)
+
+func newRequestSlots(limit int) chan struct{} {
+	if limit <= 0 {
+		return nil
+	}
+	return make(chan struct{}, limit)
+}
+
+// limiterRetryAfter is the Retry-After sent, in seconds, when saturated.
+const limiterRetryAfter = 1
+
+// isLongLivedRoute reports whether path is served by a WebSocket or event
+// stream route. It's decided by route, since clients control their headers.
+func isLongLivedRoute(path string) bool {
+	return strings.HasPrefix(path, "/ws/chats/") || path == "/suscriptions/stream"
+}
+
+// concurrencyMiddleware rejects requests with a 503 once maxConcurrentRequests
+// are in flight, so bursts can't exhaust the Firestore quotas. WebSockets and
+// event streams stay open indefinitely, so they don't take a slot.
+func concurrencyMiddleware(next http.Handler) http.Handler {
+	if requestSlots == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isLongLivedRoute(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		select {
+		case requestSlots <- struct{}{}:
+			defer func() { <-requestSlots }()
+			next.ServeHTTP(w, r)
+		default:
+			w.Header().Set("Retry-After", strconv.Itoa(limiterRetryAfter))
+			writeError(w, errorServiceUnavailable, "", nil)
+		}
+	})
+}
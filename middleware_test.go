@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingMiddleware appends name to calls on the way in and on the way out.
+func recordingMiddleware(name string, calls *[]string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*calls = append(*calls, name+" in")
+			next.ServeHTTP(w, r)
+			*calls = append(*calls, name+" out")
+		})
+	}
+}
+
+func TestChain(t *testing.T) {
+	tests := []struct {
+		name  string
+		names []string
+		want  string
+	}{
+		{"empty", nil, "handler"},
+		{"single", []string{"a"}, "a in, handler, a out"},
+		{"declared order", []string{"a", "b", "c"}, "a in, b in, c in, handler, c out, b out, a out"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			var mw []Middleware
+			for _, name := range tt.names {
+				mw = append(mw, recordingMiddleware(name, &calls))
+			}
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, "handler")
+			})
+
+			chain(mw...)(handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if got := strings.Join(calls, ", "); got != tt.want {
+				t.Errorf("calls = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPSMiddleware(t *testing.T) {
+	restore := requireHTTPS
+	t.Cleanup(func() { requireHTTPS = restore })
+
+	tests := []struct {
+		name         string
+		require      bool
+		method       string
+		proto        string
+		tls          bool
+		wantStatus   int
+		wantLocation string
+		wantHSTS     bool
+	}{
+		{name: "disabled", method: http.MethodPost, wantStatus: http.StatusOK},
+		{name: "forwarded HTTPS", require: true, method: http.MethodPost, proto: "https", wantStatus: http.StatusOK, wantHSTS: true},
+		{name: "forwarded through proxies", require: true, method: http.MethodGet, proto: "HTTPS, http", wantStatus: http.StatusOK, wantHSTS: true},
+		{name: "direct TLS", require: true, method: http.MethodGet, tls: true, wantStatus: http.StatusOK, wantHSTS: true},
+		{name: "plain GET", require: true, method: http.MethodGet, proto: "http", wantStatus: http.StatusMovedPermanently,
+			wantLocation: "https://example.com/users?uid=alice"},
+		{name: "plain HEAD", require: true, method: http.MethodHead, wantStatus: http.StatusMovedPermanently,
+			wantLocation: "https://example.com/users?uid=alice"},
+		{name: "plain POST", require: true, method: http.MethodPost, proto: "http", wantStatus: http.StatusForbidden},
+		{name: "spoofed later hop", require: true, method: http.MethodPost, proto: "http, https", wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requireHTTPS = tt.require
+			handler := httpsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			r := httptest.NewRequest(tt.method, "http://example.com/users?uid=alice", nil)
+			if tt.proto != "" {
+				r.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+			if got := w.Header().Get("Strict-Transport-Security") != ""; got != tt.wantHSTS {
+				t.Errorf("HSTS sent = %v, want %v", got, tt.wantHSTS)
+			}
+		})
+	}
+}
+
+func TestConcurrencyMiddleware(t *testing.T) {
+	restore := requestSlots
+	requestSlots = newRequestSlots(1)
+	t.Cleanup(func() { requestSlots = restore })
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	handler := concurrencyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/users/slow" {
+			close(entered)
+			<-release
+		}
+	}))
+
+	// Holds the only slot until released
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/slow", nil))
+		close(done)
+	}()
+	<-entered
+
+	tests := []struct {
+		name           string
+		path           string
+		wantStatus     int
+		wantRetryAfter string
+	}{
+		{"saturated", "/users", http.StatusServiceUnavailable, "1"},
+		{"chat WebSocket", "/ws/chats/general", http.StatusOK, ""},
+		{"subscription stream", "/suscriptions/stream", http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.wantRetryAfter)
+			}
+		})
+	}
+
+	close(release)
+	<-done
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("status once released = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestNewRequestSlots(t *testing.T) {
+	tests := []struct {
+		limit    int
+		wantSize int
+		wantNil  bool
+	}{
+		{0, 0, true},
+		{-1, 0, true},
+		{1, 1, false},
+		{100, 100, false},
+	}
+
+	for _, tt := range tests {
+		slots := newRequestSlots(tt.limit)
+		if (slots == nil) != tt.wantNil || cap(slots) != tt.wantSize {
+			t.Errorf("newRequestSlots(%d) has %d slots, want %d", tt.limit, cap(slots), tt.wantSize)
+		}
+	}
+}
+
+func TestRecoverMiddleware(t *testing.T) {
+	restore := log.Writer()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(restore) })
+
+	tests := []struct {
+		name        string
+		panicWith   interface{}
+		wantStatus  int
+		wantRepanic bool
+	}{
+		{name: "no panic", wantStatus: http.StatusOK},
+		{name: "panic", panicWith: "boom", wantStatus: http.StatusInternalServerError},
+		{name: "error panic", panicWith: errors.New("boom"), wantStatus: http.StatusInternalServerError},
+		{name: "aborted handler", panicWith: http.ErrAbortHandler, wantRepanic: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := recoverMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.panicWith != nil {
+					panic(tt.panicWith)
+				}
+			}))
+			w := httptest.NewRecorder()
+
+			repanicked := func() (repanicked bool) {
+				defer func() { repanicked = recover() != nil }()
+				handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users", nil))
+				return false
+			}()
+
+			if repanicked != tt.wantRepanic {
+				t.Fatalf("repanicked = %v, want %v", repanicked, tt.wantRepanic)
+			}
+			if tt.wantRepanic {
+				return
+			}
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusInternalServerError {
+				if envelope := decodeEnvelope(t, w); envelope["error"] != errorInternal.Code {
+					t.Errorf("envelope = %v, want %s", envelope, errorInternal.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestLoggingMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	restoreOutput, restoreFlags := log.Writer(), log.Flags()
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(restoreOutput)
+		log.SetFlags(restoreFlags)
+	})
+
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		status  int
+		wantLog string
+	}{
+		{"implicit ok", http.MethodGet, "/users?uid=alice", 0, "INFO GET /users 200 "},
+		{"created", http.MethodPost, "/users", http.StatusCreated, "INFO POST /users 201 "},
+		{"not found", http.MethodGet, "/nowhere", http.StatusNotFound, "INFO GET /nowhere 404 "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			handler := loggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+				}
+				io.WriteString(w, "done")
+			}))
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, tt.target, nil))
+
+			line := strings.TrimSpace(buf.String())
+			if !strings.HasPrefix(line, tt.wantLog) {
+				t.Fatalf("log = %q, want it to start with %q", line, tt.wantLog)
+			}
+			if _, err := time.ParseDuration(strings.TrimPrefix(line, tt.wantLog)); err != nil {
+				t.Errorf("log = %q, want it to end with the duration: %v", line, err)
+			}
+		})
+	}
+}
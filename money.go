@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// minorUnitDigits is the number of decimals of the currencies handled, so an
+// Amount of 999 is 9.99 in the API.
+const minorUnitDigits = 2
+
+// minorUnitsPerUnit is the number of minor units in a unit of currency.
+const minorUnitsPerUnit = 100
+
+// defaultCurrency is the ISO 4217 code assumed when a write doesn't give one,
+// overridable with the DEFAULT_CURRENCY env var.
+var defaultCurrency = stringFromEnv("DEFAULT_CURRENCY", "ARS")
+
+// currencyPattern matches an ISO 4217 alphabetic code.
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// amountFields lists the Firestore fields holding an Amount.
+var amountFields = []string{"price", "cost"}
+
+var errAmountPrecision = fmt.Errorf("must have at most %d decimals", minorUnitDigits)
+
+// Amount is a monetary amount in minor units (cents). It's stored in Firestore
+// as an integer and exchanged in JSON as a decimal number, so no float
+// arithmetic ever touches it.
+type Amount int64
+
+// parseAmount reads a decimal amount such as "9.99" or "-3" into minor units.
+func parseAmount(s string) (Amount, error) {
+	negative := strings.HasPrefix(s, "-")
+	units, fraction, _ := strings.Cut(strings.TrimPrefix(s, "-"), ".")
+	if units == "" || strings.ContainsAny(units+fraction, "+-eE") {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	if len(fraction) > minorUnitDigits {
+		// Trailing zeros don't add precision, as in 9.990
+		if strings.TrimRight(fraction[minorUnitDigits:], "0") != "" {
+			return 0, errAmountPrecision
+		}
+		fraction = fraction[:minorUnitDigits]
+	}
+	fraction += strings.Repeat("0", minorUnitDigits-len(fraction))
+
+	minor, err := strconv.ParseInt(units+fraction, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	if negative {
+		minor = -minor
+	}
+	return Amount(minor), nil
+}
+
+// amountFromFloat converts a float amount, such as the prices stored before
+// amounts were kept in minor units, using its shortest decimal form.
+func amountFromFloat(f float64) (Amount, error) {
+	return parseAmount(strconv.FormatFloat(f, 'f', -1, 64))
+}
+
+// String formats the amount as a decimal, e.g. "9.99".
+func (a Amount) String() string {
+	minor := int64(a)
+	sign := ""
+	if minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+	return fmt.Sprintf("%s%d.%0*d", sign, minor/minorUnitsPerUnit, minorUnitDigits, minor%minorUnitsPerUnit)
+}
+
+// MarshalJSON encodes the amount as a decimal number.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON decodes a decimal number, rejecting sub-minor-unit precision.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err != nil {
+		return errors.New("amount must be a number")
+	}
+	amount, err := parseAmount(number.String())
+	if err != nil {
+		return err
+	}
+	*a = amount
+	return nil
+}
+
+// sanitizeCurrency uppercases currency, defaulting it to defaultCurrency when
+// empty, and checks that it's an ISO 4217 alphabetic code.
+func sanitizeCurrency(currency *string) *FieldError {
+	*currency = strings.ToUpper(strings.TrimSpace(*currency))
+	if *currency == "" {
+		*currency = defaultCurrency
+	}
+	if !currencyPattern.MatchString(*currency) {
+		return &FieldError{Field: "currency", Message: "must be an ISO 4217 code such as USD"}
+	}
+	return nil
+}
+
+// formatAmounts returns data with its amount fields turned from minor units
+// into decimal numbers for the response. data itself may be cached and
+// shared, so a copy is made instead of changing it.
+func formatAmounts(data map[string]interface{}) map[string]interface{} {
+	if data == nil {
+		return nil
+	}
+	formatted := data
+	copied := false
+	for _, field := range amountFields {
+		minor, ok := data[field].(int64)
+		if !ok {
+			continue
+		}
+		if !copied {
+			formatted = copyData(data)
+			copied = true
+		}
+		formatted[field] = json.Number(Amount(minor).String())
+	}
+	return formatted
+}
+
+// amountCollections maps each collection to the amount field of its documents.
+var amountCollections = map[string]string{
+	collections.Users:        "price",
+	collections.Talks:        "price",
+	collections.Suscriptions: "cost",
+}
+
+// MigrationSummaryType represents the outcome of a data migration
+type MigrationSummaryType struct {
+	Checked  int `json:"checked"`
+	Migrated int `json:"migrated"`
+	Failed   int `json:"failed"`
+}
+
+// migrateAmounts rewrites the amounts stored as floats, before amounts were
+// kept in minor units, as integer minor units with the default currency.
+// Firestore keeps doubles and integers apart, so already migrated documents
+// are skipped and the migration can be run again safely.
+func migrateAmounts(ctx context.Context, client *firestore.Client) (MigrationSummaryType, error) {
+	var summary MigrationSummaryType
+
+	for collection, field := range amountCollections {
+		iter := client.Collection(collection).Documents(ctx)
+		for {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				iter.Stop()
+				return summary, err
+			}
+			summary.Checked++
+
+			legacy, ok := doc.Data()[field].(float64)
+			if !ok {
+				continue
+			}
+			amount, err := amountFromFloat(legacy)
+			if err != nil {
+				amount = Amount(math.Round(legacy * minorUnitsPerUnit))
+				logWarnf("Rounding %s %s %s %v to %s", collection, doc.Ref.ID, field, legacy, amount)
+			}
+
+			updates := []firestore.Update{{Path: field, Value: amount}}
+			if currency, _ := doc.Data()["currency"].(string); currency == "" {
+				updates = append(updates, firestore.Update{Path: "currency", Value: defaultCurrency})
+			}
+			err = withRetry(ctx, func() error {
+				_, err := doc.Ref.Update(ctx, updates)
+				return err
+			})
+			readCache.Delete(cacheKey(ctx, collection, doc.Ref.ID))
+			if err != nil {
+				logErrorf("Migrating %s %s failed %v", collection, doc.Ref.ID, err)
+				summary.Failed++
+				continue
+			}
+			summary.Migrated++
+		}
+		iter.Stop()
+	}
+
+	return summary, nil
+}
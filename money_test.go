@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Amount
+		wantErr bool
+	}{
+		{"9.99", 999, false},
+		{"-3", -300, false},
+		{"0.5", 50, false},
+		{"9.990", 999, false},
+		{"12", 1200, false},
+		{"9.999", 0, true},
+		{"", 0, true},
+		{".5", 0, true},
+		{"1e3", 0, true},
+		{"+1", 0, true},
+		{"--1", 0, true},
+		{"a.bc", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseAmount(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAmount(%q) error = %v, want error %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseAmount(%q) = %d, want %d", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAmountFromFloat(t *testing.T) {
+	tests := []struct {
+		input   float64
+		want    Amount
+		wantErr bool
+	}{
+		{9.99, 999, false},
+		{0.005, 0, true},
+		{100, 10000, false},
+		{-1.5, -150, false},
+	}
+
+	for _, tt := range tests {
+		got, err := amountFromFloat(tt.input)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("amountFromFloat(%v) = %d, %v, want %d, error %v", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestAmountJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		want    Amount
+		wantErr bool
+	}{
+		{"decimal", `9.99`, 999, false},
+		{"integer", `12`, 1200, false},
+		{"negative", `-0.05`, -5, false},
+		{"null", `null`, 0, false},
+		{"too precise", `9.999`, 0, true},
+		{"not a number", `"nine"`, 0, true},
+		{"boolean", `true`, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Amount
+			err := json.Unmarshal([]byte(tt.json), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal(%s) error = %v, want error %v", tt.json, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Unmarshal(%s) = %d, want %d", tt.json, got, tt.want)
+			}
+		})
+	}
+
+	marshalTests := []struct {
+		amount Amount
+		want   string
+	}{
+		{999, "9.99"},
+		{-5, "-0.05"},
+		{0, "0.00"},
+		{1200, "12.00"},
+	}
+
+	for _, tt := range marshalTests {
+		got, err := json.Marshal(tt.amount)
+		if err != nil || string(got) != tt.want {
+			t.Errorf("Marshal(%d) = %s, %v, want %s", tt.amount, got, err, tt.want)
+		}
+	}
+}
+
+func TestFormatAmounts(t *testing.T) {
+	tests := []struct {
+		name string
+		data map[string]interface{}
+		want map[string]interface{}
+	}{
+		{"nil", nil, nil},
+		{"price", map[string]interface{}{"uid": "alice", "price": int64(999)},
+			map[string]interface{}{"uid": "alice", "price": json.Number("9.99")}},
+		{"cost", map[string]interface{}{"cost": int64(-150)}, map[string]interface{}{"cost": json.Number("-1.50")}},
+		{"legacy float", map[string]interface{}{"price": 9.99}, map[string]interface{}{"price": 9.99}},
+		{"no amounts", map[string]interface{}{"uid": "alice"}, map[string]interface{}{"uid": "alice"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var original map[string]interface{}
+			if tt.data != nil {
+				original = copyData(tt.data)
+			}
+
+			got := formatAmounts(tt.data)
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("formatAmounts = %v, want %v", got, tt.want)
+			}
+			for field, want := range tt.want {
+				if got[field] != want {
+					t.Errorf("%s = %#v, want %#v", field, got[field], want)
+				}
+			}
+			// The input may be cached, so it must be left untouched
+			for field, want := range original {
+				if tt.data[field] != want {
+					t.Errorf("input %s changed to %#v", field, tt.data[field])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// openAPISpec is the OpenAPI 3 description of the users and suscriptions
+// endpoints. Schemas are derived from the field types, so they follow them.
+var openAPISpec = map[string]interface{}{
+	"openapi": "3.0.3",
+	"info": map[string]interface{}{
+		"title":   "Talkit API",
+		"version": "1.0.0",
+	},
+	"paths": map[string]interface{}{
+		"/users": map[string]interface{}{
+			"get": openAPIOperation("Get a user by uid or slug", "User", false,
+				openAPIQueryParam("uid"), openAPIQueryParam("slug")),
+			"post":   openAPIBodyOperation("Create a user", "UserCreate", "User", http.StatusCreated),
+			"put":    openAPIBodyOperation("Replace a user", "UserInput", "", http.StatusOK),
+			"patch":  openAPIBodyOperation("Update some fields of a user", "UserInput", "", http.StatusOK),
+			"delete": openAPIBodyOperation("Delete a user", "UserDelete", "", http.StatusOK),
+		},
+		"/suscriptions": map[string]interface{}{
+			"get": openAPIOperation("Get the suscription of a user", "Suscription", false,
+				openAPIQueryParam("uid")),
+			"post":   openAPIBodyOperation("Create a suscription", "Suscription", "", http.StatusCreated),
+			"put":    openAPIBodyOperation("Replace a suscription", "Suscription", "", http.StatusOK),
+			"delete": openAPIBodyOperation("Delete a suscription", "Delete", "", http.StatusOK),
+		},
+	},
+	"components": map[string]interface{}{
+		"schemas": map[string]interface{}{
+			// Users are read back with their Firestore field names but
+			// decoded from request bodies by their Go field names. Creates
+			// may leave the ID out, defaulting it to the caller's uid
+			"User":        openAPISchema(reflect.TypeOf(UsersFieldsType{}), "firestore"),
+			"UserInput":   openAPISchema(reflect.TypeOf(UsersFieldsType{}), "json"),
+			"UserCreate":  openAPIOptional(openAPISchema(reflect.TypeOf(UsersFieldsType{}), "json"), "ID"),
+			"Suscription": openAPISchema(reflect.TypeOf(SubscriptionFieldsType{}), "json"),
+			"Delete":      openAPISchema(reflect.TypeOf(DeleteType{}), "json"),
+			"UserDelete":  openAPISchema(reflect.TypeOf(UserDeleteType{}), "json"),
+		},
+		"securitySchemes": map[string]interface{}{
+			"firebase": map[string]interface{}{
+				"type":        "apiKey",
+				"in":          "header",
+				"name":        "Authorization",
+				"description": "Firebase ID token",
+			},
+		},
+	},
+}
+
+// OpenAPIHandler serves the OpenAPI description of the api.
+func OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if handleCORS(w, r, http.MethodGet, http.MethodOptions) {
+		return
+	}
+
+	switch method := r.Method; method {
+	case http.MethodGet:
+		setPublicCache(w)
+		writeJSONWithETag(w, r, openAPISpec)
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodOptions)
+	}
+}
+
+func openAPIRef(schema string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + schema}
+}
+
+func openAPIQueryParam(name string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":   name,
+		"in":     "query",
+		"schema": map[string]interface{}{"type": "string"},
+	}
+}
+
+// openAPIOperation describes a read returning the schema, or a list of it.
+func openAPIOperation(summary string, schema string, list bool, params ...map[string]interface{}) map[string]interface{} {
+	content := openAPIRef(schema)
+	if list {
+		content = map[string]interface{}{"type": "array", "items": content}
+	}
+
+	return map[string]interface{}{
+		"summary":    summary,
+		"parameters": params,
+		"responses": map[string]interface{}{
+			"200": map[string]interface{}{
+				"description": "OK",
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": content},
+				},
+			},
+			"404": map[string]interface{}{"description": "Not found"},
+		},
+	}
+}
+
+// openAPIBodyOperation describes an authenticated write taking the request
+// schema. An empty response schema documents a response without body.
+func openAPIBodyOperation(summary string, request string, response string, code int) map[string]interface{} {
+	success := map[string]interface{}{"description": http.StatusText(code)}
+	if response != "" {
+		success["content"] = map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": openAPIRef(response)},
+		}
+	}
+
+	return map[string]interface{}{
+		"summary":  summary,
+		"security": []map[string]interface{}{{"firebase": []string{}}},
+		"requestBody": map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": openAPIRef(request)},
+			},
+		},
+		"responses": map[string]interface{}{
+			strconv.Itoa(code): success,
+			"400":              map[string]interface{}{"description": "Invalid body"},
+			"403":              map[string]interface{}{"description": "Forbidden"},
+		},
+	}
+}
+
+// openAPISchema describes the struct type t, naming its properties after the
+// given struct tag. Untagged fields keep their Go name, like encoding/json.
+func openAPISchema(t reflect.Type, tag string) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		name := strings.Split(field.Tag.Get(tag), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		properties[name] = openAPIType(field.Type)
+		if strings.Contains(field.Tag.Get("validate"), "required") {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// openAPIOptional returns schema with the named properties no longer required.
+func openAPIOptional(schema map[string]interface{}, names ...string) map[string]interface{} {
+	required, _ := schema["required"].([]string)
+	var kept []string
+	for _, name := range required {
+		if !containsString(names, name) {
+			kept = append(kept, name)
+		}
+	}
+
+	if len(kept) > 0 {
+		schema["required"] = kept
+	} else {
+		delete(schema, "required")
+	}
+	return schema
+}
+
+// openAPIType maps a Go field type to its OpenAPI schema.
+func openAPIType(t reflect.Type) map[string]interface{} {
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	// Amounts are stored in minor units but exchanged as decimals
+	if t == reflect.TypeOf(Amount(0)) {
+		return map[string]interface{}{"type": "number", "multipleOf": 1.0 / minorUnitsPerUnit}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": openAPIType(t.Elem())}
+	default:
+		return map[string]interface{}{"type": "string"}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPIHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	OpenAPIHandler(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var spec struct {
+		OpenAPI    string                                `json:"openapi"`
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]map[string]interface{} `json:"properties"`
+				Required   []string                          `json:"required"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("Decoding spec: %v", err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		t.Errorf("openapi = %q, want a 3.x spec", spec.OpenAPI)
+	}
+
+	t.Run("routes", func(t *testing.T) {
+		// Every documented operation must be one the route serves
+		for _, route := range routeMethods {
+			operations, ok := spec.Paths[route.route]
+			if !ok {
+				continue
+			}
+			var documented, served []string
+			for method := range operations {
+				documented = append(documented, strings.ToUpper(method))
+			}
+			served = append(served, route.methods...)
+			sort.Strings(documented)
+			sort.Strings(served)
+			if strings.Join(documented, ",") != strings.Join(served, ",") {
+				t.Errorf("%s documents %v, want %v", route.route, documented, served)
+			}
+		}
+		for _, path := range []string{"/users", "/suscriptions"} {
+			if _, ok := spec.Paths[path]; !ok {
+				t.Errorf("%s isn't documented", path)
+			}
+		}
+	})
+
+	tests := []struct {
+		schema       string
+		wantFields   []string
+		wantRequired string
+	}{
+		{"User", []string{"uid", "displayName", "price", "currency", "type", "year", "image", "description", "slug", "createdAt", "updatedAt"}, "uid,displayName"},
+		{"UserInput", []string{"ID", "Name", "Price", "Slug"}, "ID,Name"},
+		{"UserCreate", []string{"ID", "Name", "Price"}, "Name"},
+		{"Suscription", []string{"uid", "expired", "suscriptionType", "cost", "currency", "expireAt"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.schema, func(t *testing.T) {
+			schema, ok := spec.Components.Schemas[tt.schema]
+			if !ok {
+				t.Fatalf("schema %s is missing", tt.schema)
+			}
+			for _, field := range tt.wantFields {
+				if _, ok := schema.Properties[field]; !ok {
+					t.Errorf("%s has no %s property", tt.schema, field)
+				}
+			}
+			if got := strings.Join(schema.Required, ","); got != tt.wantRequired {
+				t.Errorf("%s requires %s, want %s", tt.schema, got, tt.wantRequired)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// errPreconditionFailed is returned when the document changed since the
+// update time the client based its write on.
+var errPreconditionFailed = errors.New("document was modified since the given update time")
+
+// updatePrecondition reports whether the current document, last updated by
+// the API at updatedAt, satisfies the client's precondition. updatedAt is zero
+// when the document doesn't exist.
+type updatePrecondition func(updatedAt time.Time) bool
+
+// parseUpdatePrecondition reads the optional write precondition of a request.
+// The updateTime query parameter (RFC 3339, as returned in updatedAt) must
+// match the document's updatedAt exactly, while the If-Unmodified-Since
+// header, having only second precision, must not be older than it.
+// It returns a nil precondition when the request has none.
+func parseUpdatePrecondition(r *http.Request) (updatePrecondition, error) {
+	if value := r.URL.Query().Get("updateTime"); value != "" {
+		updateTime, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return nil, errors.New("updateTime must be an RFC 3339 timestamp")
+		}
+		return func(updatedAt time.Time) bool {
+			return !updatedAt.IsZero() && updatedAt.Equal(updateTime)
+		}, nil
+	}
+
+	if value := r.Header.Get("If-Unmodified-Since"); value != "" {
+		since, err := http.ParseTime(value)
+		if err != nil {
+			return nil, errors.New("If-Unmodified-Since must be an HTTP date")
+		}
+		return func(updatedAt time.Time) bool {
+			return !updatedAt.IsZero() && !updatedAt.Truncate(time.Second).After(since)
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// documentUpdatedAt returns the updatedAt field of the document, or the zero
+// time when it doesn't exist. Preconditions are checked against it rather
+// than the document's UpdateTime, which presence heartbeats also change.
+func documentUpdatedAt(current *firestore.DocumentSnapshot) time.Time {
+	if !current.Exists() {
+		return time.Time{}
+	}
+	value, err := current.DataAt("updatedAt")
+	if err != nil {
+		return time.Time{}
+	}
+	updatedAt, _ := value.(time.Time)
+	return updatedAt
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseUpdatePrecondition(t *testing.T) {
+	updatedAt := time.Date(2024, 3, 1, 10, 0, 0, 123456000, time.UTC)
+
+	tests := []struct {
+		name          string
+		query         string
+		ifUnmodified  string
+		updatedAt     time.Time
+		wantNil       bool
+		wantErr       bool
+		wantSatisfied bool
+	}{
+		{name: "no precondition", wantNil: true},
+		{name: "invalid updateTime", query: "updateTime=yesterday", wantErr: true},
+		{name: "invalid If-Unmodified-Since", ifUnmodified: "yesterday", wantErr: true},
+		{name: "matching updateTime", query: "updateTime=2024-03-01T10:00:00.123456Z", updatedAt: updatedAt, wantSatisfied: true},
+		{name: "stale updateTime", query: "updateTime=2024-03-01T09:00:00Z", updatedAt: updatedAt},
+		{name: "updateTime of a missing document", query: "updateTime=2024-03-01T10:00:00.123456Z"},
+		{name: "unmodified since", ifUnmodified: "Fri, 01 Mar 2024 10:00:00 GMT", updatedAt: updatedAt, wantSatisfied: true},
+		{name: "modified since", ifUnmodified: "Fri, 01 Mar 2024 09:59:59 GMT", updatedAt: updatedAt},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut, "/users?"+tt.query, nil)
+			if tt.ifUnmodified != "" {
+				r.Header.Set("If-Unmodified-Since", tt.ifUnmodified)
+			}
+
+			precondition, err := parseUpdatePrecondition(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if (precondition == nil) != tt.wantNil {
+				t.Fatalf("precondition = %v, want nil %v", precondition, tt.wantNil)
+			}
+			if tt.wantNil {
+				return
+			}
+			if got := precondition(tt.updatedAt); got != tt.wantSatisfied {
+				t.Errorf("precondition(%v) = %v, want %v", tt.updatedAt, got, tt.wantSatisfied)
+			}
+		})
+	}
+}
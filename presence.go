@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"firebase.google.com/go/auth"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// defaultPresenceTimeout is how long a user may go without a heartbeat
+// before being considered offline.
+const defaultPresenceTimeout = 5 * time.Minute
+
+// presenceTimeout is overridable with the PRESENCE_TIMEOUT env var.
+var presenceTimeout = durationFromEnv("PRESENCE_TIMEOUT", defaultPresenceTimeout)
+
+// presenceFields are the user fields kept by the presence endpoint rather
+// than by user writes.
+var presenceFields = []string{"online", "lastSeen"}
+
+// PresenceType is the body accepted by the presence endpoint.
+type PresenceType struct {
+	ID     string `json:"uid"`
+	Online bool   `json:"online"`
+}
+
+// UsersPresenceAPI is an HTTP Cloud Function recording whether a user is online.
+func UsersPresenceAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := projectContext(r)
+
+	app, client, ok := initFirebase(w, r)
+	if !ok {
+		return
+	}
+
+	if handleCORS(w, r, http.MethodPut, http.MethodOptions) {
+		return
+	}
+
+	// Bound the Firestore work so a hung call can't block the request
+	ctx, cancel := context.WithTimeout(ctx, firestoreTimeout)
+	defer cancel()
+
+	switch method := r.Method; method {
+	case http.MethodPut:
+		token := authorizeRequest(w, app, r)
+		if token == nil {
+			return
+		}
+		updatePresence(ctx, storeFor(client), token, w, r)
+	default:
+		writeMethodNotAllowed(w, http.MethodPut, http.MethodOptions)
+	}
+}
+
+func updatePresence(ctx context.Context, store documentStore, token *auth.Token, w http.ResponseWriter, r *http.Request) {
+	if !requireJSON(w, r) {
+		return
+	}
+
+	var Body PresenceType
+
+	err := decodeStrict(r.Body, &Body)
+	if err != nil {
+		logDebugf("Unmarshalling json failed %v", err)
+		writeDecodeError(w, err)
+		return
+	}
+	defer r.Body.Close()
+
+	// Without an explicit uid the presence is the caller's own
+	if Body.ID == "" {
+		Body.ID = token.UID
+	}
+	if !canModifyUser(token, Body.ID) {
+		writeForbidden(w, "You can only update your own presence")
+		return
+	}
+
+	err = withRetry(ctx, func() error {
+		return store.Update(ctx, collections.Users, Body.ID, []firestore.Update{
+			{Path: "online", Value: Body.Online},
+			{Path: "lastSeen", Value: firestore.ServerTimestamp},
+		})
+	})
+	readCache.Delete(cacheKey(ctx, collections.Users, Body.ID))
+	if status.Code(err) == codes.NotFound {
+		writeNotFound(w, "User not found")
+		return
+	}
+	if err != nil {
+		writeFirestoreError(w, "Document update failed", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// markIdleUsersOffline flips online to false for every user whose lastSeen is
+// older than presenceTimeout, returning how many users were marked offline.
+func markIdleUsersOffline(ctx context.Context, client *firestore.Client) (int, error) {
+	cutoff := time.Now().Add(-presenceTimeout)
+
+	iter := client.Collection(collections.Users).
+		Where("online", "==", true).
+		Where("lastSeen", "<", cutoff).
+		Documents(ctx)
+	defer iter.Stop()
+
+	marked := 0
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return marked, err
+		}
+
+		// Only flip the flag if no heartbeat arrived since the query ran
+		_, err = doc.Ref.Update(ctx, []firestore.Update{{Path: "online", Value: false}}, firestore.LastUpdateTime(doc.UpdateTime))
+		readCache.Delete(cacheKey(ctx, collections.Users, doc.Ref.ID))
+		if status.Code(err) == codes.FailedPrecondition {
+			continue
+		}
+		if err != nil {
+			logErrorf("Marking user %s offline failed %v", doc.Ref.ID, err)
+			continue
+		}
+		marked++
+	}
+
+	return marked, nil
+}
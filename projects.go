@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/firestore"
+	firebase "firebase.google.com/go"
+	"google.golang.org/api/option"
+)
+
+// defaultProjectID is the Firebase project requests use unless they select
+// another registered one.
+const defaultProjectID = "talkit-199f9"
+
+// projectHeader selects the project a request's Firestore calls go to.
+const projectHeader = "X-Firebase-Project"
+
+// projectConfig describes a Firebase project the backend may talk to. An
+// empty CredentialsFile uses the default credentials.
+type projectConfig struct {
+	ProjectID       string
+	CredentialsFile string
+}
+
+// parseProjectConfigs reads FIREBASE_PROJECTS, a comma-separated list of
+// project IDs, each optionally followed by "=" and the path of the service
+// account key used for it. The default project is always registered.
+func parseProjectConfigs(value string) map[string]projectConfig {
+	configs := map[string]projectConfig{
+		defaultProjectID: {ProjectID: defaultProjectID},
+	}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		projectID, credentials, _ := strings.Cut(entry, "=")
+		configs[projectID] = projectConfig{ProjectID: projectID, CredentialsFile: credentials}
+	}
+	return configs
+}
+
+// errUnregisteredProject is returned for projects missing from FIREBASE_PROJECTS.
+var errUnregisteredProject = errors.New("project is not registered")
+
+// firebaseProject is the Firebase app of a project and its Firestore client.
+type firebaseProject struct {
+	App    *firebase.App
+	Client *firestore.Client
+}
+
+// projectRegistry creates the app and Firestore client of each registered
+// project on first use and shares them between requests. Its clients must
+// not be closed by callers.
+type projectRegistry struct {
+	mu       sync.Mutex
+	configs  map[string]projectConfig
+	projects map[string]*firebaseProject
+}
+
+var projects = &projectRegistry{
+	configs:  parseProjectConfigs(stringFromEnv("FIREBASE_PROJECTS", "")),
+	projects: map[string]*firebaseProject{},
+}
+
+// Project returns the app and client of the registered project.
+func (reg *projectRegistry) Project(projectID string) (*firebaseProject, error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if project, ok := reg.projects[projectID]; ok {
+		return project, nil
+	}
+	config, ok := reg.configs[projectID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errUnregisteredProject, projectID)
+	}
+
+	opts := firebaseOptions()
+	if config.CredentialsFile != "" && firestoreEmulatorHost() == "" {
+		opts = append(opts, option.WithCredentialsFile(config.CredentialsFile))
+	}
+
+	// Both outlive the request that created them
+	ctx := context.Background()
+	conf := &firebase.Config{ProjectID: projectID, StorageBucket: storageBucket}
+	app, err := firebase.NewApp(ctx, conf, opts...)
+	if err != nil {
+		return nil, err
+	}
+	// The client is created directly, since the app only knows the default database
+	client, err := newFirestoreClient(ctx, projectID, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	project := &firebaseProject{App: app, Client: client}
+	reg.projects[projectID] = project
+	return project, nil
+}
+
+type projectContextKey struct{}
+
+// requestProjectID returns the project selected by the request's
+// X-Firebase-Project header, or the default project.
+func requestProjectID(r *http.Request) string {
+	if projectID := r.Header.Get(projectHeader); projectID != "" {
+		return projectID
+	}
+	return defaultProjectID
+}
+
+// projectContext returns the context of r carrying the project it selects, so
+// the caches shared between requests keep each project's documents apart.
+func projectContext(r *http.Request) context.Context {
+	return context.WithValue(r.Context(), projectContextKey{}, requestProjectID(r))
+}
+
+// projectFromContext returns the project carried by ctx, or the default one.
+func projectFromContext(ctx context.Context) string {
+	if projectID, ok := ctx.Value(projectContextKey{}).(string); ok {
+		return projectID
+	}
+	return defaultProjectID
+}
+
+// initFirebase returns the Firebase app and Firestore client of the project
+// selected by the request's X-Firebase-Project header, or of the default
+// project. They're shared between requests, so handlers must not close the
+// client. It returns false after writing a 400 response for unregistered
+// projects, or a 500 one when the project can't be initialized. Tests replace
+// it to run handlers without a Firebase project.
+var initFirebase = projectForRequest
+
+func projectForRequest(w http.ResponseWriter, r *http.Request) (*firebase.App, *firestore.Client, bool) {
+	projectID := requestProjectID(r)
+
+	project, err := projects.Project(projectID)
+	if errors.Is(err, errUnregisteredProject) {
+		writeBadRequest(w, "Unknown project "+projectID)
+		return nil, nil, false
+	}
+	if err != nil {
+		logErrorf("Firebase init for %s: %v", projectID, err)
+		writeInternalError(w)
+		return nil, nil, false
+	}
+	return project.App, project.Client, true
+}
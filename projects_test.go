@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseProjectConfigs(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  map[string]projectConfig
+	}{
+		{"unset", "", map[string]projectConfig{
+			defaultProjectID: {ProjectID: defaultProjectID},
+		}},
+		{"extra projects", " analytics=/keys/analytics.json, staging ,", map[string]projectConfig{
+			defaultProjectID: {ProjectID: defaultProjectID},
+			"analytics":      {ProjectID: "analytics", CredentialsFile: "/keys/analytics.json"},
+			"staging":        {ProjectID: "staging"},
+		}},
+		{"default with credentials", defaultProjectID + "=/keys/default.json", map[string]projectConfig{
+			defaultProjectID: {ProjectID: defaultProjectID, CredentialsFile: "/keys/default.json"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseProjectConfigs(tt.value); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseProjectConfigs(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestProject(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"default project", "", defaultProjectID},
+		{"selected project", "analytics", "analytics"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/users", nil)
+			if tt.header != "" {
+				r.Header.Set(projectHeader, tt.header)
+			}
+
+			if got := requestProjectID(r); got != tt.want {
+				t.Errorf("requestProjectID = %q, want %q", got, tt.want)
+			}
+			if got := projectFromContext(projectContext(r)); got != tt.want {
+				t.Errorf("projectFromContext = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProjectRegistry(t *testing.T) {
+	// Pointing at the emulator keeps the clients from looking up credentials
+	t.Setenv("FIRESTORE_EMULATOR_HOST", "127.0.0.1:1")
+
+	reg := &projectRegistry{
+		configs:  parseProjectConfigs("analytics"),
+		projects: map[string]*firebaseProject{},
+	}
+	t.Cleanup(func() {
+		for _, project := range reg.projects {
+			project.Client.Close()
+		}
+	})
+
+	clients := map[string]*firebaseProject{}
+	for _, projectID := range []string{defaultProjectID, "analytics"} {
+		project, err := reg.Project(projectID)
+		if err != nil {
+			t.Fatalf("Project(%s): %v", projectID, err)
+		}
+		again, err := reg.Project(projectID)
+		if err != nil || again != project {
+			t.Errorf("Project(%s) again = %p, %v, want the shared %p", projectID, again, err, project)
+		}
+		clients[projectID] = project
+	}
+	if clients[defaultProjectID].Client == clients["analytics"].Client {
+		t.Error("both projects share a Firestore client")
+	}
+
+	if _, err := reg.Project("unknown"); !errors.Is(err, errUnregisteredProject) {
+		t.Errorf("Project(unknown) error = %v, want %v", err, errUnregisteredProject)
+	}
+}
+
+func TestProjectForRequestUnregistered(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/users", nil)
+	r.Header.Set(projectHeader, "unregistered-project")
+	w := httptest.NewRecorder()
+
+	if _, _, ok := projectForRequest(w, r); ok {
+		t.Fatal("projectForRequest accepted an unregistered project")
+	}
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// defaultPageSize is the number of documents returned when no limit is given.
+const defaultPageSize = 20
+
+// defaultMaxPageSize caps the limit a client may ask for, unless MAX_PAGE_SIZE
+// configures another cap.
+const defaultMaxPageSize = 100
+
+var maxPageSize = intFromEnv("MAX_PAGE_SIZE", defaultMaxPageSize)
+
+// parseLimit reads the limit query parameter, clamped to maxPageSize. Missing,
+// non-numeric and non-positive values fall back to defaultPageSize.
+func parseLimit(r *http.Request) int {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil {
+		return defaultPageSize
+	}
+	return clampLimit(limit)
+}
+
+// clampLimit bounds limit to maxPageSize, using defaultPageSize when it is not
+// positive.
+func clampLimit(limit int) int {
+	if limit <= 0 {
+		return defaultPageSize
+	}
+	if limit > maxPageSize {
+		return maxPageSize
+	}
+	return limit
+}
+
+// nextCursorHeader carries the startAfter value of a listing's next page. It's
+// only set on full pages, a shorter one being the last.
+const nextCursorHeader = "X-Next-Cursor"
+
+// applyStartAfter continues query after the document of collection named by
+// the startAfter query parameter, the last one of the previous page. It
+// returns false after writing a 404 response when that document doesn't
+// exist, or the error of the lookup.
+func applyStartAfter(ctx context.Context, client *firestore.Client, w http.ResponseWriter, r *http.Request, collection string, query firestore.Query) (firestore.Query, bool) {
+	startAfter := r.URL.Query().Get("startAfter")
+	if startAfter == "" {
+		return query, true
+	}
+
+	doc, err := client.Collection(collection).Doc(startAfter).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		writeNotFound(w, "startAfter document not found")
+		return query, false
+	}
+	if err != nil {
+		writeFirestoreError(w, "Reading document failed", err)
+		return query, false
+	}
+	return query.StartAfter(doc), true
+}
+
+// setNextCursor sets the next page cursor of a listing when the page of
+// limit documents, ending with lastID, is full.
+func setNextCursor(w http.ResponseWriter, count int, limit int, lastID string) {
+	if count == limit {
+		w.Header().Set(nextCursorHeader, lastID)
+	}
+}
+
+// talkSortFields maps the sortable talk fields accepted in the sort query
+// parameter to their Firestore field names.
+var talkSortFields = map[string]string{
+	"name":  "displayName",
+	"price": "price",
+	"year":  "year",
+}
+
+// applySort orders the query by the sort query parameter. A leading "-"
+// sorts descending. Fields not present in allowed are rejected.
+func applySort(query firestore.Query, sort string, allowed map[string]string) (firestore.Query, error) {
+	if sort == "" {
+		return query, nil
+	}
+
+	direction := firestore.Asc
+	if strings.HasPrefix(sort, "-") {
+		direction = firestore.Desc
+		sort = strings.TrimPrefix(sort, "-")
+	}
+
+	field, ok := allowed[sort]
+	if !ok {
+		return query, fmt.Errorf("unknown sort field %q", sort)
+	}
+
+	return query.OrderBy(field, direction), nil
+}
+
+// countQuery returns the number of documents matching query using an
+// aggregation, without fetching the documents themselves.
+func countQuery(ctx context.Context, query firestore.Query) (int64, error) {
+	result, err := query.NewAggregationQuery().WithCount("count").Get(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	value, ok := result["count"].(*firestorepb.Value)
+	if !ok {
+		return 0, fmt.Errorf("unexpected count result %v", result["count"])
+	}
+	return value.GetIntegerValue(), nil
+}
+
+// totalCountHeader carries the number of documents matching a listing, before
+// it's paginated.
+const totalCountHeader = "X-Total-Count"
+
+// setTotalCount sets the total count header of a listing when the request
+// asks for it with includeTotal=true, since counting costs an extra query.
+// query must not be limited yet. It returns false after writing an error
+// response.
+func setTotalCount(ctx context.Context, w http.ResponseWriter, r *http.Request, query firestore.Query) bool {
+	includeTotal, _ := strconv.ParseBool(r.URL.Query().Get("includeTotal"))
+	if !includeTotal {
+		return true
+	}
+
+	total, err := countQuery(ctx, query)
+	if err != nil {
+		writeFirestoreError(w, "Counting documents failed", err)
+		return false
+	}
+	w.Header().Set(totalCountHeader, strconv.FormatInt(total, 10))
+	return true
+}
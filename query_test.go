@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		limit string
+		want  int
+	}{
+		{"", defaultPageSize},
+		{"abc", defaultPageSize},
+		{"0", defaultPageSize},
+		{"-5", defaultPageSize},
+		{"7", 7},
+		{strconv.Itoa(maxPageSize), maxPageSize},
+		{strconv.Itoa(maxPageSize + 1), maxPageSize},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/users/list?limit="+tt.limit, nil)
+		if got := parseLimit(r); got != tt.want {
+			t.Errorf("parseLimit(%q) = %d, want %d", tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestClampLimit(t *testing.T) {
+	restore := maxPageSize
+	maxPageSize = 50
+	t.Cleanup(func() { maxPageSize = restore })
+
+	tests := []struct {
+		limit int
+		want  int
+	}{
+		{-1, defaultPageSize},
+		{0, defaultPageSize},
+		{1, 1},
+		{49, 49},
+		{50, 50},
+		{51, 50},
+		{1000, 50},
+	}
+
+	for _, tt := range tests {
+		if got := clampLimit(tt.limit); got != tt.want {
+			t.Errorf("clampLimit(%d) = %d, want %d", tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestApplySort(t *testing.T) {
+	tests := []struct {
+		sort    string
+		wantErr bool
+	}{
+		{"", false},
+		{"name", false},
+		{"-price", false},
+		{"createdAt", true},
+		{"-", true},
+	}
+
+	for _, tt := range tests {
+		_, err := applySort(firestore.Query{}, tt.sort, talkSortFields)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("applySort(%q) error = %v, want error %v", tt.sort, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSetNextCursor(t *testing.T) {
+	tests := []struct {
+		name  string
+		count int
+		want  string
+	}{
+		{"full page", 2, "bob"},
+		{"last page", 1, ""},
+		{"empty page", 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			setNextCursor(w, tt.count, 2, "bob")
+			if got := w.Header().Get(nextCursorHeader); got != tt.want {
+				t.Errorf("%s = %q, want %q", nextCursorHeader, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetTotalCountSkipped(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+	}{
+		{"not asked", "/users/list"},
+		{"disabled", "/users/list?includeTotal=false"},
+		{"invalid", "/users/list?includeTotal=sometimes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The offline client can't count, so success means no query ran
+			query := newOfflineClient(t).Collection(collections.Users).Query
+			w := httptest.NewRecorder()
+
+			if !setTotalCount(context.Background(), w, httptest.NewRequest(http.MethodGet, tt.target, nil), query) {
+				t.Fatalf("setTotalCount failed: %s", w.Body)
+			}
+			if got := w.Header().Get(totalCountHeader); got != "" {
+				t.Errorf("%s = %q, want it unset", totalCountHeader, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// hijackableRecorder is a ResponseRecorder supporting Hijack, as servers do.
+type hijackableRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (w hijackableRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, nil
+}
+
+func TestStatusRecorder(t *testing.T) {
+	tests := []struct {
+		name       string
+		handle     func(rec *statusRecorder)
+		wantStatus int
+	}{
+		{"nothing written", func(rec *statusRecorder) {}, http.StatusOK},
+		{"header", func(rec *statusRecorder) { rec.WriteHeader(http.StatusNotFound) }, http.StatusNotFound},
+		{"body only", func(rec *statusRecorder) { rec.Write([]byte("{}")) }, http.StatusOK},
+		{"superfluous header", func(rec *statusRecorder) {
+			rec.WriteHeader(http.StatusCreated)
+			rec.WriteHeader(http.StatusInternalServerError)
+		}, http.StatusCreated},
+		{"header after body", func(rec *statusRecorder) {
+			rec.Write([]byte("{}"))
+			rec.WriteHeader(http.StatusInternalServerError)
+		}, http.StatusOK},
+		{"flush", func(rec *statusRecorder) {
+			rec.Flush()
+			rec.WriteHeader(http.StatusInternalServerError)
+		}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			rec := newStatusRecorder(w)
+
+			tt.handle(rec)
+
+			if got := rec.Status(); got != tt.wantStatus {
+				t.Errorf("Status() = %d, want %d", got, tt.wantStatus)
+			}
+			if w.Code != tt.wantStatus {
+				t.Errorf("status sent = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestStatusRecorderHijack(t *testing.T) {
+	tests := []struct {
+		name       string
+		w          http.ResponseWriter
+		wantErr    bool
+		wantStatus int
+	}{
+		{"hijackable", hijackableRecorder{httptest.NewRecorder()}, false, http.StatusSwitchingProtocols},
+		{"not hijackable", httptest.NewRecorder(), true, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := newStatusRecorder(tt.w)
+
+			_, _, err := rec.Hijack()
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Hijack error = %v, want error %v", err, tt.wantErr)
+			}
+			if got := rec.Status(); got != tt.wantStatus {
+				t.Errorf("Status() = %d, want %d", got, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Retry policy for transient Firestore errors
+const (
+	maxRetryAttempts = 4
+	initialBackoff   = 100 * time.Millisecond
+)
+
+// isRetryable reports whether err is a transient Firestore error worth retrying.
+func isRetryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// isRetryableWrite reports whether a non-idempotent write, such as a Create or
+// a Delete requiring the document to exist, is worth retrying after err. A
+// write that hit its deadline may have been applied already, and running it
+// again would then fail with AlreadyExists or NotFound, so only Unavailable is.
+func isRetryableWrite(err error) bool {
+	return status.Code(err) == codes.Unavailable
+}
+
+// withRetry runs op, retrying transient failures with exponential backoff
+// until it succeeds, fails permanently, runs out of attempts or ctx is done.
+func withRetry(ctx context.Context, op func() error) error {
+	return retry(ctx, isRetryable, op)
+}
+
+// withWriteRetry is withRetry for non-idempotent writes, see isRetryableWrite.
+func withWriteRetry(ctx context.Context, op func() error) error {
+	return retry(ctx, isRetryableWrite, op)
+}
+
+func retry(ctx context.Context, retryable func(error) bool, op func() error) error {
+	backoff := initialBackoff
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || !retryable(err) || attempt == maxRetryAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRetry(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "try again")
+	deadline := status.Error(codes.DeadlineExceeded, "too slow")
+	notFound := status.Error(codes.NotFound, "gone")
+
+	tests := []struct {
+		name         string
+		retry        func(ctx context.Context, op func() error) error
+		errs         []error
+		wantErr      error
+		wantAttempts int
+	}{
+		{"success", withRetry, nil, nil, 1},
+		{"unavailable then success", withRetry, []error{unavailable}, nil, 2},
+		{"deadline then success", withRetry, []error{deadline}, nil, 2},
+		{"permanent failure", withRetry, []error{notFound}, notFound, 1},
+		{"out of attempts", withRetry, []error{unavailable, unavailable, unavailable, unavailable, unavailable}, unavailable, maxRetryAttempts},
+		{"write unavailable then success", withWriteRetry, []error{unavailable}, nil, 2},
+		{"write deadline", withWriteRetry, []error{deadline}, deadline, 1},
+		{"write permanent failure", withWriteRetry, []error{notFound}, notFound, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := tt.retry(context.Background(), func() error {
+				attempts++
+				if attempts <= len(tt.errs) {
+					return tt.errs[attempts-1]
+				}
+				return nil
+			})
+
+			if err != tt.wantErr {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestRetryStopsWhenContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	attempts := 0
+	unavailable := status.Error(codes.Unavailable, "try again")
+	err := withRetry(ctx, func() error {
+		attempts++
+		return unavailable
+	})
+
+	if !errors.Is(err, unavailable) || attempts != 1 {
+		t.Errorf("withRetry = %v after %d attempts, want %v after 1", err, attempts, unavailable)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	firebase "firebase.google.com/go"
+	"firebase.google.com/go/auth"
+)
+
+// RevokeType represents the body expected structure of a revoke http call
+type RevokeType struct {
+	ID string `json:"uid"`
+}
+
+// UsersRevokeAPI is an HTTP Cloud Function revoking the refresh tokens of a
+// user, forcing every session to authenticate again.
+func UsersRevokeAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := projectContext(r)
+
+	app, _, ok := initFirebase(w, r)
+	if !ok {
+		return
+	}
+
+	if handleCORS(w, r, http.MethodPost, http.MethodOptions) {
+		return
+	}
+
+	switch method := r.Method; method {
+	case http.MethodPost:
+		token := authorizeRequest(w, app, r)
+		if token == nil {
+			return
+		}
+		revokeTokens(ctx, app, token, w, r)
+	default:
+		writeMethodNotAllowed(w, http.MethodPost, http.MethodOptions)
+	}
+}
+
+func revokeTokens(ctx context.Context, app *firebase.App, token *auth.Token, w http.ResponseWriter, r *http.Request) {
+	if !requireJSON(w, r) {
+		return
+	}
+
+	var Body RevokeType
+
+	err := decodeStrict(r.Body, &Body)
+	if err != nil {
+		logDebugf("Unmarshalling json failed %v", err)
+		writeDecodeError(w, err)
+		return
+	}
+	defer r.Body.Close()
+
+	// Without an explicit uid the caller revokes their own sessions
+	if Body.ID == "" {
+		Body.ID = token.UID
+	}
+	if !canModifyUser(token, Body.ID) {
+		writeForbidden(w, "You can only revoke your own sessions")
+		return
+	}
+
+	authClient, err := app.Auth(ctx)
+	if err != nil {
+		logErrorf("error getting Auth client: %v", err)
+		writeInternalError(w)
+		return
+	}
+
+	err = authClient.RevokeRefreshTokens(ctx, Body.ID)
+	if auth.IsUserNotFound(err) {
+		writeNotFound(w, "User not found")
+		return
+	}
+	if err != nil {
+		logErrorf("Revoking refresh tokens failed %v", err)
+		writeInternalError(w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
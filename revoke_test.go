@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestUsersRevokeAPI(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		uid        string
+		body       string
+		wantStatus int
+	}{
+		{"unsupported method", http.MethodGet, "alice", "", http.StatusMethodNotAllowed},
+		{"unauthenticated", http.MethodPost, "", `{"uid": "alice"}`, http.StatusForbidden},
+		{"another user", http.MethodPost, "bob", `{"uid": "alice"}`, http.StatusForbidden},
+		{"unknown field", http.MethodPost, "alice", `{"uid": "alice", "everywhere": true}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeFirebase(t, nil, nil)
+
+			w := serveJSON(UsersRevokeAPI, tt.method, "/users/revoke", tt.uid, tt.body)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
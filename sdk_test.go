@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"talkit.com/client"
+)
+
+// newSDKServer serves the handlers called by the client SDK on store, the
+// tokens sent by the SDK being the uids of the callers.
+func newSDKServer(t *testing.T, store *memoryStore) *httptest.Server {
+	t.Helper()
+
+	fakeFirebase(t, nil, store)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/me", MeAPI)
+	mux.HandleFunc("/users", UsersAPI)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// tokenOf returns a token source authenticating as uid.
+func tokenOf(uid string) client.TokenSource {
+	return func(ctx context.Context) (string, error) {
+		return uid, nil
+	}
+}
+
+func TestSDKGetMe(t *testing.T) {
+	store := newMemoryStore()
+	store.Put(collections.Users, "alice", map[string]interface{}{"uid": "alice", "displayName": "Alice", "price": int64(999)})
+	store.Put(collections.Suscriptions, "alice", map[string]interface{}{"uid": "alice", "cost": int64(150)})
+	readCache.Delete(cacheKey(context.Background(), collections.Users, "alice"))
+	server := newSDKServer(t, store)
+
+	me, err := client.New(server.URL, tokenOf("alice")).GetMe(context.Background())
+	if err != nil {
+		t.Fatalf("GetMe: %v", err)
+	}
+	if me.User.ID != "alice" || me.User.Price != "9.99" {
+		t.Errorf("user = %+v, want alice priced 9.99", me.User)
+	}
+	if me.Subscription == nil || me.Subscription.Cost != "1.50" {
+		t.Errorf("subscription = %+v, want one costing 1.50", me.Subscription)
+	}
+
+	tests := []struct {
+		name       string
+		token      client.TokenSource
+		wantStatus int
+		wantCode   string
+	}{
+		{"missing user", tokenOf("bob"), http.StatusNotFound, "NOT_FOUND"},
+		{"anonymous", nil, http.StatusForbidden, "FORBIDDEN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.New(server.URL, tt.token).GetMe(context.Background())
+
+			var apiErr *client.Error
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.wantStatus || apiErr.Code != tt.wantCode {
+				t.Errorf("GetMe error = %v, want a %d %s", err, tt.wantStatus, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestSDKDeleteUser(t *testing.T) {
+	tests := []struct {
+		name       string
+		caller     string
+		uid        string
+		wantStatus int
+		wantExists bool
+	}{
+		{"own user", "alice", "alice", 0, false},
+		{"another user", "bob", "alice", http.StatusForbidden, true},
+		{"missing user", "carol", "carol", http.StatusNotFound, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemoryStore()
+			store.Put(collections.Users, "alice", map[string]interface{}{"uid": "alice"})
+			server := newSDKServer(t, store)
+
+			err := client.New(server.URL, tokenOf(tt.caller)).DeleteUser(context.Background(), tt.uid)
+			if tt.wantStatus == 0 && err != nil {
+				t.Fatalf("DeleteUser: %v", err)
+			}
+			var apiErr *client.Error
+			if tt.wantStatus != 0 && (!errors.As(err, &apiErr) || apiErr.StatusCode != tt.wantStatus) {
+				t.Errorf("DeleteUser error = %v, want a %d", err, tt.wantStatus)
+			}
+			if _, exists := store.docs[collections.Users+"/alice"]; exists != tt.wantExists {
+				t.Errorf("alice exists = %v, want %v", exists, tt.wantExists)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// userSearchFields maps the user fields a search may filter or order on to
+// their Firestore field names.
+var userSearchFields = map[string]string{
+	"name":  "displayName",
+	"price": "price",
+	"slug":  "slug",
+	"type":  "type",
+	"year":  "year",
+}
+
+// searchOperators lists the Firestore operators a search filter may use.
+var searchOperators = map[string]bool{
+	"==":     true,
+	"!=":     true,
+	"<":      true,
+	"<=":     true,
+	">":      true,
+	">=":     true,
+	"in":     true,
+	"not-in": true,
+}
+
+// SearchFilterType is a single condition of a search query.
+type SearchFilterType struct {
+	Field string      `json:"field"`
+	Op    string      `json:"op"`
+	Value interface{} `json:"value"`
+}
+
+// SearchQueryType is the body accepted by the search endpoints.
+type SearchQueryType struct {
+	Filters []SearchFilterType `json:"filters"`
+	OrderBy string             `json:"orderBy"`
+	Limit   int                `json:"limit"`
+}
+
+// UsersSearchAPI is an HTTP Cloud Function returning the users matching a
+// JSON query object.
+func UsersSearchAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := projectContext(r)
+
+	_, client, ok := initFirebase(w, r)
+	if !ok {
+		return
+	}
+
+	if handleCORS(w, r, http.MethodPost, http.MethodOptions) {
+		return
+	}
+
+	// Bound the Firestore work so a hung call can't block the request
+	ctx, cancel := context.WithTimeout(ctx, firestoreTimeout)
+	defer cancel()
+
+	switch method := r.Method; method {
+	case http.MethodPost:
+		searchUsers(ctx, client, w, r)
+	default:
+		writeMethodNotAllowed(w, http.MethodPost, http.MethodOptions)
+	}
+}
+
+func searchUsers(ctx context.Context, client *firestore.Client, w http.ResponseWriter, r *http.Request) {
+	if !requireJSON(w, r) {
+		return
+	}
+
+	var Body SearchQueryType
+
+	err := decodeStrict(r.Body, &Body)
+	if err != nil {
+		logDebugf("Unmarshalling json failed %v", err)
+		writeDecodeError(w, err)
+		return
+	}
+	defer r.Body.Close()
+
+	query, fieldErr := buildSearchQuery(client.Collection(collections.Users).Query, Body, userSearchFields)
+	if fieldErr != nil {
+		writeFieldError(w, fieldErr)
+		return
+	}
+
+	Users := UsersType{}
+
+	iter := query.Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			writeFirestoreError(w, "Iteration over documents failed", err)
+			return
+		}
+
+		Users = append(Users, formatAmounts(doc.Data()))
+	}
+
+	w.Header().Set("Content-Type", jsonContentType)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": Users,
+	})
+}
+
+// buildSearchQuery applies the filters, ordering and limit of search to
+// query. Fields missing from allowed and unknown operators are rejected.
+func buildSearchQuery(query firestore.Query, search SearchQueryType, allowed map[string]string) (firestore.Query, *FieldError) {
+	for _, filter := range search.Filters {
+		field, ok := allowed[filter.Field]
+		if !ok {
+			return query, &FieldError{Field: filter.Field, Message: fmt.Sprintf("filtering on %q is not allowed", filter.Field)}
+		}
+		if !searchOperators[filter.Op] {
+			return query, &FieldError{Field: filter.Field, Message: fmt.Sprintf("operator %q is not allowed", filter.Op)}
+		}
+		value := filter.Value
+		if containsString(amountFields, field) {
+			var err error
+			if value, err = searchAmount(value); err != nil {
+				return query, &FieldError{Field: filter.Field, Message: err.Error()}
+			}
+		}
+		query = query.Where(field, filter.Op, value)
+	}
+
+	query, err := applySort(query, search.OrderBy, allowed)
+	if err != nil {
+		return query, &FieldError{Field: "orderBy", Message: err.Error()}
+	}
+
+	return query.Limit(clampLimit(search.Limit)), nil
+}
+
+// searchAmount converts the decimal value of an amount filter, or each value
+// of an in filter, into the minor units amounts are stored in.
+func searchAmount(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case float64:
+		return amountFromFloat(v)
+	case []interface{}:
+		amounts := make([]interface{}, len(v))
+		for i, item := range v {
+			amount, err := searchAmount(item)
+			if err != nil {
+				return nil, err
+			}
+			amounts[i] = amount
+		}
+		return amounts, nil
+	default:
+		return nil, errors.New("value must be a number")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+)
+
+func TestBuildSearchQuery(t *testing.T) {
+	tests := []struct {
+		name      string
+		search    SearchQueryType
+		wantField string
+	}{
+		{"no filters", SearchQueryType{}, ""},
+		{"several filters", SearchQueryType{Filters: []SearchFilterType{{"type", "==", "speaker"}, {"year", "in", []interface{}{"2023", "2024"}}}, OrderBy: "-year", Limit: 10}, ""},
+		{"amount filter", SearchQueryType{Filters: []SearchFilterType{{"price", ">=", 9.99}}}, ""},
+		{"disallowed field", SearchQueryType{Filters: []SearchFilterType{{"type", "==", "speaker"}, {"password", "==", "secret"}}}, "password"},
+		{"disallowed operator", SearchQueryType{Filters: []SearchFilterType{{"name", "array-contains", "Alice"}}}, "name"},
+		{"non-numeric amount", SearchQueryType{Filters: []SearchFilterType{{"price", "==", "cheap"}}}, "price"},
+		{"too precise amount", SearchQueryType{Filters: []SearchFilterType{{"price", "==", 9.999}}}, "price"},
+		{"disallowed order", SearchQueryType{OrderBy: "createdAt"}, "orderBy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, fieldErr := buildSearchQuery(firestore.Query{}, tt.search, userSearchFields)
+
+			if tt.wantField == "" {
+				if fieldErr != nil {
+					t.Errorf("buildSearchQuery() = %v, want no error", fieldErr)
+				}
+				return
+			}
+			if fieldErr == nil || fieldErr.Field != tt.wantField {
+				t.Errorf("buildSearchQuery() = %v, want an error on %s", fieldErr, tt.wantField)
+			}
+		})
+	}
+}
+
+func TestSearchAmount(t *testing.T) {
+	tests := []struct {
+		value   interface{}
+		want    interface{}
+		wantErr bool
+	}{
+		{9.99, Amount(999), false},
+		{float64(10), Amount(1000), false},
+		{[]interface{}{9.99, 10.5}, []interface{}{Amount(999), Amount(1050)}, false},
+		{[]interface{}{9.99, "cheap"}, nil, true},
+		{"9.99", nil, true},
+		{nil, nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := searchAmount(tt.value)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && !reflect.DeepEqual(got, tt.want)) {
+			t.Errorf("searchAmount(%v) = %v, %v, want %v, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestUsersSearchAPI(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		wantStatus int
+	}{
+		{"unsupported method", http.MethodGet, "", http.StatusMethodNotAllowed},
+		{"malformed body", http.MethodPost, `{"filters": `, http.StatusBadRequest},
+		{"unknown body field", http.MethodPost, `{"where": []}`, http.StatusBadRequest},
+		{"rejected field", http.MethodPost, `{"filters": [{"field": "password", "op": "==", "value": "secret"}]}`, http.StatusBadRequest},
+		{"rejected operator", http.MethodPost, `{"filters": [{"field": "type", "op": "like", "value": "speak"}]}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeFirebase(t, newOfflineClient(t), nil)
+
+			w := serveJSON(UsersSearchAPI, tt.method, "/users/search", "", tt.body)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
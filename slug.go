@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// errSlugTaken is returned when another document already uses the slug.
+var errSlugTaken = errors.New("slug is already in use")
+
+// slugify derives a slug from name: lowercased, spaces turned into hyphens
+// and anything that isn't a letter, digit or hyphen removed.
+func slugify(name string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(name) {
+		switch {
+		case c < unicode.MaxASCII && (unicode.IsLetter(c) || unicode.IsDigit(c)):
+			b.WriteRune(c)
+		case c == '-' || unicode.IsSpace(c):
+			b.WriteRune('-')
+		}
+	}
+
+	// Collapse the runs of hyphens left by repeated spaces or stripped characters
+	slug := b.String()
+	for strings.Contains(slug, "--") {
+		slug = strings.ReplaceAll(slug, "--", "-")
+	}
+	slug = strings.Trim(slug, "-")
+
+	if len(slug) > maxSlugLength {
+		slug = strings.TrimRight(slug[:maxSlugLength], "-")
+	}
+	return slug
+}
+
+// uniqueSlug returns base, or base with a numeric suffix, such that no
+// document of the collection already uses it.
+func uniqueSlug(ctx context.Context, client *firestore.Client, collection string, base string) (string, error) {
+	candidate := base
+	for i := 2; ; i++ {
+		taken, err := slugTaken(ctx, client, collection, candidate)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return candidate, nil
+		}
+		candidate = slugWithSuffix(base, i)
+	}
+}
+
+// slugWithSuffix appends the numeric suffix n to base, truncating base so the
+// slug still fits in maxSlugLength.
+func slugWithSuffix(base string, n int) string {
+	suffix := "-" + strconv.Itoa(n)
+	if len(base)+len(suffix) > maxSlugLength {
+		base = strings.TrimRight(base[:maxSlugLength-len(suffix)], "-")
+	}
+	return base + suffix
+}
+
+// slugTaken reports whether a document of the collection already uses slug.
+func slugTaken(ctx context.Context, client *firestore.Client, collection string, slug string) (bool, error) {
+	iter := client.Collection(collection).Where("slug", "==", slug).Limit(1).Documents(ctx)
+	defer iter.Stop()
+
+	_, err := iter.Next()
+	if err == iterator.Done {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Alice Liddell", "alice-liddell"},
+		{"  Alice   in  Wonderland ", "alice-in-wonderland"},
+		{"Ünïcode & Friends!", "ncode-friends"},
+		{"--already-a-slug--", "already-a-slug"},
+		{"!!!", ""},
+		{strings.Repeat("a", maxSlugLength+10), strings.Repeat("a", maxSlugLength)},
+		{strings.Repeat("a", maxSlugLength-1) + " b", strings.Repeat("a", maxSlugLength-1)},
+	}
+
+	for _, tt := range tests {
+		if got := slugify(tt.name); got != tt.want {
+			t.Errorf("slugify(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSlugWithSuffix(t *testing.T) {
+	long := strings.Repeat("a", maxSlugLength)
+
+	tests := []struct {
+		base string
+		n    int
+		want string
+	}{
+		{"alice", 2, "alice-2"},
+		{long, 2, strings.Repeat("a", maxSlugLength-2) + "-2"},
+		{long, 10, strings.Repeat("a", maxSlugLength-3) + "-10"},
+		{strings.Repeat("a", maxSlugLength-3) + "-bc", 2, strings.Repeat("a", maxSlugLength-3) + "-2"},
+	}
+
+	for _, tt := range tests {
+		got := slugWithSuffix(tt.base, tt.n)
+		if got != tt.want {
+			t.Errorf("slugWithSuffix(%q, %d) = %q, want %q", tt.base, tt.n, got, tt.want)
+		}
+		if len(got) > maxSlugLength {
+			t.Errorf("slugWithSuffix(%q, %d) is %d long, want at most %d", tt.base, tt.n, len(got), maxSlugLength)
+		}
+	}
+}
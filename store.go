@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// documentStore abstracts the single-document operations handlers make, so
+// tests can run them against memoryStore instead of a Firestore backend.
+// Missing documents are reported with a NotFound status error, like Firestore
+// does.
+type documentStore interface {
+	Get(ctx context.Context, collection string, id string) (map[string]interface{}, error)
+	Update(ctx context.Context, collection string, id string, updates []firestore.Update) error
+	// Delete fails with NotFound when the document doesn't exist
+	Delete(ctx context.Context, collection string, id string) error
+}
+
+// findDocument returns the data of the document, or nil when it doesn't exist.
+func findDocument(ctx context.Context, store documentStore, collection string, id string) (map[string]interface{}, error) {
+	data, err := store.Get(ctx, collection, id)
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	return data, err
+}
+
+// firestoreStore is the documentStore backed by a Firestore client.
+type firestoreStore struct {
+	client *firestore.Client
+}
+
+func newFirestoreStore(client *firestore.Client) documentStore {
+	return firestoreStore{client: client}
+}
+
+// storeFor returns the documentStore handlers use with client. Tests replace
+// it to run handlers against memoryStore.
+var storeFor = newFirestoreStore
+
+func (s firestoreStore) Get(ctx context.Context, collection string, id string) (map[string]interface{}, error) {
+	doc, err := s.client.Collection(collection).Doc(id).Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return doc.Data(), nil
+}
+
+func (s firestoreStore) Update(ctx context.Context, collection string, id string, updates []firestore.Update) error {
+	_, err := s.client.Collection(collection).Doc(id).Update(ctx, updates)
+	return err
+}
+
+func (s firestoreStore) Delete(ctx context.Context, collection string, id string) error {
+	_, err := s.client.Collection(collection).Doc(id).Delete(ctx, firestore.Exists)
+	return err
+}
+
+// copyData makes a shallow copy so callers can't mutate stored documents.
+func copyData(data map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		copied[key] = value
+	}
+	return copied
+}
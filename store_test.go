@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"firebase.google.com/go/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// memoryStore is an in-memory documentStore for unit tests. Updates only
+// support top-level paths and resolve ServerTimestamp to the current time.
+type memoryStore struct {
+	mu   sync.Mutex
+	docs map[string]map[string]interface{}
+	// Err, when set, is returned by every operation to simulate failures
+	Err error
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{docs: map[string]map[string]interface{}{}}
+}
+
+// Put seeds the document with the given data.
+func (s *memoryStore) Put(collection string, id string, data map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.docs[collection+"/"+id] = copyData(data)
+}
+
+func (s *memoryStore) Get(ctx context.Context, collection string, id string) (map[string]interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	data, ok := s.docs[collection+"/"+id]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "%s/%s not found", collection, id)
+	}
+	return copyData(data), nil
+}
+
+func (s *memoryStore) Update(ctx context.Context, collection string, id string, updates []firestore.Update) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Err != nil {
+		return s.Err
+	}
+	data, ok := s.docs[collection+"/"+id]
+	if !ok {
+		return status.Errorf(codes.NotFound, "%s/%s not found", collection, id)
+	}
+	for _, update := range updates {
+		if update.Value == firestore.ServerTimestamp {
+			data[update.Path] = time.Now()
+			continue
+		}
+		data[update.Path] = update.Value
+	}
+	return nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, collection string, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Err != nil {
+		return s.Err
+	}
+	if _, ok := s.docs[collection+"/"+id]; !ok {
+		return status.Errorf(codes.NotFound, "%s/%s not found", collection, id)
+	}
+	delete(s.docs, collection+"/"+id)
+	return nil
+}
+
+// newJSONRequest returns a request carrying body as JSON.
+func newJSONRequest(method string, target string, body string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Content-Type", jsonContentType)
+	return r
+}
+
+func TestFindDocument(t *testing.T) {
+	store := newMemoryStore()
+	store.Put(collections.Users, "alice", map[string]interface{}{"displayName": "Alice"})
+
+	data, err := findDocument(context.Background(), store, collections.Users, "alice")
+	if err != nil || data["displayName"] != "Alice" {
+		t.Errorf("findDocument(alice) = %v, %v, want the document", data, err)
+	}
+
+	data, err = findDocument(context.Background(), store, collections.Users, "bob")
+	if err != nil || data != nil {
+		t.Errorf("findDocument(bob) = %v, %v, want nil, nil", data, err)
+	}
+
+	store.Err = errors.New("backend down")
+	if _, err := findDocument(context.Background(), store, collections.Users, "alice"); err != store.Err {
+		t.Errorf("findDocument error = %v, want %v", err, store.Err)
+	}
+}
+
+func TestDeleteUsers(t *testing.T) {
+	tests := []struct {
+		name       string
+		uid        string
+		body       string
+		err        error
+		wantStatus int
+		wantExists bool
+	}{
+		{"owner", "alice", `{"id": "alice", "confirm": "alice"}`, nil, http.StatusOK, false},
+		{"other user", "bob", `{"id": "alice", "confirm": "alice"}`, nil, http.StatusForbidden, true},
+		{"confirm mismatch", "alice", `{"id": "alice", "confirm": "bob"}`, nil, http.StatusBadRequest, true},
+		{"confirm missing", "alice", `{"id": "alice"}`, nil, http.StatusBadRequest, true},
+		{"unknown field", "alice", `{"id": "alice", "confirm": "alice", "force": true}`, nil, http.StatusBadRequest, true},
+		{"missing user", "carol", `{"id": "carol", "confirm": "carol"}`, nil, http.StatusNotFound, true},
+		{"store failure", "alice", `{"id": "alice", "confirm": "alice"}`, errors.New("backend down"), http.StatusInternalServerError, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemoryStore()
+			store.Put(collections.Users, "alice", map[string]interface{}{"displayName": "Alice"})
+			store.Err = tt.err
+
+			w := httptest.NewRecorder()
+			r := newJSONRequest(http.MethodDelete, "/users", tt.body)
+			deleteUsers(context.Background(), store, &auth.Token{UID: tt.uid}, w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			store.Err = nil
+			if _, ok := store.docs[collections.Users+"/alice"]; ok != tt.wantExists {
+				t.Errorf("alice exists = %v, want %v", ok, tt.wantExists)
+			}
+		})
+	}
+}
+
+func TestDeleteUsersRequiresJSON(t *testing.T) {
+	r := httptest.NewRequest(http.MethodDelete, "/users", strings.NewReader(`{"id": "alice", "confirm": "alice"}`))
+	w := httptest.NewRecorder()
+	deleteUsers(context.Background(), newMemoryStore(), &auth.Token{UID: "alice"}, w, r)
+
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusUnsupportedMediaType)
+	}
+}
+
+func TestPatchUsers(t *testing.T) {
+	tests := []struct {
+		name       string
+		uid        string
+		body       string
+		err        error
+		wantStatus int
+		wantName   string
+	}{
+		{"owner", "alice", `{"ID": "alice", "Name": "Alice Liddell"}`, nil, http.StatusOK, "Alice Liddell"},
+		{"other user", "bob", `{"ID": "alice", "Name": "Bob"}`, nil, http.StatusForbidden, "Alice"},
+		{"blank name", "alice", `{"ID": "alice", "Name": "  "}`, nil, http.StatusBadRequest, "Alice"},
+		{"invalid slug", "alice", `{"ID": "alice", "Slug": "Not A Slug"}`, nil, http.StatusBadRequest, "Alice"},
+		{"missing user", "carol", `{"ID": "carol", "Name": "Carol"}`, nil, http.StatusNotFound, "Alice"},
+		{"store failure", "alice", `{"ID": "alice", "Name": "Alice Liddell"}`, errors.New("backend down"), http.StatusInternalServerError, "Alice"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemoryStore()
+			store.Put(collections.Users, "alice", map[string]interface{}{"uid": "alice", "displayName": "Alice", "slug": "alice"})
+			store.Err = tt.err
+
+			w := httptest.NewRecorder()
+			r := newJSONRequest(http.MethodPatch, "/users", tt.body)
+			patchUsers(context.Background(), store, &auth.Token{UID: tt.uid}, w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			data := store.docs[collections.Users+"/alice"]
+			if data["displayName"] != tt.wantName {
+				t.Errorf("displayName = %v, want %q", data["displayName"], tt.wantName)
+			}
+			if data["slug"] != "alice" {
+				t.Errorf("slug = %v, want it left unchanged", data["slug"])
+			}
+			if _, updated := data["updatedAt"].(time.Time); updated != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("updatedAt = %v, want it set only on success", data["updatedAt"])
+			}
+		})
+	}
+}
+
+func TestPatchUsersPrice(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantPrice  interface{}
+	}{
+		{"negative price", `{"ID": "alice", "Price": -5}`, http.StatusBadRequest, int64(999)},
+		{"smallest negative price", `{"ID": "alice", "Price": -0.01}`, http.StatusBadRequest, int64(999)},
+		{"zero price", `{"ID": "alice", "Price": 0}`, http.StatusOK, Amount(0)},
+		{"positive price", `{"ID": "alice", "Price": 12.5}`, http.StatusOK, Amount(1250)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemoryStore()
+			store.Put(collections.Users, "alice", map[string]interface{}{"uid": "alice", "displayName": "Alice", "price": int64(999)})
+
+			w := httptest.NewRecorder()
+			r := newJSONRequest(http.MethodPatch, "/users", tt.body)
+			patchUsers(context.Background(), store, &auth.Token{UID: "alice"}, w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if price := store.docs[collections.Users+"/alice"]["price"]; price != tt.wantPrice {
+				t.Errorf("price = %#v, want %#v", price, tt.wantPrice)
+			}
+		})
+	}
+}
+
+func TestGetMe(t *testing.T) {
+	tests := []struct {
+		name       string
+		uid        string
+		err        error
+		wantStatus int
+		wantBody   []string
+	}{
+		{"user with a subscription", "alice", nil, http.StatusOK, []string{`"price":9.99`, `"cost":1.50`}},
+		{"user without a subscription", "bob", nil, http.StatusOK, []string{`"uid":"bob"`, `"suscription":null`}},
+		{"missing user", "carol", nil, http.StatusNotFound, nil},
+		{"store failure", "alice", errors.New("backend down"), http.StatusInternalServerError, nil},
+		{"deadline", "alice", status.Error(codes.DeadlineExceeded, "too slow"), http.StatusGatewayTimeout, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemoryStore()
+			store.Put(collections.Users, "alice", map[string]interface{}{"uid": "alice", "price": int64(999)})
+			store.Put(collections.Suscriptions, "alice", map[string]interface{}{"cost": int64(150)})
+			store.Put(collections.Users, "bob", map[string]interface{}{"uid": "bob"})
+			store.Err = tt.err
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/me", nil)
+			getMe(context.Background(), store, &auth.Token{UID: tt.uid}, w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			for _, want := range tt.wantBody {
+				if !strings.Contains(w.Body.String(), want) {
+					t.Errorf("body %s doesn't contain %s", w.Body, want)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdatePresence(t *testing.T) {
+	lastSeen := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		uid          string
+		body         string
+		wantStatus   int
+		wantOnline   interface{}
+		wantLastSeen bool
+	}{
+		{"going online", "alice", `{"online": true}`, http.StatusOK, true, true},
+		{"going offline", "alice", `{"online": false}`, http.StatusOK, false, true},
+		{"explicit uid", "alice", `{"uid": "alice", "online": true}`, http.StatusOK, true, true},
+		{"other user", "bob", `{"uid": "alice", "online": true}`, http.StatusForbidden, false, false},
+		{"missing user", "carol", `{"online": true}`, http.StatusNotFound, false, false},
+		{"unknown field", "alice", `{"away": true}`, http.StatusBadRequest, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemoryStore()
+			store.Put(collections.Users, "alice", map[string]interface{}{"uid": "alice", "online": false, "lastSeen": lastSeen})
+
+			w := httptest.NewRecorder()
+			r := newJSONRequest(http.MethodPut, "/users/presence", tt.body)
+			updatePresence(context.Background(), store, &auth.Token{UID: tt.uid}, w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			data := store.docs[collections.Users+"/alice"]
+			if data["online"] != tt.wantOnline {
+				t.Errorf("online = %v, want %v", data["online"], tt.wantOnline)
+			}
+			if updated := data["lastSeen"] != lastSeen; updated != tt.wantLastSeen {
+				t.Errorf("lastSeen = %v, want it updated %v", data["lastSeen"], tt.wantLastSeen)
+			}
+		})
+	}
+}
+
+// concurrentStore is a memoryStore reporting each read on started and
+// blocking it until release is closed or its context is done. Reads of the
+// failing collection fail right away instead.
+type concurrentStore struct {
+	*memoryStore
+	failing string
+	started chan string
+	release chan struct{}
+}
+
+func (s *concurrentStore) Get(ctx context.Context, collection string, id string) (map[string]interface{}, error) {
+	s.started <- collection
+	if collection == s.failing {
+		return nil, errors.New("backend down")
+	}
+	select {
+	case <-s.release:
+		return s.memoryStore.Get(ctx, collection, id)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(2 * time.Second):
+		return nil, errors.New("the other read never started")
+	}
+}
+
+func TestGetMeConcurrentReads(t *testing.T) {
+	tests := []struct {
+		name       string
+		failing    string
+		wantStatus int
+	}{
+		{"both reads", "", http.StatusOK},
+		{"failing subscription", collections.Suscriptions, http.StatusInternalServerError},
+		{"failing user", collections.Users, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &concurrentStore{memoryStore: newMemoryStore(), failing: tt.failing, started: make(chan string, 2), release: make(chan struct{})}
+			store.Put(collections.Users, "alice", map[string]interface{}{"uid": "alice"})
+
+			// Reads are only released once both have started, which a
+			// sequential getMe would never reach. A failing read isn't
+			// released at all, so the other one must be canceled
+			if tt.failing == "" {
+				go func() {
+					<-store.started
+					<-store.started
+					close(store.release)
+				}()
+			}
+
+			start := time.Now()
+			w := httptest.NewRecorder()
+			getMe(context.Background(), store, &auth.Token{UID: "alice"}, w, httptest.NewRequest(http.MethodGet, "/me", nil))
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("getMe took %v, want concurrent reads canceled on failure", elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,796 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"firebase.google.com/go/auth"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// defaultRenewalDays is the renewal period used when none is given.
+const defaultRenewalDays = 30
+
+// defaultExpiringDays is the window used by the expiring listing when no days
+// are given.
+const defaultExpiringDays = 7
+
+// SubscriptionHistoryType represents a previous state of a Suscription, kept
+// in the history subcollection of the Suscription document.
+type SubscriptionHistoryType struct {
+	Previous  SubscriptionFieldsType `firestore:"previous" json:"previous"`
+	Change    string                 `firestore:"change" json:"change"`
+	ChangedAt time.Time              `firestore:"changedAt,serverTimestamp" json:"changedAt"`
+}
+
+// RenewType represents the body expected structure of a renewal http call
+type RenewType struct {
+	ID   string `json:"uid"`
+	Days int    `json:"days"`
+	// SuscriptionType optionally switches the subscription to another plan
+	SuscriptionType string `json:"suscriptionType"`
+}
+
+// SuscriptionsRenewAPI is an HTTP Cloud Function that extends a subscription.
+func SuscriptionsRenewAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := projectContext(r)
+
+	app, client, ok := initFirebase(w, r)
+	if !ok {
+		return
+	}
+
+	if handleCORS(w, r, http.MethodPost, http.MethodOptions) {
+		return
+	}
+
+	// Bound the Firestore work so a hung call can't block the request
+	ctx, cancel := context.WithTimeout(ctx, firestoreTimeout)
+	defer cancel()
+
+	switch method := r.Method; method {
+	case http.MethodPost:
+		if !authorizeAdmin(w, app, r) {
+			return
+		}
+		renewSuscription(ctx, client, w, r)
+	default:
+		writeMethodNotAllowed(w, http.MethodPost, http.MethodOptions)
+	}
+}
+
+func renewSuscription(ctx context.Context, client *firestore.Client, w http.ResponseWriter, r *http.Request) {
+	if !requireJSON(w, r) {
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		logDebugf("Reading request body failed %v", err)
+		writeBadRequest(w, "Reading the request body failed")
+		return
+	}
+	defer r.Body.Close()
+
+	var Body RenewType
+
+	err = json.Unmarshal(body, &Body)
+	if err != nil {
+		logDebugf("Unmarshalling json failed %v", err)
+		writeDecodeError(w, err)
+		return
+	}
+	if Body.ID == "" {
+		writeBadRequest(w, "uid is required")
+		return
+	}
+	if Body.Days < 0 {
+		writeBadRequest(w, "days must not be negative")
+		return
+	}
+	if Body.Days == 0 {
+		Body.Days = defaultRenewalDays
+	}
+	if Body.SuscriptionType != "" {
+		if fieldErr := validateSuscriptionType(Body.SuscriptionType); fieldErr != nil {
+			writeFieldError(w, fieldErr)
+			return
+		}
+	}
+
+	docRef := client.Collection(collections.Suscriptions).Doc(Body.ID)
+	var renewed SubscriptionFieldsType
+
+	// Read and write inside a transaction so concurrent renewals don't
+	// overwrite each other's extension
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(docRef)
+		if err != nil {
+			return err
+		}
+
+		var suscription SubscriptionFieldsType
+		if err := doc.DataTo(&suscription); err != nil {
+			return err
+		}
+		previous := suscription
+
+		// Extend from the current expiry if it's still in the future
+		base := time.Now()
+		if suscription.ExpireAt.After(base) {
+			base = suscription.ExpireAt
+		}
+		suscription.ExpireAt = base.AddDate(0, 0, Body.Days)
+		suscription.Expired = false
+		if Body.SuscriptionType != "" {
+			suscription.SuscriptionType = Body.SuscriptionType
+		}
+
+		if err := recordSuscriptionHistory(tx, docRef, previous, "renewal"); err != nil {
+			return err
+		}
+
+		renewed = suscription
+		return tx.Set(docRef, &suscription)
+	})
+	readCache.Delete(cacheKey(ctx, collections.Suscriptions, Body.ID))
+	if status.Code(err) == codes.NotFound {
+		writeNotFound(w, "Suscription uid not found")
+		return
+	}
+	if err != nil {
+		writeFirestoreError(w, "Suscription renewal failed", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(renewed)
+}
+
+// SuscriptionsStreamAPI is an HTTP Cloud Function that streams the changes of
+// a subscription as Server-Sent Events.
+func SuscriptionsStreamAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := projectContext(r)
+
+	app, client, ok := initFirebase(w, r)
+	if !ok {
+		return
+	}
+
+	if handleCORS(w, r, http.MethodGet, http.MethodOptions) {
+		return
+	}
+
+	switch method := r.Method; method {
+	case http.MethodGet:
+		token := authorizeRequest(w, app, r)
+		if token == nil {
+			return
+		}
+		streamSuscription(ctx, client, token, w, r)
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodOptions)
+	}
+}
+
+func streamSuscription(ctx context.Context, client *firestore.Client, token *auth.Token, w http.ResponseWriter, r *http.Request) {
+	uid := r.URL.Query().Get("uid")
+	if uid == "" {
+		writeBadRequest(w, "uid query parameter is required")
+		return
+	}
+	if token.UID != uid && !requireClaim(token, "admin") {
+		writeForbidden(w, "You can only follow your own suscription")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		logErrorf("Streaming unsupported by response writer")
+		writeInternalError(w)
+		return
+	}
+
+	// The stream outlives the server write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// The listener is torn down when the client disconnects and ctx is canceled
+	iter := client.Collection(collections.Suscriptions).Doc(uid).Snapshots(ctx)
+	defer iter.Stop()
+
+	for {
+		doc, err := iter.Next()
+		if err != nil {
+			if ctx.Err() == nil {
+				logErrorf("Listening to suscription %s failed %v", uid, err)
+			}
+			return
+		}
+
+		var data []byte
+		if doc.Exists() {
+			var suscription SubscriptionFieldsType
+			if err := doc.DataTo(&suscription); err != nil {
+				logErrorf("Decoding document failed %v", err)
+				continue
+			}
+			data, _ = json.Marshal(suscription)
+		} else {
+			data = []byte("null")
+		}
+
+		fmt.Fprintf(w, "event: suscription\ndata: %s\n\n", data)
+		flusher.Flush()
+	}
+}
+
+// SuscriptionsExpiredAPI is an HTTP Cloud Function that purges expired subscriptions.
+func SuscriptionsExpiredAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := projectContext(r)
+
+	app, client, ok := initFirebase(w, r)
+	if !ok {
+		return
+	}
+
+	if handleCORS(w, r, http.MethodDelete, http.MethodOptions) {
+		return
+	}
+
+	// Bound the Firestore work so a hung call can't block the request
+	ctx, cancel := context.WithTimeout(ctx, firestoreTimeout)
+	defer cancel()
+
+	switch method := r.Method; method {
+	case http.MethodDelete:
+		if !authorizeAdmin(w, app, r) {
+			return
+		}
+		deleteExpiredSuscriptions(ctx, client, w, r)
+	default:
+		writeMethodNotAllowed(w, http.MethodDelete, http.MethodOptions)
+	}
+}
+
+func deleteExpiredSuscriptions(ctx context.Context, client *firestore.Client, w http.ResponseWriter, r *http.Request) {
+	iter := client.Collection(collections.Suscriptions).Where("expired", "==", true).Documents(ctx)
+	defer iter.Stop()
+
+	var jobs []*firestore.BulkWriterJob
+	bw := client.BulkWriter(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			bw.End()
+			writeFirestoreError(w, "Iteration over documents failed", err)
+			return
+		}
+
+		job, err := bw.Delete(doc.Ref)
+		if err != nil {
+			logErrorf("Queueing deletion of %s failed %v", doc.Ref.ID, err)
+			continue
+		}
+		jobs = append(jobs, job)
+		readCache.Delete(cacheKey(ctx, collections.Suscriptions, doc.Ref.ID))
+	}
+	bw.End()
+
+	deleted := 0
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			logErrorf("Document deletion failed %v", err)
+			continue
+		}
+		deleted++
+	}
+
+	w.Header().Set("Content-Type", jsonContentType)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"deleted": deleted,
+	})
+}
+
+// recordSuscriptionHistory stores the previous state of a subscription in its
+// history subcollection as part of the transaction changing it.
+func recordSuscriptionHistory(tx *firestore.Transaction, docRef *firestore.DocumentRef, previous SubscriptionFieldsType, change string) error {
+	entry := SubscriptionHistoryType{
+		Previous: previous,
+		Change:   change,
+	}
+	return tx.Create(docRef.Collection("history").NewDoc(), &entry)
+}
+
+// SuscriptionsHistoryAPI is an HTTP Cloud Function listing the previous states of a subscription.
+func SuscriptionsHistoryAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := projectContext(r)
+
+	app, client, ok := initFirebase(w, r)
+	if !ok {
+		return
+	}
+
+	if handleCORS(w, r, http.MethodGet, http.MethodOptions) {
+		return
+	}
+
+	// Bound the Firestore work so a hung call can't block the request
+	ctx, cancel := context.WithTimeout(ctx, firestoreTimeout)
+	defer cancel()
+
+	switch method := r.Method; method {
+	case http.MethodGet:
+		token := authorizeRequest(w, app, r)
+		if token == nil {
+			return
+		}
+		setPrivateNoStore(w)
+		getSuscriptionHistory(ctx, client, token, w, r)
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodOptions)
+	}
+}
+
+func getSuscriptionHistory(ctx context.Context, client *firestore.Client, token *auth.Token, w http.ResponseWriter, r *http.Request) {
+	uid := r.URL.Query().Get("uid")
+	if uid == "" {
+		writeBadRequest(w, "uid query parameter is required")
+		return
+	}
+	if token.UID != uid && !requireClaim(token, "admin") {
+		writeForbidden(w, "You can only read your own suscription history")
+		return
+	}
+
+	History := []SubscriptionHistoryType{}
+
+	iter := client.Collection(collections.Suscriptions).Doc(uid).Collection("history").OrderBy("changedAt", firestore.Desc).Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			writeFirestoreError(w, "Iteration over documents failed", err)
+			return
+		}
+
+		var entry SubscriptionHistoryType
+		if err := doc.DataTo(&entry); err != nil {
+			logErrorf("Decoding document failed %v", err)
+			writeInternalError(w)
+			return
+		}
+
+		History = append(History, entry)
+	}
+
+	writeJSONWithETag(w, r, History)
+}
+
+// ExpirySummaryType represents the outcome of a subscription expiry sweep
+type ExpirySummaryType struct {
+	Checked int `json:"checked"`
+	Expired int `json:"expired"`
+	Failed  int `json:"failed"`
+}
+
+// expireSubscriptions marks every subscription whose expireAt is in the past
+// as expired and notifies the expiry webhook for each of them.
+func expireSubscriptions(ctx context.Context, client *firestore.Client) (ExpirySummaryType, error) {
+	var summary ExpirySummaryType
+	now := time.Now()
+
+	iter := client.Collection(collections.Suscriptions).Where("expired", "==", false).Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return summary, err
+		}
+		summary.Checked++
+
+		var suscription SubscriptionFieldsType
+		if err := doc.DataTo(&suscription); err != nil {
+			logErrorf("Decoding document %s failed %v", doc.Ref.ID, err)
+			summary.Failed++
+			continue
+		}
+		if suscription.ExpireAt.IsZero() || suscription.ExpireAt.After(now) {
+			continue
+		}
+
+		err = withRetry(ctx, func() error {
+			_, err := doc.Ref.Update(ctx, []firestore.Update{{Path: "expired", Value: true}})
+			return err
+		})
+		readCache.Delete(cacheKey(ctx, collections.Suscriptions, doc.Ref.ID))
+		if err != nil {
+			logErrorf("Expiring suscription %s failed %v", doc.Ref.ID, err)
+			summary.Failed++
+			continue
+		}
+		summary.Expired++
+
+		notifySuscriptionExpired(doc.Ref.ID, suscription.ExpireAt)
+	}
+
+	return summary, nil
+}
+
+// SuscriptionsStatusAPI is an HTTP Cloud Function reporting whether a subscription is active.
+func SuscriptionsStatusAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := projectContext(r)
+
+	app, client, ok := initFirebase(w, r)
+	if !ok {
+		return
+	}
+
+	if handleCORS(w, r, http.MethodGet, http.MethodOptions) {
+		return
+	}
+
+	// Bound the Firestore work so a hung call can't block the request
+	ctx, cancel := context.WithTimeout(ctx, firestoreTimeout)
+	defer cancel()
+
+	switch method := r.Method; method {
+	case http.MethodGet:
+		token := authorizeRequest(w, app, r)
+		if token == nil {
+			return
+		}
+		setPrivateNoStore(w)
+		getSuscriptionStatus(ctx, client, token, w, r)
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodOptions)
+	}
+}
+
+func getSuscriptionStatus(ctx context.Context, client *firestore.Client, token *auth.Token, w http.ResponseWriter, r *http.Request) {
+	uid := r.URL.Query().Get("uid")
+	if uid == "" {
+		writeBadRequest(w, "uid query parameter is required")
+		return
+	}
+	if token.UID != uid && !requireClaim(token, "admin") {
+		writeForbidden(w, "You can only read your own suscription status")
+		return
+	}
+
+	doc, err := client.Collection(collections.Suscriptions).Doc(uid).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		writeNotFound(w, "Suscription uid not found")
+		return
+	}
+	if err != nil {
+		writeFirestoreError(w, "Reading document failed", err)
+		return
+	}
+
+	var suscription SubscriptionFieldsType
+	if err := doc.DataTo(&suscription); err != nil {
+		logErrorf("Decoding document failed %v", err)
+		writeInternalError(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", jsonContentType)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"active":   isSuscriptionActive(suscription, time.Now()),
+		"expireAt": suscription.ExpireAt,
+		"type":     suscription.SuscriptionType,
+	})
+}
+
+// isSuscriptionActive reports whether the subscription is neither flagged as
+// expired nor past its expireAt at the given time.
+func isSuscriptionActive(suscription SubscriptionFieldsType, now time.Time) bool {
+	if suscription.Expired {
+		return false
+	}
+	return suscription.ExpireAt.After(now)
+}
+
+// SuscriptionsExpiringAPI is an HTTP Cloud Function listing the subscriptions
+// that expire within the next days.
+func SuscriptionsExpiringAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := projectContext(r)
+
+	app, client, ok := initFirebase(w, r)
+	if !ok {
+		return
+	}
+
+	if handleCORS(w, r, http.MethodGet, http.MethodOptions) {
+		return
+	}
+
+	// Bound the Firestore work so a hung call can't block the request
+	ctx, cancel := context.WithTimeout(ctx, firestoreTimeout)
+	defer cancel()
+
+	switch method := r.Method; method {
+	case http.MethodGet:
+		if !authorizeAdmin(w, app, r) {
+			return
+		}
+		setPrivateNoStore(w)
+		getExpiringSuscriptions(ctx, client, w, r)
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodOptions)
+	}
+}
+
+func getExpiringSuscriptions(ctx context.Context, client *firestore.Client, w http.ResponseWriter, r *http.Request) {
+	days := defaultExpiringDays
+	if param := r.URL.Query().Get("days"); param != "" {
+		n, err := strconv.Atoi(param)
+		if err != nil || n <= 0 {
+			writeBadRequest(w, "days must be a positive integer")
+			return
+		}
+		days = n
+	}
+
+	now := time.Now()
+	Suscriptions := []SubscriptionFieldsType{}
+
+	iter := client.Collection(collections.Suscriptions).
+		Where("expireAt", ">=", now).
+		Where("expireAt", "<=", now.AddDate(0, 0, days)).
+		OrderBy("expireAt", firestore.Asc).
+		Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			writeFirestoreError(w, "Iteration over documents failed", err)
+			return
+		}
+
+		var suscription SubscriptionFieldsType
+		if err := doc.DataTo(&suscription); err != nil {
+			logErrorf("Decoding document %s failed %v", doc.Ref.ID, err)
+			continue
+		}
+
+		Suscriptions = append(Suscriptions, suscription)
+	}
+
+	w.Header().Set("Content-Type", jsonContentType)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": Suscriptions,
+	})
+}
+
+// errSuscriptionExists is returned when a transfer's destination already has
+// a subscription.
+var errSuscriptionExists = errors.New("destination already has a suscription")
+
+// TransferType represents the body expected structure of a transfer http call
+type TransferType struct {
+	FromID string `json:"fromUid"`
+	ToID   string `json:"toUid"`
+}
+
+// SuscriptionsTransferAPI is an HTTP Cloud Function that moves a subscription
+// from one user to another.
+func SuscriptionsTransferAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := projectContext(r)
+
+	app, client, ok := initFirebase(w, r)
+	if !ok {
+		return
+	}
+
+	if handleCORS(w, r, http.MethodPost, http.MethodOptions) {
+		return
+	}
+
+	// Bound the Firestore work so a hung call can't block the request
+	ctx, cancel := context.WithTimeout(ctx, firestoreTimeout)
+	defer cancel()
+
+	switch method := r.Method; method {
+	case http.MethodPost:
+		if !authorizeAdmin(w, app, r) {
+			return
+		}
+		transferSuscription(ctx, client, w, r)
+	default:
+		writeMethodNotAllowed(w, http.MethodPost, http.MethodOptions)
+	}
+}
+
+func transferSuscription(ctx context.Context, client *firestore.Client, w http.ResponseWriter, r *http.Request) {
+	if !requireJSON(w, r) {
+		return
+	}
+
+	var Body TransferType
+
+	err := decodeStrict(r.Body, &Body)
+	if err != nil {
+		logDebugf("Unmarshalling json failed %v", err)
+		writeDecodeError(w, err)
+		return
+	}
+	defer r.Body.Close()
+
+	if Body.FromID == "" || Body.ToID == "" {
+		writeBadRequest(w, "fromUid and toUid are required")
+		return
+	}
+	if Body.FromID == Body.ToID {
+		writeBadRequest(w, "fromUid and toUid must differ")
+		return
+	}
+
+	fromRef := client.Collection(collections.Suscriptions).Doc(Body.FromID)
+	toRef := client.Collection(collections.Suscriptions).Doc(Body.ToID)
+	var transferred SubscriptionFieldsType
+
+	// Copy and delete in one transaction, so the subscription is never lost
+	// or duplicated and the destination check still holds on commit
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		from, err := tx.Get(fromRef)
+		if err != nil {
+			return err
+		}
+		to, err := tx.Get(toRef)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if to.Exists() {
+			return errSuscriptionExists
+		}
+
+		var suscription SubscriptionFieldsType
+		if err := from.DataTo(&suscription); err != nil {
+			return err
+		}
+		previous := suscription
+		suscription.ID = Body.ToID
+
+		if err := tx.Create(toRef, &suscription); err != nil {
+			return err
+		}
+		if err := recordSuscriptionHistory(tx, toRef, previous, "transfer"); err != nil {
+			return err
+		}
+
+		transferred = suscription
+		return tx.Delete(fromRef)
+	})
+	readCache.Delete(cacheKey(ctx, collections.Suscriptions, Body.FromID))
+	readCache.Delete(cacheKey(ctx, collections.Suscriptions, Body.ToID))
+	if errors.Is(err, errSuscriptionExists) {
+		writeError(w, errorConflict, "toUid already has a suscription", nil)
+		return
+	}
+	if status.Code(err) == codes.NotFound {
+		writeNotFound(w, "Suscription fromUid not found")
+		return
+	}
+	if err != nil {
+		writeFirestoreError(w, "Suscription transfer failed", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(transferred)
+}
+
+// parseLegacyExpireAt reads an expireAt stored as an http.TimeFormat string,
+// as subscriptions were written before it became a timestamp. It returns
+// false for values that aren't strings, which need no migration.
+func parseLegacyExpireAt(value interface{}) (time.Time, bool, error) {
+	legacy, ok := value.(string)
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	expireAt, err := time.Parse(http.TimeFormat, legacy)
+	if err != nil {
+		return time.Time{}, true, err
+	}
+	return expireAt, true, nil
+}
+
+// migrateExpireAt rewrites the expireAt strings of subscriptions, and of the
+// previous states kept in their history, as timestamps. Firestore keeps
+// strings and timestamps apart, so already migrated documents are skipped and
+// the migration can be run again safely.
+func migrateExpireAt(ctx context.Context, client *firestore.Client) (MigrationSummaryType, error) {
+	var summary MigrationSummaryType
+
+	iter := client.Collection(collections.Suscriptions).Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return summary, err
+		}
+
+		migrateExpireAtField(ctx, doc, "expireAt", &summary)
+		readCache.Delete(cacheKey(ctx, collections.Suscriptions, doc.Ref.ID))
+
+		history := doc.Ref.Collection("history").Documents(ctx)
+		for {
+			entry, err := history.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				history.Stop()
+				return summary, err
+			}
+			migrateExpireAtField(ctx, entry, "previous.expireAt", &summary)
+		}
+		history.Stop()
+	}
+
+	return summary, nil
+}
+
+// migrateExpireAtField migrates the expireAt found at path in doc, counting
+// the outcome in summary.
+func migrateExpireAtField(ctx context.Context, doc *firestore.DocumentSnapshot, path string, summary *MigrationSummaryType) {
+	summary.Checked++
+
+	value, err := doc.DataAt(path)
+	if err != nil {
+		return
+	}
+	expireAt, legacy, err := parseLegacyExpireAt(value)
+	if !legacy {
+		return
+	}
+	if err != nil {
+		logErrorf("Parsing %s %s of %s failed %v", path, value, doc.Ref.Path, err)
+		summary.Failed++
+		return
+	}
+
+	err = withRetry(ctx, func() error {
+		_, err := doc.Ref.Update(ctx, []firestore.Update{{Path: path, Value: expireAt}})
+		return err
+	})
+	if err != nil {
+		logErrorf("Migrating %s of %s failed %v", path, doc.Ref.Path, err)
+		summary.Failed++
+		return
+	}
+	summary.Migrated++
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLegacyExpireAt(t *testing.T) {
+	expireAt := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		value      interface{}
+		want       time.Time
+		wantLegacy bool
+		wantErr    bool
+	}{
+		{"http date", "Fri, 01 Mar 2024 10:00:00 GMT", expireAt, true, false},
+		{"timestamp", expireAt, time.Time{}, false, false},
+		{"missing", nil, time.Time{}, false, false},
+		{"malformed", "next week", time.Time{}, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, legacy, err := parseLegacyExpireAt(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if legacy != tt.wantLegacy || !got.Equal(tt.want) {
+				t.Errorf("parseLegacyExpireAt(%v) = %v, %v, want %v, %v", tt.value, got, legacy, tt.want, tt.wantLegacy)
+			}
+		})
+	}
+}
+
+func TestIsSuscriptionActive(t *testing.T) {
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		suscription SubscriptionFieldsType
+		want        bool
+	}{
+		{"expires later", SubscriptionFieldsType{ExpireAt: now.Add(time.Hour)}, true},
+		{"expired flag", SubscriptionFieldsType{Expired: true, ExpireAt: now.Add(time.Hour)}, false},
+		{"past expireAt", SubscriptionFieldsType{ExpireAt: now.Add(-time.Second)}, false},
+		{"expiring now", SubscriptionFieldsType{ExpireAt: now}, false},
+		{"no expireAt", SubscriptionFieldsType{}, false},
+		{"other zone", SubscriptionFieldsType{ExpireAt: now.Add(time.Minute).In(time.FixedZone("ART", -3*60*60))}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSuscriptionActive(tt.suscription, now); got != tt.want {
+				t.Errorf("isSuscriptionActive = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscriptionFieldNames(t *testing.T) {
+	fields := reflect.TypeOf(SubscriptionFieldsType{})
+
+	for i := 0; i < fields.NumField(); i++ {
+		field := fields.Field(i)
+		t.Run(field.Name, func(t *testing.T) {
+			stored := strings.Split(field.Tag.Get("firestore"), ",")[0]
+			sent := strings.Split(field.Tag.Get("json"), ",")[0]
+			if stored == "" || stored != sent {
+				t.Errorf("firestore name %q and json name %q differ", stored, sent)
+			}
+		})
+	}
+}
+
+func TestSuscriptionHandlersValidation(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		method     string
+		target     string
+		uid        string
+		body       string
+		wantStatus int
+	}{
+		{"create with unknown field", SuscriptionsAPI, http.MethodPost, "/suscriptions", "alice", `{"uid": "alice", "discount": 10}`, http.StatusBadRequest},
+		{"create for another user", SuscriptionsAPI, http.MethodPost, "/suscriptions", "alice", `{"uid": "bob", "suscriptionType": "monthly"}`, http.StatusForbidden},
+		{"create without uid by non-admin", SuscriptionsAPI, http.MethodPost, "/suscriptions", "alice", `{"suscriptionType": "monthly"}`, http.StatusForbidden},
+		{"admin create for another user with unknown plan", SuscriptionsAPI, http.MethodPost, "/suscriptions", "admin", `{"uid": "bob", "suscriptionType": "weekly"}`, http.StatusBadRequest},
+		{"create with unknown plan", SuscriptionsAPI, http.MethodPost, "/suscriptions", "alice", `{"uid": "alice", "suscriptionType": "weekly"}`, http.StatusBadRequest},
+		{"update with unknown plan", SuscriptionsAPI, http.MethodPut, "/suscriptions", "admin", `{"uid": "alice", "suscriptionType": "weekly"}`, http.StatusBadRequest},
+		{"renew with GET", SuscriptionsRenewAPI, http.MethodGet, "/suscriptions/renew", "admin", "", http.StatusMethodNotAllowed},
+		{"renew by non-admin", SuscriptionsRenewAPI, http.MethodPost, "/suscriptions/renew", "alice", `{"uid": "alice"}`, http.StatusForbidden},
+		{"renew malformed", SuscriptionsRenewAPI, http.MethodPost, "/suscriptions/renew", "admin", `{"uid": `, http.StatusBadRequest},
+		{"renew without uid", SuscriptionsRenewAPI, http.MethodPost, "/suscriptions/renew", "admin", `{"days": 5}`, http.StatusBadRequest},
+		{"renew negative days", SuscriptionsRenewAPI, http.MethodPost, "/suscriptions/renew", "admin", `{"uid": "alice", "days": -1}`, http.StatusBadRequest},
+		{"renew unknown plan", SuscriptionsRenewAPI, http.MethodPost, "/suscriptions/renew", "admin", `{"uid": "alice", "suscriptionType": "weekly"}`, http.StatusBadRequest},
+		{"stream with POST", SuscriptionsStreamAPI, http.MethodPost, "/suscriptions/stream?uid=alice", "alice", "", http.StatusMethodNotAllowed},
+		{"stream unauthenticated", SuscriptionsStreamAPI, http.MethodGet, "/suscriptions/stream?uid=alice", "", "", http.StatusForbidden},
+		{"stream without uid", SuscriptionsStreamAPI, http.MethodGet, "/suscriptions/stream", "alice", "", http.StatusBadRequest},
+		{"stream of another user", SuscriptionsStreamAPI, http.MethodGet, "/suscriptions/stream?uid=bob", "alice", "", http.StatusForbidden},
+		{"delete expired with GET", SuscriptionsExpiredAPI, http.MethodGet, "/suscriptions/expired", "admin", "", http.StatusMethodNotAllowed},
+		{"delete expired by non-admin", SuscriptionsExpiredAPI, http.MethodDelete, "/suscriptions/expired", "alice", "", http.StatusForbidden},
+		{"history with POST", SuscriptionsHistoryAPI, http.MethodPost, "/suscriptions/history?uid=alice", "alice", "", http.StatusMethodNotAllowed},
+		{"history without uid", SuscriptionsHistoryAPI, http.MethodGet, "/suscriptions/history", "alice", "", http.StatusBadRequest},
+		{"history of another user", SuscriptionsHistoryAPI, http.MethodGet, "/suscriptions/history?uid=bob", "alice", "", http.StatusForbidden},
+		{"transfer with GET", SuscriptionsTransferAPI, http.MethodGet, "/suscriptions/transfer", "admin", "", http.StatusMethodNotAllowed},
+		{"transfer by non-admin", SuscriptionsTransferAPI, http.MethodPost, "/suscriptions/transfer", "alice", `{"fromUid": "alice", "toUid": "bob"}`, http.StatusForbidden},
+		{"transfer without toUid", SuscriptionsTransferAPI, http.MethodPost, "/suscriptions/transfer", "admin", `{"fromUid": "alice"}`, http.StatusBadRequest},
+		{"transfer to the same uid", SuscriptionsTransferAPI, http.MethodPost, "/suscriptions/transfer", "admin", `{"fromUid": "alice", "toUid": "alice"}`, http.StatusBadRequest},
+		{"transfer with unknown field", SuscriptionsTransferAPI, http.MethodPost, "/suscriptions/transfer", "admin", `{"fromUid": "alice", "toUid": "bob", "days": 3}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeFirebase(t, newOfflineClient(t), nil)
+
+			w := serveJSON(tt.handler, tt.method, tt.target, tt.uid, tt.body)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// TalksType represents the Talks collection in the database
+type TalksType []map[string]interface{}
+
+// TalkFieldsType defines the structure of the fields in a Talk from the Talks collection.
+type TalkFieldsType struct {
+	ID          string `firestore:"uid"`
+	Name        string `firestore:"displayName"`
+	Price       Amount `firestore:"price"`
+	Currency    string `firestore:"currency"`
+	Type        string `firestore:"type"`
+	Year        string `firestore:"year"`
+	Image       string `firestore:"image"`
+	Description string `firestore:"description"`
+	Slug        string `firestore:"slug"`
+}
+
+// TalksSearchAPI is an HTTP Cloud Function that searches talks by type, year and price.
+func TalksSearchAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := projectContext(r)
+
+	_, client, ok := initFirebase(w, r)
+	if !ok {
+		return
+	}
+
+	if handleCORS(w, r, http.MethodGet, http.MethodOptions) {
+		return
+	}
+
+	// Bound the Firestore work so a hung call can't block the request
+	ctx, cancel := context.WithTimeout(ctx, firestoreTimeout)
+	defer cancel()
+
+	switch method := r.Method; method {
+	case http.MethodGet:
+		setPublicCache(w)
+		searchTalks(ctx, client, w, r)
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodOptions)
+	}
+}
+
+func searchTalks(ctx context.Context, client *firestore.Client, w http.ResponseWriter, r *http.Request) {
+	Talks := TalksType{}
+
+	// Every filter is optional, without any of them all talks are returned
+	query := client.Collection(collections.Talks).Query
+	if talkType := r.URL.Query().Get("type"); talkType != "" {
+		query = query.Where("type", "==", talkType)
+	}
+	if year := r.URL.Query().Get("year"); year != "" {
+		query = query.Where("year", "==", year)
+	}
+
+	minPrice, hasMin, err := parsePriceParam(r, "minPrice")
+	if err != nil {
+		writeBadRequest(w, "minPrice must be a number with at most 2 decimals")
+		return
+	}
+	maxPrice, hasMax, err := parsePriceParam(r, "maxPrice")
+	if err != nil {
+		writeBadRequest(w, "maxPrice must be a number with at most 2 decimals")
+		return
+	}
+	if hasMin && hasMax && minPrice > maxPrice {
+		writeBadRequest(w, "minPrice must be less than or equal to maxPrice")
+		return
+	}
+	if hasMin {
+		query = query.Where("price", ">=", minPrice)
+	}
+	if hasMax {
+		query = query.Where("price", "<=", maxPrice)
+	}
+
+	sort := r.URL.Query().Get("sort")
+	if err := checkTalkSort(sort, hasMin || hasMax); err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+	query, err = applySort(query, sort, talkSortFields)
+	if err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	if !setTotalCount(ctx, w, r, query) {
+		return
+	}
+	query, ok := applyStartAfter(ctx, client, w, r, collections.Talks, query)
+	if !ok {
+		return
+	}
+
+	limit := parseLimit(r)
+	lastID := ""
+	iter := query.Limit(limit).Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			writeFirestoreError(w, "Iteration over documents failed", err)
+			return
+		}
+
+		Talks = append(Talks, formatAmounts(doc.Data()))
+		lastID = doc.Ref.ID
+	}
+
+	setNextCursor(w, len(Talks), limit, lastID)
+	writeJSONWithETag(w, r, Talks)
+}
+
+// checkTalkSort rejects sorting a price range by another field, since
+// Firestore requires a query to be ordered by its inequality field first.
+func checkTalkSort(sort string, priceRange bool) error {
+	if !priceRange || sort == "" {
+		return nil
+	}
+	if field := strings.TrimPrefix(sort, "-"); field != "price" {
+		return fmt.Errorf("sort must be price or -price when filtering by minPrice or maxPrice, not %s", field)
+	}
+	return nil
+}
+
+// parsePriceParam reads an optional decimal price query parameter into the
+// minor units prices are stored in.
+func parsePriceParam(r *http.Request, name string) (Amount, bool, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, false, nil
+	}
+	price, err := parseAmount(value)
+	if err != nil {
+		return 0, false, err
+	}
+	return price, true, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckTalkSort(t *testing.T) {
+	tests := []struct {
+		sort       string
+		priceRange bool
+		wantErr    bool
+	}{
+		{"", false, false},
+		{"year", false, false},
+		{"", true, false},
+		{"price", true, false},
+		{"-price", true, false},
+		{"year", true, true},
+		{"-name", true, true},
+	}
+
+	for _, tt := range tests {
+		if err := checkTalkSort(tt.sort, tt.priceRange); (err != nil) != tt.wantErr {
+			t.Errorf("checkTalkSort(%q, %v) = %v, want error %v", tt.sort, tt.priceRange, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParsePriceParam(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    Amount
+		wantSet bool
+		wantErr bool
+	}{
+		{"", 0, false, false},
+		{"minPrice=9.99", 999, true, false},
+		{"minPrice=10", 1000, true, false},
+		{"minPrice=9.999", 0, false, true},
+		{"minPrice=cheap", 0, false, true},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/talks/search?"+tt.query, nil)
+		got, set, err := parsePriceParam(r, "minPrice")
+		if (err != nil) != tt.wantErr || got != tt.want || set != tt.wantSet {
+			t.Errorf("parsePriceParam(%q) = %v, %v, %v, want %v, %v, error %v", tt.query, got, set, err, tt.want, tt.wantSet, tt.wantErr)
+		}
+	}
+}
+
+func TestTalksSearchAPI(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		query      string
+		wantStatus int
+	}{
+		{"unsupported method", http.MethodPost, "?type=keynote", http.StatusMethodNotAllowed},
+		{"preflight", http.MethodOptions, "", http.StatusNoContent},
+		{"invalid minPrice", http.MethodGet, "?minPrice=cheap", http.StatusBadRequest},
+		{"too precise maxPrice", http.MethodGet, "?maxPrice=9.999", http.StatusBadRequest},
+		{"inverted range", http.MethodGet, "?minPrice=10&maxPrice=5", http.StatusBadRequest},
+		{"unknown sort", http.MethodGet, "?sort=createdAt", http.StatusBadRequest},
+		{"range sorted by year", http.MethodGet, "?minPrice=1&sort=-year", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeFirebase(t, newOfflineClient(t), nil)
+
+			w := serveJSON(TalksSearchAPI, tt.method, "/talks/search"+tt.query, "", "")
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"firebase.google.com/go/auth"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// userListFilters maps the query parameters accepted by the user listing to
+// the Firestore fields they filter on.
+var userListFilters = map[string]string{
+	"slug": "slug",
+	"type": "type",
+	"year": "year",
+}
+
+// UsersCountAPI is an HTTP Cloud Function returning how many users exist.
+func UsersCountAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := projectContext(r)
+
+	app, client, ok := initFirebase(w, r)
+	if !ok {
+		return
+	}
+
+	if handleCORS(w, r, http.MethodGet, http.MethodOptions) {
+		return
+	}
+
+	// Bound the Firestore work so a hung call can't block the request
+	ctx, cancel := context.WithTimeout(ctx, firestoreTimeout)
+	defer cancel()
+
+	switch method := r.Method; method {
+	case http.MethodGet:
+		if !authorizeAdmin(w, app, r) {
+			return
+		}
+		setPrivateNoStore(w)
+		countUsers(ctx, client, w, r)
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodOptions)
+	}
+}
+
+func countUsers(ctx context.Context, client *firestore.Client, w http.ResponseWriter, r *http.Request) {
+	query := client.Collection(collections.Users).Query
+	if userType := r.URL.Query().Get("type"); userType != "" {
+		query = query.Where("type", "==", userType)
+	}
+
+	count, err := countQuery(ctx, query)
+	if err != nil {
+		writeFirestoreError(w, "Counting documents failed", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", jsonContentType)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"count": count,
+	})
+}
+
+// UsersListAPI is an HTTP Cloud Function returning every user matching the
+// filters, a page at a time.
+func UsersListAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := projectContext(r)
+
+	_, client, ok := initFirebase(w, r)
+	if !ok {
+		return
+	}
+
+	if handleCORS(w, r, http.MethodGet, http.MethodOptions) {
+		return
+	}
+
+	// Bound the Firestore work so a hung call can't block the request
+	ctx, cancel := context.WithTimeout(ctx, firestoreTimeout)
+	defer cancel()
+
+	switch method := r.Method; method {
+	case http.MethodGet:
+		setPublicCache(w)
+		listUsers(ctx, client, w, r)
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodOptions)
+	}
+}
+
+func listUsers(ctx context.Context, client *firestore.Client, w http.ResponseWriter, r *http.Request) {
+	Users := UsersType{}
+
+	fields, fieldErr := parseFields(r, userFieldNames)
+	if fieldErr != nil {
+		writeFieldError(w, fieldErr)
+		return
+	}
+
+	// Every filter is optional, without any of them all users are returned
+	query := client.Collection(collections.Users).Query
+	for param, field := range userListFilters {
+		if value := r.URL.Query().Get(param); value != "" {
+			query = query.Where(field, "==", value)
+		}
+	}
+
+	if !setTotalCount(ctx, w, r, query) {
+		return
+	}
+	query, ok := applyStartAfter(ctx, client, w, r, collections.Users, query)
+	if !ok {
+		return
+	}
+
+	limit := parseLimit(r)
+	lastID := ""
+	iter := query.Limit(limit).Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			writeFirestoreError(w, "Iteration over documents failed", err)
+			return
+		}
+
+		Users = append(Users, projectFields(formatAmounts(doc.Data()), fields))
+		lastID = doc.Ref.ID
+	}
+
+	setNextCursor(w, len(Users), limit, lastID)
+	writeJSONWithETag(w, r, map[string]interface{}{
+		"data": Users,
+	})
+}
+
+// patchUsers applies a partial update with only the fields present in the body.
+func patchUsers(ctx context.Context, store documentStore, token *auth.Token, w http.ResponseWriter, r *http.Request) {
+	if !requireJSON(w, r) {
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		logDebugf("Reading request body failed %v", err)
+		writeBadRequest(w, "Reading the request body failed")
+		return
+	}
+	defer r.Body.Close()
+
+	var Body UsersFieldsType
+
+	err = decodeStrict(bytes.NewReader(body), &Body)
+	if err != nil {
+		logDebugf("Unmarshalling json failed %v", err)
+		writeDecodeError(w, err)
+		return
+	}
+
+	// Decoding again as a map tells which fields the client actually sent
+	var provided map[string]json.RawMessage
+	if err := json.Unmarshal(body, &provided); err != nil {
+		logDebugf("Unmarshalling json failed %v", err)
+		writeDecodeError(w, err)
+		return
+	}
+
+	if !canModifyUser(token, Body.ID) {
+		writeForbidden(w, "You can only modify your own user")
+		return
+	}
+
+	if fieldErr := sanitizeUser(&Body); fieldErr != nil {
+		writeFieldError(w, fieldErr)
+		return
+	}
+
+	updates := userUpdates(Body, provided)
+	if fieldErrs := validateUpdates(&Body, updates); fieldErrs != nil {
+		writeFieldErrors(w, fieldErrs)
+		return
+	}
+	updates = append(updates, firestore.Update{Path: "updatedAt", Value: firestore.ServerTimestamp})
+
+	err = withRetry(ctx, func() error {
+		return store.Update(ctx, collections.Users, Body.ID, updates)
+	})
+	readCache.Delete(cacheKey(ctx, collections.Users, Body.ID))
+	if status.Code(err) == codes.NotFound {
+		writeNotFound(w, "User not found")
+		return
+	}
+	if err != nil {
+		writeFirestoreError(w, "Document update failed", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// userUpdates builds the Firestore updates for the fields of user named in
+// provided. Keys match field names case-insensitively, like encoding/json.
+// The ID and the server-assigned timestamps are never updated.
+func userUpdates(user UsersFieldsType, provided map[string]json.RawMessage) []firestore.Update {
+	var updates []firestore.Update
+
+	v := reflect.ValueOf(user)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		switch field.Name {
+		case "ID", "CreatedAt", "UpdatedAt":
+			continue
+		}
+
+		for key := range provided {
+			if strings.EqualFold(key, field.Name) {
+				path := strings.Split(field.Tag.Get("firestore"), ",")[0]
+				updates = append(updates, firestore.Update{Path: path, Value: v.Field(i).Interface()})
+				break
+			}
+		}
+	}
+
+	return updates
+}
+
+// userData returns the Firestore fields of user. Zero timestamps are left
+// for the server to assign, as their serverTimestamp tags do for structs.
+func userData(user UsersFieldsType) map[string]interface{} {
+	data := map[string]interface{}{}
+
+	v := reflect.ValueOf(user)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		path := strings.Split(t.Field(i).Tag.Get("firestore"), ",")[0]
+		value := v.Field(i).Interface()
+		if timestamp, ok := value.(time.Time); ok && timestamp.IsZero() {
+			value = firestore.ServerTimestamp
+		}
+		data[path] = value
+	}
+
+	return data
+}
+
+// getUsersByIDs returns the users of the comma-separated uids query parameter
+// in request order, fetched in a single round trip. Missing users are null
+// in data and listed in missing.
+func getUsersByIDs(ctx context.Context, client *firestore.Client, w http.ResponseWriter, r *http.Request) {
+	uids := strings.Split(r.URL.Query().Get("uids"), ",")
+	if len(uids) > maxPageSize {
+		writeBadRequest(w, fmt.Sprintf("At most %d uids can be requested at once", maxPageSize))
+		return
+	}
+	if containsString(uids, "") {
+		writeBadRequest(w, "uids must not contain empty values")
+		return
+	}
+
+	fields, fieldErr := parseFields(r, userFieldNames)
+	if fieldErr != nil {
+		writeFieldError(w, fieldErr)
+		return
+	}
+
+	refs := make([]*firestore.DocumentRef, len(uids))
+	for i, uid := range uids {
+		refs[i] = client.Collection(collections.Users).Doc(uid)
+	}
+
+	docs, err := client.GetAll(ctx, refs)
+	if err != nil {
+		writeFirestoreError(w, "Fetching users failed", err)
+		return
+	}
+
+	Users := UsersType{}
+	missing := []string{}
+	for i, doc := range docs {
+		if !doc.Exists() {
+			Users = append(Users, nil)
+			missing = append(missing, uids[i])
+			continue
+		}
+		Users = append(Users, projectFields(formatAmounts(doc.Data()), fields))
+	}
+
+	writeJSONWithETag(w, r, map[string]interface{}{
+		"data":    Users,
+		"missing": missing,
+	})
+}
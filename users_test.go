@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+func TestUserData(t *testing.T) {
+	createdAt := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	data := userData(UsersFieldsType{ID: "alice", Name: "Alice", Price: 999, CreatedAt: createdAt})
+
+	want := map[string]interface{}{
+		"uid":         "alice",
+		"displayName": "Alice",
+		"price":       Amount(999),
+		"createdAt":   createdAt,
+		"updatedAt":   firestore.ServerTimestamp,
+	}
+	for field, value := range want {
+		if data[field] != value {
+			t.Errorf("%s = %v, want %v", field, data[field], value)
+		}
+	}
+	for _, field := range presenceFields {
+		if _, ok := data[field]; ok {
+			t.Errorf("%s is set, want it left to the presence endpoint", field)
+		}
+	}
+}
+
+func TestUsersCountAPI(t *testing.T) {
+	tests := []struct {
+		name       string
+		uid        string
+		wantStatus int
+	}{
+		{"unauthenticated", "", http.StatusForbidden},
+		{"not admin", "alice", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeFirebase(t, newOfflineClient(t), nil)
+
+			w := serveJSON(UsersCountAPI, http.MethodGet, "/users/count", tt.uid, "")
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
+
+func TestUsersListAPI(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		wantStatus int
+	}{
+		{"unknown projected field", http.MethodGet, "/users/list?fields=uid,password", http.StatusBadRequest},
+		{"unsupported method", http.MethodPost, "/users/list", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeFirebase(t, newOfflineClient(t), nil)
+
+			w := serveJSON(UsersListAPI, tt.method, tt.target, "", "")
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
+
+func TestSetUsersValidation(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{"missing name", `{"Price": 5}`, http.StatusBadRequest},
+		{"blank name", `{"Name": "   "}`, http.StatusBadRequest},
+		{"negative price", `{"Name": "Bob", "Price": -0.01}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeFirebase(t, newOfflineClient(t), nil)
+
+			w := serveJSON(UsersAPI, http.MethodPost, "/users", "bob", tt.body)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
+
+func TestGetUsersByIDsValidation(t *testing.T) {
+	previous := maxPageSize
+	maxPageSize = 2
+	t.Cleanup(func() { maxPageSize = previous })
+
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+	}{
+		{"too many uids", "/users?uids=alice,bob,carol", http.StatusBadRequest},
+		{"empty uid", "/users?uids=alice,,bob", http.StatusBadRequest},
+		{"trailing comma", "/users?uids=alice,", http.StatusBadRequest},
+		{"unknown projected field", "/users?uids=alice&fields=password", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeFirebase(t, newOfflineClient(t), nil)
+
+			w := serveJSON(UsersAPI, http.MethodGet, tt.target, "", "")
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+}
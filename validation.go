@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"cloud.google.com/go/firestore"
+	"github.com/go-playground/validator/v10"
+)
+
+// Maximum lengths, in characters, of the user text fields
+const (
+	maxNameLength        = 100
+	maxSlugLength        = 100
+	maxDescriptionLength = 5000
+)
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// suscriptionTypes lists the accepted values of suscriptionType.
+var suscriptionTypes = []string{"free-trial", "monthly", "annual"}
+
+// FieldError describes an invalid field of a request body.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("invalid field %s: %s", e.Field, e.Message)
+}
+
+// sanitizeUser trims the user text fields and checks their length and format,
+// along with the price not being negative and its currency.
+func sanitizeUser(user *UsersFieldsType) *FieldError {
+	user.Name = strings.TrimSpace(user.Name)
+	user.Slug = strings.TrimSpace(user.Slug)
+	user.Description = strings.TrimSpace(user.Description)
+
+	if utf8.RuneCountInString(user.Name) > maxNameLength {
+		return &FieldError{Field: "displayName", Message: fmt.Sprintf("must be at most %d characters", maxNameLength)}
+	}
+	if utf8.RuneCountInString(user.Slug) > maxSlugLength {
+		return &FieldError{Field: "slug", Message: fmt.Sprintf("must be at most %d characters", maxSlugLength)}
+	}
+	if user.Slug != "" && !slugPattern.MatchString(user.Slug) {
+		return &FieldError{Field: "slug", Message: "must only contain lowercase letters, digits and hyphens"}
+	}
+	if utf8.RuneCountInString(user.Description) > maxDescriptionLength {
+		return &FieldError{Field: "description", Message: fmt.Sprintf("must be at most %d characters", maxDescriptionLength)}
+	}
+	if user.Price < 0 {
+		return &FieldError{Field: "price", Message: "must not be negative"}
+	}
+	if fieldErr := sanitizeCurrency(&user.Currency); fieldErr != nil {
+		return fieldErr
+	}
+
+	return nil
+}
+
+// validate runs the validate struct tags of request bodies. Fields are
+// reported by their Firestore names, like sanitizeUser does, or by their JSON
+// names for bodies that aren't stored.
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		if name := strings.Split(field.Tag.Get("firestore"), ",")[0]; name != "" {
+			return name
+		}
+		return strings.Split(field.Tag.Get("json"), ",")[0]
+	})
+	return v
+}
+
+// validateStruct checks the validate tags of v, returning a FieldError for
+// every field that fails them.
+func validateStruct(v interface{}) []*FieldError {
+	var validationErrs validator.ValidationErrors
+	if !errors.As(validate.Struct(v), &validationErrs) {
+		return nil
+	}
+
+	fieldErrs := make([]*FieldError, len(validationErrs))
+	for i, validationErr := range validationErrs {
+		fieldErrs[i] = &FieldError{Field: validationErr.Field(), Message: validationMessage(validationErr)}
+	}
+	return fieldErrs
+}
+
+// validateUpdates checks the validate tags of the fields of v that updates
+// change, along with the uid naming the document. The fields left out of a
+// partial update keep their stored values, so their tags don't apply.
+func validateUpdates(v interface{}, updates []firestore.Update) []*FieldError {
+	checked := map[string]bool{"uid": true}
+	for _, update := range updates {
+		checked[update.Path] = true
+	}
+
+	var fieldErrs []*FieldError
+	for _, fieldErr := range validateStruct(v) {
+		if checked[fieldErr.Field] {
+			fieldErrs = append(fieldErrs, fieldErr)
+		}
+	}
+	return fieldErrs
+}
+
+// validationMessage describes the validate tag a field failed.
+func validationMessage(validationErr validator.FieldError) string {
+	switch validationErr.Tag() {
+	case "required":
+		return "is required"
+	case "gte":
+		return "must be greater than or equal to " + validationErr.Param()
+	default:
+		return fmt.Sprintf("must satisfy %s=%s", validationErr.Tag(), validationErr.Param())
+	}
+}
+
+// validateSuscriptionType checks suscriptionType against the allowed types.
+func validateSuscriptionType(suscriptionType string) *FieldError {
+	for _, allowed := range suscriptionTypes {
+		if suscriptionType == allowed {
+			return nil
+		}
+	}
+	return &FieldError{
+		Field:   "suscriptionType",
+		Message: "must be one of " + strings.Join(suscriptionTypes, ", "),
+	}
+}
+
+// writeFieldError writes the 400 error envelope naming the offending field.
+func writeFieldError(w http.ResponseWriter, fieldErr *FieldError) {
+	writeError(w, errorBadRequest, fieldErr.Error(), map[string]interface{}{
+		"field": fieldErr.Field,
+	})
+}
+
+// writeFieldErrors writes the 400 error envelope listing every invalid field.
+func writeFieldErrors(w http.ResponseWriter, fieldErrs []*FieldError) {
+	fields := make([]map[string]interface{}, len(fieldErrs))
+	for i, fieldErr := range fieldErrs {
+		fields[i] = map[string]interface{}{
+			"field":   fieldErr.Field,
+			"message": fieldErr.Message,
+		}
+	}
+
+	writeError(w, errorBadRequest, "Request body has invalid fields", map[string]interface{}{
+		"fields": fields,
+	})
+}
+
+// requireJSON checks that the request body is declared as JSON. It returns
+// false after writing a 415 response otherwise.
+func requireJSON(w http.ResponseWriter, r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err == nil && mediaType == "application/json" {
+		return true
+	}
+
+	writeError(w, errorUnsupportedMediaType, "", nil)
+	return false
+}
+
+// decodeStrict decodes a JSON body into v, failing on fields v doesn't define.
+func decodeStrict(body io.Reader, v interface{}) error {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// unknownFieldError converts the unknown field error of a strict decode into
+// a FieldError. It returns nil for any other error.
+func unknownFieldError(err error) *FieldError {
+	const prefix = "json: unknown field "
+	if err == nil || !strings.HasPrefix(err.Error(), prefix) {
+		return nil
+	}
+	field := strings.Trim(strings.TrimPrefix(err.Error(), prefix), `"`)
+	return &FieldError{Field: field, Message: "unknown field"}
+}
+
+// writeDecodeError writes the 400 error envelope explaining why a JSON body
+// couldn't be decoded, with the offending field or offset when known.
+func writeDecodeError(w http.ResponseWriter, err error) {
+	if fieldErr := unknownFieldError(err); fieldErr != nil {
+		writeFieldError(w, fieldErr)
+		return
+	}
+
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		writeError(w, errorBadRequest, "Malformed JSON: "+e.Error(), map[string]interface{}{
+			"offset": e.Offset,
+		})
+	case *json.UnmarshalTypeError:
+		writeError(w, errorBadRequest, fmt.Sprintf("invalid field %s: must be %s, got %s", e.Field, e.Type, e.Value), map[string]interface{}{
+			"field":  e.Field,
+			"offset": e.Offset,
+		})
+	default:
+		switch {
+		case errors.Is(err, io.EOF):
+			writeBadRequest(w, "Request body is empty")
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			writeBadRequest(w, "Request body is truncated")
+		default:
+			writeBadRequest(w, "Invalid request body: "+err.Error())
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeUser(t *testing.T) {
+	tests := []struct {
+		name      string
+		user      UsersFieldsType
+		want      UsersFieldsType
+		wantField string
+	}{
+		{
+			name: "trimmed",
+			user: UsersFieldsType{Name: "  Alice ", Slug: " alice ", Description: "\tHi\n", Currency: " usd "},
+			want: UsersFieldsType{Name: "Alice", Slug: "alice", Description: "Hi", Currency: "USD"},
+		},
+		{
+			name: "default currency",
+			user: UsersFieldsType{Name: "Alice"},
+			want: UsersFieldsType{Name: "Alice", Currency: defaultCurrency},
+		},
+		{
+			name: "multibyte name at the limit",
+			user: UsersFieldsType{Name: strings.Repeat("ñ", maxNameLength)},
+			want: UsersFieldsType{Name: strings.Repeat("ñ", maxNameLength), Currency: defaultCurrency},
+		},
+		{name: "long name", user: UsersFieldsType{Name: strings.Repeat("a", maxNameLength+1)}, wantField: "displayName"},
+		{name: "long slug", user: UsersFieldsType{Slug: strings.Repeat("a", maxSlugLength+1)}, wantField: "slug"},
+		{name: "invalid slug", user: UsersFieldsType{Slug: "Alice Liddell"}, wantField: "slug"},
+		{name: "long description", user: UsersFieldsType{Description: strings.Repeat("a", maxDescriptionLength+1)}, wantField: "description"},
+		{name: "negative price", user: UsersFieldsType{Price: -1}, wantField: "price"},
+		{name: "invalid currency", user: UsersFieldsType{Currency: "dollars"}, wantField: "currency"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := tt.user
+			fieldErr := sanitizeUser(&user)
+
+			if tt.wantField != "" {
+				if fieldErr == nil || fieldErr.Field != tt.wantField {
+					t.Errorf("sanitizeUser error = %v, want one on %s", fieldErr, tt.wantField)
+				}
+				return
+			}
+			if fieldErr != nil {
+				t.Fatalf("sanitizeUser: %v", fieldErr)
+			}
+			if user != tt.want {
+				t.Errorf("sanitized user = %+v, want %+v", user, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteDecodeError(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantMessage string
+		wantData    map[string]interface{}
+	}{
+		{"empty", "", "Request body is empty", nil},
+		{"truncated", `{"Name": "Alice"`, "Request body is truncated", nil},
+		{"syntax", `{"Name": }`, "Malformed JSON: invalid character '}' looking for beginning of value",
+			map[string]interface{}{"offset": float64(10)}},
+		{"wrong type", `{"Name": 42}`, "invalid field Name: must be string, got number",
+			map[string]interface{}{"field": "Name", "offset": float64(11)}},
+		{"unknown field", `{"Nickname": "Al"}`, "invalid field Nickname: unknown field",
+			map[string]interface{}{"field": "Nickname"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var user UsersFieldsType
+			err := decodeStrict(strings.NewReader(tt.body), &user)
+			if err == nil {
+				t.Fatalf("decodeStrict(%q) succeeded, want an error", tt.body)
+			}
+			w := httptest.NewRecorder()
+
+			writeDecodeError(w, err)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+			}
+			envelope := decodeEnvelope(t, w)
+			if envelope["message"] != tt.wantMessage {
+				t.Errorf("message = %q, want %q", envelope["message"], tt.wantMessage)
+			}
+			data, _ := envelope["data"].(map[string]interface{})
+			for key, want := range tt.wantData {
+				if data[key] != want {
+					t.Errorf("data[%s] = %v, want %v", key, data[key], want)
+				}
+			}
+		})
+	}
+}
+
+func TestRequireJSON(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        bool
+	}{
+		{"application/json", true},
+		{jsonContentType, true},
+		{"Application/JSON", true},
+		{"", false},
+		{"text/plain", false},
+		{"application/x-www-form-urlencoded", false},
+		{"application/jsonp", false},
+		{"application/json; charset", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(`{}`))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+
+			if got := requireJSON(w, r); got != tt.want {
+				t.Errorf("requireJSON(%q) = %v, want %v", tt.contentType, got, tt.want)
+			}
+			if !tt.want && w.Code != http.StatusUnsupportedMediaType {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusUnsupportedMediaType)
+			}
+		})
+	}
+}
+
+func TestValidateSuscriptionType(t *testing.T) {
+	tests := []struct {
+		suscriptionType string
+		wantErr         bool
+	}{
+		{"free-trial", false},
+		{"monthly", false},
+		{"annual", false},
+		{"weekly", true},
+		{"Monthly", true},
+		{"", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.suscriptionType, func(t *testing.T) {
+			fieldErr := validateSuscriptionType(tt.suscriptionType)
+
+			if (fieldErr != nil) != tt.wantErr {
+				t.Fatalf("validateSuscriptionType(%q) = %v, want error %v", tt.suscriptionType, fieldErr, tt.wantErr)
+			}
+			if fieldErr != nil && fieldErr.Field != "suscriptionType" {
+				t.Errorf("field = %q, want suscriptionType", fieldErr.Field)
+			}
+		})
+	}
+}
+
+func TestValidateStruct(t *testing.T) {
+	tests := []struct {
+		name string
+		user UsersFieldsType
+		want string
+	}{
+		{"valid", UsersFieldsType{ID: "alice", Name: "Alice", Price: 999}, ""},
+		{"free", UsersFieldsType{ID: "alice", Name: "Alice"}, ""},
+		{"missing required fields", UsersFieldsType{Price: 999}, "uid is required; displayName is required"},
+		{"negative price", UsersFieldsType{ID: "alice", Name: "Alice", Price: -1}, "price must be greater than or equal to 0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var messages []string
+			for _, fieldErr := range validateStruct(&tt.user) {
+				messages = append(messages, fieldErr.Field+" "+fieldErr.Message)
+			}
+			if got := strings.Join(messages, "; "); got != tt.want {
+				t.Errorf("validateStruct() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// webhookAttempts is the number of deliveries tried before giving up.
+const webhookAttempts = 3
+
+// webhookInitialBackoff is the wait after the first failed delivery, doubled
+// after each further failure.
+var webhookInitialBackoff = time.Second
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// SuscriptionExpiredEvent is the payload posted to the webhook when a
+// subscription expires.
+type SuscriptionExpiredEvent struct {
+	ID        string    `json:"uid"`
+	ExpiredAt time.Time `json:"expiredAt"`
+}
+
+// notifySuscriptionExpired posts the expiry event to the SUBSCRIPTION_WEBHOOK_URL
+// webhook, if configured. Delivery happens in the background so the caller is
+// never blocked by a slow or failing receiver.
+func notifySuscriptionExpired(uid string, expiredAt time.Time) {
+	url := os.Getenv("SUBSCRIPTION_WEBHOOK_URL")
+	if url == "" {
+		return
+	}
+
+	event := SuscriptionExpiredEvent{ID: uid, ExpiredAt: expiredAt}
+	go func() {
+		if err := deliverWebhook(url, event); err != nil {
+			logErrorf("Webhook delivery for %s failed %v", uid, err)
+		}
+	}()
+}
+
+// deliverWebhook posts payload as JSON to url, retrying failed attempts with
+// exponential backoff.
+func deliverWebhook(url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	backoff := webhookInitialBackoff
+	for attempt := 1; ; attempt++ {
+		err = postWebhook(url, body)
+		if err == nil || attempt == webhookAttempts {
+			return err
+		}
+
+		logWarnf("Webhook attempt %d to %s failed %v", attempt, url, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func postWebhook(url string, body []byte) error {
+	resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDeliverWebhook(t *testing.T) {
+	backoff := webhookInitialBackoff
+	webhookInitialBackoff = time.Millisecond
+	t.Cleanup(func() { webhookInitialBackoff = backoff })
+
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantAttempts int
+		wantErr      bool
+	}{
+		{"delivered", []int{http.StatusOK}, 1, false},
+		{"no content", []int{http.StatusNoContent}, 1, false},
+		{"retried until delivered", []int{http.StatusServiceUnavailable, http.StatusOK}, 2, false},
+		{"redirect is a failure", []int{http.StatusFound, http.StatusFound, http.StatusOK}, 3, false},
+		{"gives up", []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusOK}, webhookAttempts, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var attempts int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+					t.Errorf("request = %s with Content-Type %q, want a JSON POST", r.Method, r.Header.Get("Content-Type"))
+				}
+				w.WriteHeader(tt.statuses[attempts])
+				attempts++
+			}))
+			defer server.Close()
+
+			err := deliverWebhook(server.URL, SuscriptionExpiredEvent{ID: "alice"})
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("deliverWebhook error = %v, want error %v", err, tt.wantErr)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestNotifySuscriptionExpired(t *testing.T) {
+	events := make(chan SuscriptionExpiredEvent, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event SuscriptionExpiredEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("Decoding webhook payload: %v", err)
+		}
+		events <- event
+	}))
+	defer server.Close()
+	t.Setenv("SUBSCRIPTION_WEBHOOK_URL", server.URL)
+
+	expiredAt := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	notifySuscriptionExpired("alice", expiredAt)
+
+	select {
+	case event := <-events:
+		if event.ID != "alice" || !event.ExpiredAt.Equal(expiredAt) {
+			t.Errorf("webhook payload = %+v, want alice expired at %v", event, expiredAt)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not delivered")
+	}
+}
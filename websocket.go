@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// wsWriteTimeout bounds how long a single frame write may take.
+const wsWriteTimeout = 10 * time.Second
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	// CORS is open on every other route, so the socket accepts any origin too
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// ChatsWebSocketAPI upgrades the request to a WebSocket and streams the new
+// messages of a chat as they are written to Firestore. Typing frames sent by
+// a client are relayed to the other participants.
+func ChatsWebSocketAPI(w http.ResponseWriter, r *http.Request) {
+	ctx := projectContext(r)
+
+	app, client, ok := initFirebase(w, r)
+	if !ok {
+		return
+	}
+
+	// Browsers can't set headers on a WebSocket handshake, so the token may
+	// also be sent as a query parameter
+	if r.Header.Get("Authorization") == "" {
+		r.Header.Set("Authorization", r.URL.Query().Get("token"))
+	}
+	token := authorizeRequest(w, app, r)
+	if token == nil {
+		return
+	}
+
+	chatID := mux.Vars(r)["chatId"]
+
+	// Check the participants while a plain HTTP error can still be sent
+	checkCtx, cancelCheck := context.WithTimeout(ctx, firestoreTimeout)
+	allowed := authorizeChat(checkCtx, storeFor(client), token, chatID, w)
+	cancelCheck()
+	if !allowed {
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logWarnf("WebSocket upgrade failed %v", err)
+		return
+	}
+	defer conn.Close()
+
+	// The server read/write timeouts still apply to the hijacked connection
+	conn.SetReadDeadline(time.Time{})
+
+	c := &chatConn{conn: conn, uid: token.UID}
+	hub.join(chatID, c)
+	defer hub.leave(chatID, c)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Reading is needed to notice the client going away
+	go func() {
+		defer cancel()
+		readChatFrames(c, chatID)
+	}()
+
+	streamChatMessages(ctx, client, c, chatID)
+}
+
+// readChatFrames handles the frames sent by the client until it disconnects.
+func readChatFrames(c *chatConn, chatID string) {
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var frame ChatFrameType
+		if err := json.Unmarshal(data, &frame); err != nil {
+			logDebugf("Unmarshalling chat frame failed %v", err)
+			continue
+		}
+
+		// A socket only relays events for the chat it was opened on
+		if frame.Type == "typing" && frame.ChatID == chatID {
+			hub.broadcast(chatID, c, TypingEventType{Type: "typing", ChatID: chatID, UID: c.uid})
+		}
+	}
+}
+
+func streamChatMessages(ctx context.Context, client *firestore.Client, c *chatConn, chatID string) {
+	iter := client.Collection(collections.Messages).Where("chatId", "==", chatID).Snapshots(ctx)
+	defer iter.Stop()
+
+	initial := true
+	for {
+		snap, err := iter.Next()
+		if err != nil {
+			if ctx.Err() == nil {
+				logErrorf("Listening to chat %s failed %v", chatID, err)
+			}
+			return
+		}
+
+		// The first snapshot holds the existing history, only new messages are pushed
+		if initial {
+			initial = false
+			continue
+		}
+
+		for _, change := range snap.Changes {
+			if change.Kind != firestore.DocumentAdded {
+				continue
+			}
+
+			var message MessageFieldsType
+			if err := change.Doc.DataTo(&message); err != nil {
+				logErrorf("Decoding message failed %v", err)
+				continue
+			}
+			message.ID = change.Doc.Ref.ID
+
+			if err := c.writeJSON(message); err != nil {
+				logWarnf("Writing to chat %s socket failed %v", chatID, err)
+				return
+			}
+		}
+	}
+}
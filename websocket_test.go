@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// newChatServer serves the chat WebSocket with chat1 between alice and bob.
+func newChatServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	store := newMemoryStore()
+	store.Put(collections.Chats, "chat1", map[string]interface{}{"participants": []interface{}{"alice", "bob"}})
+	fakeFirebase(t, newOfflineClient(t), store)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/ws/chats/{chatId}", ChatsWebSocketAPI)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// dialChat opens the chat WebSocket as uid, sending the token as the query
+// parameter browsers have to use.
+func dialChat(server *httptest.Server, chatID string, uid string) (*websocket.Conn, *http.Response, error) {
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/chats/" + chatID + "?token=" + uid
+	return websocket.DefaultDialer.Dial(url, nil)
+}
+
+// waitForPeers waits until n connections joined the chat on the hub.
+func waitForPeers(t *testing.T, chatID string, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		hub.mu.Lock()
+		joined := len(hub.chats[chatID])
+		hub.mu.Unlock()
+		if joined >= n {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("%d connections never joined %s", n, chatID)
+}
+
+func TestChatsWebSocketAPIHandshake(t *testing.T) {
+	server := newChatServer(t)
+
+	tests := []struct {
+		name       string
+		chatID     string
+		uid        string
+		wantStatus int
+	}{
+		{"participant", "chat1", "alice", http.StatusSwitchingProtocols},
+		{"unauthenticated", "chat1", "", http.StatusForbidden},
+		{"outsider", "chat1", "mallory", http.StatusForbidden},
+		{"unknown chat", "chat2", "alice", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, resp, err := dialChat(server, tt.chatID, tt.uid)
+			if conn != nil {
+				conn.Close()
+			}
+			if resp == nil {
+				t.Fatalf("Dial failed without a response: %v", err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("handshake status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestChatsWebSocketAPITyping(t *testing.T) {
+	server := newChatServer(t)
+
+	alice, _, err := dialChat(server, "chat1", "alice")
+	if err != nil {
+		t.Fatalf("Dialing as alice: %v", err)
+	}
+	defer alice.Close()
+	bob, _, err := dialChat(server, "chat1", "bob")
+	if err != nil {
+		t.Fatalf("Dialing as bob: %v", err)
+	}
+	defer bob.Close()
+	waitForPeers(t, "chat1", 2)
+
+	frames := []struct {
+		name  string
+		frame string
+	}{
+		{"not JSON", `typing`},
+		{"other chat", `{"type": "typing", "chatId": "chat2"}`},
+		{"other type", `{"type": "read", "chatId": "chat1"}`},
+		{"typing", `{"type": "typing", "chatId": "chat1"}`},
+	}
+	for _, f := range frames {
+		if err := alice.WriteMessage(websocket.TextMessage, []byte(f.frame)); err != nil {
+			t.Fatalf("Sending %s frame: %v", f.name, err)
+		}
+	}
+
+	// Only the last frame is relayed, and never back to its sender
+	bob.SetReadDeadline(time.Now().Add(time.Second))
+	var event TypingEventType
+	if err := bob.ReadJSON(&event); err != nil {
+		t.Fatalf("Reading bob's event: %v", err)
+	}
+	if want := (TypingEventType{Type: "typing", ChatID: "chat1", UID: "alice"}); event != want {
+		t.Errorf("bob received %+v, want %+v", event, want)
+	}
+	bob.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, data, err := bob.ReadMessage(); err == nil {
+		t.Errorf("bob received another event %s", data)
+	}
+	alice.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, data, err := alice.ReadMessage(); err == nil {
+		t.Errorf("alice received her own event %s", data)
+	}
+}